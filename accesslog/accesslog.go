@@ -0,0 +1,294 @@
+// Package accesslog writes one line per grpc and grpc-web call proxied by the gateway, with the method, the resolved
+// backend, the status, the durations, the traffic in each direction, the peer and the identity of the caller.
+package accesslog
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	FormatJson   = "json"
+	FormatLogfmt = "logfmt"
+
+	ProtocolGrpc    = "grpc"
+	ProtocolGrpcWeb = "grpc-web"
+)
+
+// Config the settings of the access log.
+type Config struct {
+	// Enabled whether to write the access log.
+	Enabled bool
+	// Format "json" or "logfmt". default is "json".
+	Format string
+	// File the file to write to, rotated by MaxSizeMb, MaxBackups and MaxAgeDays. default is the stdout.
+	File string
+	// MaxSizeMb the size in megabytes of the file to be rotated at. default is 100.
+	MaxSizeMb int
+	// MaxBackups the number of the rotated files to keep, 0 to keep all of them.
+	MaxBackups int
+	// MaxAgeDays the days to keep the rotated files, 0 to keep them regardless of the age.
+	MaxAgeDays int
+	// Compress whether to gzip the rotated files.
+	Compress bool
+	// SampleRatio the ratio of the successful calls to be logged, 0 to 1, 0 logs none. the failed ones are always
+	// logged. default is 1.
+	SampleRatio float64
+}
+
+// FieldsFunc returns the fields of the call known after the authentication, e.g. the user and the request id.
+type FieldsFunc func(ctx context.Context) logrus.Fields
+
+// Logger the access logger, which is the stats.Handler of the grpc servers collecting the calls.
+type Logger struct {
+	log         *logrus.Logger
+	closer      io.Closer
+	sampleRatio float64
+}
+
+// New creates the access logger writing to the file of the config, or the stdout.
+func New(cfg Config) (*Logger, error) {
+	var out io.Writer = os.Stdout
+	var closer io.Closer
+	if cfg.File != "" {
+		lj := &lumberjack.Logger{
+			Filename:   cfg.File,
+			MaxSize:    cfg.MaxSizeMb,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAgeDays,
+			Compress:   cfg.Compress,
+		}
+		out, closer = lj, lj
+	}
+	l, err := newLogger(cfg, out)
+	if err != nil {
+		return nil, err
+	}
+	l.closer = closer
+	return l, nil
+}
+
+func newLogger(cfg Config, out io.Writer) (*Logger, error) {
+	log := logrus.New()
+	log.SetOutput(out)
+	log.SetLevel(logrus.InfoLevel)
+	switch cfg.Format {
+	case "", FormatJson:
+		log.SetFormatter(&logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano})
+	case FormatLogfmt:
+		log.SetFormatter(&logrus.TextFormatter{DisableColors: true, FullTimestamp: true, TimestampFormat: time.RFC3339Nano})
+	default:
+		return nil, fmt.Errorf("unknown access log format %q", cfg.Format)
+	}
+	// the ratio of 0 logs none of the successful calls, the default of 1 is of the config.
+	return &Logger{log: log, sampleRatio: math.Min(math.Max(cfg.SampleRatio, 0), 1)}, nil
+}
+
+// Close closes the file of the access log if any.
+func (l *Logger) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
+
+type recordKey struct{}
+type protocolKey struct{}
+
+// record the call being logged, updated by the stats handlers of both the server and the backend connection, and the
+// director of the reverse proxy.
+type record struct {
+	sync.Mutex
+	method       string
+	protocol     string
+	peer         string
+	start        time.Time
+	endpoint     string
+	backendAddr  string
+	backendStart time.Time
+	backendDur   time.Duration
+	recvBytes    int
+	sentBytes    int
+	recvMsgs     int
+	sentMsgs     int
+	fields       logrus.Fields
+}
+
+func recordFromContext(ctx context.Context) *record {
+	r, _ := ctx.Value(recordKey{}).(*record)
+	return r
+}
+
+// WithProtocol marks the requests served by the grpc server of the protocol other than the native grpc, e.g. the
+// grpc-web ones.
+func WithProtocol(ctx context.Context, protocol string) context.Context {
+	return context.WithValue(ctx, protocolKey{}, protocol)
+}
+
+// SetEndpoint records the endpoint the call is resolved to, if the call is logged.
+func SetEndpoint(ctx context.Context, endpoint string) {
+	if r := recordFromContext(ctx); r != nil {
+		r.Lock()
+		r.endpoint = endpoint
+		r.Unlock()
+	}
+}
+
+func (l *Logger) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	r := &record{method: info.FullMethodName, protocol: ProtocolGrpc, start: time.Now()}
+	if p, ok := ctx.Value(protocolKey{}).(string); ok {
+		r.protocol = p
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		r.peer = p.Addr.String()
+	}
+	return context.WithValue(ctx, recordKey{}, r)
+}
+
+func (l *Logger) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	r := recordFromContext(ctx)
+	if r == nil {
+		return
+	}
+	switch s := s.(type) {
+	case *stats.InPayload:
+		r.Lock()
+		r.recvBytes += s.WireLength
+		r.recvMsgs++
+		r.Unlock()
+	case *stats.OutPayload:
+		r.Lock()
+		r.sentBytes += s.WireLength
+		r.sentMsgs++
+		r.Unlock()
+	case *stats.End:
+		l.write(r, s)
+	}
+}
+
+func (l *Logger) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (l *Logger) HandleConn(context.Context, stats.ConnStats) {}
+
+func (l *Logger) write(r *record, end *stats.End) {
+	st := status.Convert(end.Error)
+	if end.Error == nil && l.sampleRatio < 1 && rand.Float64() >= l.sampleRatio {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	fields := logrus.Fields{
+		"method":      r.method,
+		"protocol":    r.protocol,
+		"peer":        r.peer,
+		"code":        st.Code().String(),
+		"duration_ms": durationMs(end.EndTime.Sub(r.start)),
+		"recv_bytes":  r.recvBytes,
+		"sent_bytes":  r.sentBytes,
+		"recv_msgs":   r.recvMsgs,
+		"sent_msgs":   r.sentMsgs,
+	}
+	if r.endpoint != "" {
+		fields["endpoint"] = r.endpoint
+	}
+	if r.backendAddr != "" {
+		fields["backend_addr"] = r.backendAddr
+		fields["backend_duration_ms"] = durationMs(r.backendDur)
+	}
+	if end.Error != nil {
+		fields["error"] = st.Message()
+	}
+	for k, v := range r.fields {
+		fields[k] = v
+	}
+	l.log.WithFields(fields).Info("access")
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// StreamServerInterceptor returns the interceptor adding the fields of the fn to the access log of the call. It is to be
+// chained after the request id one and before the authentication, so as the rejected calls are logged with the request
+// id, and again after the authentication for the identity of the caller. the fields of the both are merged.
+func StreamServerInterceptor(fn FieldsFunc) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		addFields(ss.Context(), fn)
+		return handler(srv, ss)
+	}
+}
+
+// UnaryServerInterceptor is the unary counterpart of StreamServerInterceptor.
+func UnaryServerInterceptor(fn FieldsFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		addFields(ctx, fn)
+		return handler(ctx, req)
+	}
+}
+
+func addFields(ctx context.Context, fn FieldsFunc) {
+	r := recordFromContext(ctx)
+	if r == nil {
+		return
+	}
+	fields := fn(ctx)
+	r.Lock()
+	if r.fields == nil {
+		r.fields = logrus.Fields{}
+	}
+	for k, v := range fields {
+		r.fields[k] = v
+	}
+	r.Unlock()
+}
+
+// BackendStatsHandler the stats.Handler of the backend connections, recording the address of the backend instance
+// serving the call and the duration of the backend call.
+type BackendStatsHandler struct{}
+
+func (BackendStatsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (BackendStatsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	r := recordFromContext(ctx)
+	if r == nil {
+		return
+	}
+	switch s := s.(type) {
+	case *stats.Begin:
+		r.Lock()
+		r.backendStart = s.BeginTime
+		r.Unlock()
+	case *stats.OutHeader:
+		if s.RemoteAddr != nil {
+			r.Lock()
+			r.backendAddr = s.RemoteAddr.String()
+			r.Unlock()
+		}
+	case *stats.End:
+		r.Lock()
+		r.backendDur = s.EndTime.Sub(r.backendStart)
+		r.Unlock()
+	}
+}
+
+func (BackendStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (BackendStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
@@ -0,0 +1,146 @@
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/stats"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func startHealthServer(t *testing.T, opts ...grpc.ServerOption) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(opts...)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+// syncBuffer the buffer written by the server goroutines and read by the test.
+type syncBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.Lock()
+	defer b.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+func TestLogger(t *testing.T) {
+	out := &syncBuffer{}
+	l, err := newLogger(Config{SampleRatio: 1}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the fields of the interceptors before and after the authentication are merged.
+	requestId := func(ctx context.Context) logrus.Fields {
+		return logrus.Fields{"request_id": "r1"}
+	}
+	fields := func(ctx context.Context) logrus.Fields {
+		return logrus.Fields{"user": "alice"}
+	}
+	addr := startHealthServer(t, grpc.StatsHandler(l),
+		grpc.ChainUnaryInterceptor(UnaryServerInterceptor(requestId), UnaryServerInterceptor(fields)))
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_, _ = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{Service: "unknown"})
+
+	// the stats of the end are handled after the response is sent.
+	deadline := time.Now().Add(time.Second)
+	for len(out.Bytes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	var line map[string]interface{}
+	if err = json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("expected a JSON line, but got %q: %v", out.Bytes(), err)
+	}
+	expected := map[string]interface{}{
+		"method":     "/grpc.health.v1.Health/Check",
+		"protocol":   ProtocolGrpc,
+		"code":       "NotFound",
+		"user":       "alice",
+		"request_id": "r1",
+		"recv_msgs":  float64(1),
+		"sent_msgs":  float64(0),
+	}
+	for k, v := range expected {
+		if line[k] != v {
+			t.Errorf("expected %v of %v, but got %v", k, v, line[k])
+		}
+	}
+	if line["recv_bytes"].(float64) <= 0 || !strings.HasPrefix(line["peer"].(string), "127.0.0.1:") {
+		t.Errorf("expected the bytes received and the peer, but got %v", line)
+	}
+}
+
+func TestBackendStatsHandler(t *testing.T) {
+	out := &bytes.Buffer{}
+	l, err := newLogger(Config{Format: FormatLogfmt, SampleRatio: 1}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startHealthServer(t)
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithStatsHandler(BackendStatsHandler{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	ctx := l.TagRPC(WithProtocol(context.Background(), ProtocolGrpcWeb), &stats.RPCTagInfo{FullMethodName: "/grpc.health.v1.Health/Check"})
+	SetEndpoint(ctx, "health")
+	if _, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	l.HandleRPC(ctx, &stats.End{EndTime: time.Now()})
+	for _, expected := range []string{"protocol=grpc-web", "endpoint=health", "backend_addr=\"" + addr + "\"", "code=OK"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected %v in the line, but got %q", expected, out.String())
+		}
+	}
+}
+
+func TestLoggerSampling(t *testing.T) {
+	out := &bytes.Buffer{}
+	l, err := newLogger(Config{SampleRatio: 0}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := l.TagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: "/a.B/C"})
+	for i := 0; i < 100; i++ {
+		l.HandleRPC(ctx, &stats.End{EndTime: time.Now()})
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected the successful calls to be sampled out, but got %q", out.String())
+	}
+	l.HandleRPC(ctx, &stats.End{EndTime: time.Now(), Error: context.DeadlineExceeded})
+	if !strings.Contains(out.String(), `"code":"Unknown"`) {
+		t.Errorf("expected the failed call to be logged, but got %q", out.String())
+	}
+	if _, err = newLogger(Config{Format: "xml"}, out); err == nil {
+		t.Errorf("expected error of unknown format, but got nil")
+	}
+}
@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus/ctxlogrus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultApiKeyHeader the default metadata key of the API key.
+const DefaultApiKeyHeader = "x-api-key"
+
+const apiKeyHashPrefix = "sha256:"
+
+var apiKeyRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "grpc_gateway_api_key_requests_total",
+	Help: "Total number of the requests authenticated by API key, by the key label and the result.",
+}, []string{"label", "result"})
+
+func init() {
+	prometheus.MustRegister(apiKeyRequests)
+}
+
+// ApiKeyConfig the settings of the API key authentication.
+type ApiKeyConfig struct {
+	// Enabled whether to authenticate the requests by the API key in the Header metadata.
+	Enabled bool
+	// Optional whether to pass the requests without the API key as unauthenticated. the invalid keys are rejected anyway.
+	Optional bool
+	// Header the metadata key of the API key, default is "x-api-key". the key is not forwarded to the backends.
+	Header string
+	// File the YAML file of the hashed API keys with their labels, scopes and quotas, see ApiKeyEntry.
+	File string
+	// ReloadInterval the interval to check the File for changes, e.g. "30s". default is 0, which disables the check.
+	// the File is reloaded on SIGHUP anyway. the shortest interval is used if the listeners sharing the File set different ones.
+	ReloadInterval time.Duration
+	// LabelMetadata the metadata key forwarding the label of the API key to the backends, e.g. "x-api-key-label".
+	// the label is not forwarded if empty, and the value supplied by the clients is dropped anyway.
+	LabelMetadata string
+}
+
+// ApiKeyEntry an API key in the File, e.g.
+//
+//	keys:
+//	  - label: partner-acme
+//	    # sha256 of the key in hex, e.g. by `echo -n $KEY | sha256sum`
+//	    hash: sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//	    scopes: [public:read]
+//	    quota:
+//	      requestsPerSecond: 10
+//	      burst: 20
+type ApiKeyEntry struct {
+	// Label identifies the key in the logs, the metrics, the authorization and the rate limiting.
+	Label  string      `yaml:"label"`
+	Hash   string      `yaml:"hash"`
+	Scopes []string    `yaml:"scopes"`
+	Quota  ApiKeyQuota `yaml:"quota"`
+}
+
+// ApiKeyQuota the rate limit of the requests of an API key, unlimited if RequestsPerSecond is 0.
+type ApiKeyQuota struct {
+	RequestsPerSecond float64 `yaml:"requestsPerSecond"`
+	// Burst the maximum requests at once, default is the ceiling of RequestsPerSecond.
+	Burst int `yaml:"burst"`
+}
+
+type apiKey struct {
+	ApiKeyEntry
+	limiter *rate.Limiter
+}
+
+// ApiKeyStore the hashed API keys loaded from the file, which may be shared by the authenticators.
+type ApiKeyStore struct {
+	sync.RWMutex
+	file string
+	// keys by the hash.
+	keys map[string]*apiKey
+}
+
+// NewApiKeyStore loads the API keys from the file.
+func NewApiKeyStore(file string) (*ApiKeyStore, error) {
+	s := &ApiKeyStore{file: file}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// HashApiKey returns the hash of the API key in the File.
+func HashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return apiKeyHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// Reload loads the API keys from the file again. The loaded keys are kept if it fails, and the rate limits of the keys
+// having the same label and quota are kept.
+func (s *ApiKeyStore) Reload() error {
+	data, err := os.ReadFile(s.file)
+	if err != nil {
+		return err
+	}
+	var f struct {
+		Keys []ApiKeyEntry `yaml:"keys"`
+	}
+	if err = yaml.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("invalid API key file %v: %v", s.file, err)
+	}
+	s.RLock()
+	byLabel := map[string]*apiKey{}
+	for _, k := range s.keys {
+		byLabel[k.Label] = k
+	}
+	s.RUnlock()
+	keys := map[string]*apiKey{}
+	labels := map[string]struct{}{}
+	for _, e := range f.Keys {
+		if e.Label == "" {
+			return fmt.Errorf("invalid API key file %v: label is required", s.file)
+		}
+		if _, ok := labels[e.Label]; ok {
+			return fmt.Errorf("invalid API key file %v: duplicated label %v", s.file, e.Label)
+		}
+		labels[e.Label] = struct{}{}
+		hash := strings.ToLower(e.Hash)
+		if b, err := hex.DecodeString(strings.TrimPrefix(hash, apiKeyHashPrefix)); err != nil || len(b) != sha256.Size ||
+			!strings.HasPrefix(hash, apiKeyHashPrefix) {
+			return fmt.Errorf("invalid API key file %v: the hash of %v is not in the form of sha256:{hex}", s.file, e.Label)
+		}
+		if dup, ok := keys[hash]; ok {
+			return fmt.Errorf("invalid API key file %v: duplicated hash of %v and %v", s.file, dup.Label, e.Label)
+		}
+		k := &apiKey{ApiKeyEntry: e}
+		if old, ok := byLabel[e.Label]; ok && old.Quota == e.Quota {
+			k.limiter = old.limiter
+		} else if e.Quota.RequestsPerSecond > 0 {
+			burst := e.Quota.Burst
+			if burst <= 0 {
+				burst = int(e.Quota.RequestsPerSecond + 0.999)
+			}
+			k.limiter = rate.NewLimiter(rate.Limit(e.Quota.RequestsPerSecond), burst)
+		}
+		keys[hash] = k
+	}
+	s.Lock()
+	s.keys = keys
+	s.Unlock()
+	return nil
+}
+
+func (s *ApiKeyStore) lookup(key string) (*apiKey, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	k, ok := s.keys[HashApiKey(key)]
+	return k, ok
+}
+
+// ApiKeyAuthenticator authenticates the requests by the API key in the metadata.
+type ApiKeyAuthenticator struct {
+	cfg   ApiKeyConfig
+	store *ApiKeyStore
+}
+
+// NewApiKeyAuthenticator returns the authenticator by the keys of the store.
+func NewApiKeyAuthenticator(cfg ApiKeyConfig, store *ApiKeyStore) (*ApiKeyAuthenticator, error) {
+	if store == nil {
+		return nil, errors.New("the API key store is required")
+	}
+	if cfg.Header == "" {
+		cfg.Header = DefaultApiKeyHeader
+	}
+	cfg.Header = strings.ToLower(cfg.Header)
+	cfg.LabelMetadata = strings.ToLower(cfg.LabelMetadata)
+	return &ApiKeyAuthenticator{cfg: cfg, store: store}, nil
+}
+
+func (a *ApiKeyAuthenticator) InjectedMetadata() []string {
+	if a.cfg.LabelMetadata == "" {
+		return nil
+	}
+	return []string{a.cfg.LabelMetadata}
+}
+
+func (a *ApiKeyAuthenticator) Authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	md = md.Copy()
+	values := md.Get(a.cfg.Header)
+	delete(md, a.cfg.Header)
+	if a.cfg.LabelMetadata != "" {
+		delete(md, a.cfg.LabelMetadata)
+	}
+	ctx = metadata.NewIncomingContext(ctx, md)
+	if len(values) == 0 {
+		if a.cfg.Optional {
+			return ctx, nil
+		}
+		return nil, status.Errorf(codes.Unauthenticated, "missing API key in %v", a.cfg.Header)
+	}
+	k, ok := a.store.lookup(values[0])
+	if !ok {
+		apiKeyRequests.WithLabelValues("", "invalid").Inc()
+		return nil, status.Error(codes.Unauthenticated, "invalid API key")
+	}
+	ctxlogrus.AddFields(ctx, logrus.Fields{"auth.api_key": k.Label})
+	if k.limiter != nil && !k.limiter.Allow() {
+		apiKeyRequests.WithLabelValues(k.Label, "quota_exceeded").Inc()
+		return nil, status.Errorf(codes.ResourceExhausted, "quota of the API key %v exceeded", k.Label)
+	}
+	apiKeyRequests.WithLabelValues(k.Label, "authenticated").Inc()
+	if a.cfg.LabelMetadata != "" {
+		md.Set(a.cfg.LabelMetadata, k.Label)
+	}
+	p := principalForUpdate(ctx)
+	p.ApiKey = k.Label
+	p.Scopes = append(append([]string(nil), p.Scopes...), k.Scopes...)
+	if p.Subject == "" {
+		p.Subject = k.Label
+	}
+	return NewContext(ctx, p), nil
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeApiKeyFile(t *testing.T, file string, content string) {
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApiKeyAuthenticator(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys.yaml")
+	writeApiKeyFile(t, file, `
+keys:
+  - label: acme
+    hash: `+HashApiKey("secret")+`
+    scopes: [public:read]
+  - label: limited
+    hash: `+HashApiKey("limited")+`
+    quota: {requestsPerSecond: 0.001, burst: 1}
+`)
+	store, err := NewApiKeyStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewApiKeyAuthenticator(ApiKeyConfig{LabelMetadata: "x-api-key-label"}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate := func(md metadata.MD) (context.Context, error) {
+		return a.Authenticate(metadata.NewIncomingContext(context.Background(), md))
+	}
+
+	ctx, err := authenticate(metadata.Pairs("x-api-key", "secret", "x-api-key-label", "spoofed"))
+	if err != nil {
+		t.Fatalf("expected authenticated, but got %v", err)
+	}
+	p, _ := FromContext(ctx)
+	if p == nil || p.ApiKey != "acme" || p.Subject != "acme" || !reflect.DeepEqual(p.Scopes, []string{"public:read"}) {
+		t.Errorf("expected principal of acme, but got %+v", p)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if len(md.Get("x-api-key")) != 0 || !reflect.DeepEqual(md.Get("x-api-key-label"), []string{"acme"}) {
+		t.Errorf("expected the key dropped and the label forwarded, but got %v", md)
+	}
+
+	if _, err = authenticate(metadata.Pairs("x-api-key", "wrong")); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated of invalid key, but got %v", err)
+	}
+	if _, err = authenticate(metadata.MD{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated of missing key, but got %v", err)
+	}
+	if _, err = authenticate(metadata.Pairs("x-api-key", "limited")); err != nil {
+		t.Errorf("expected authenticated within quota, but got %v", err)
+	}
+	if _, err = authenticate(metadata.Pairs("x-api-key", "limited")); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted, but got %v", err)
+	}
+
+	// invalid file keeps the loaded keys, and the rate limits survive the reloads.
+	writeApiKeyFile(t, file, "keys:\n  - label: broken\n    hash: md5:abc\n")
+	if err = store.Reload(); err == nil {
+		t.Errorf("expected error of invalid hash, but got nil")
+	}
+	if _, err = authenticate(metadata.Pairs("x-api-key", "secret")); err != nil {
+		t.Errorf("expected the loaded keys kept, but got %v", err)
+	}
+	writeApiKeyFile(t, file, "keys:\n  - label: a\n    hash: "+HashApiKey("same")+"\n  - label: b\n    hash: "+HashApiKey("same")+"\n")
+	if err = store.Reload(); err == nil {
+		t.Errorf("expected error of duplicated hash, but got nil")
+	}
+	writeApiKeyFile(t, file, `
+keys:
+  - label: limited
+    hash: `+HashApiKey("limited")+`
+    quota: {requestsPerSecond: 0.001, burst: 1}
+`)
+	if err = store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = authenticate(metadata.Pairs("x-api-key", "secret")); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated of removed key, but got %v", err)
+	}
+	if _, err = authenticate(metadata.Pairs("x-api-key", "limited")); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected the rate limit kept, but got %v", err)
+	}
+}
+
+func TestChainOptional(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys.yaml")
+	writeApiKeyFile(t, file, "keys:\n  - label: acme\n    hash: "+HashApiKey("secret")+"\n")
+	store, err := NewApiKeyStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := NewApiKeyAuthenticator(ApiKeyConfig{Optional: true}, store)
+	chain := Chain{a}
+	ctx, err := chain.Authenticate(metadata.NewIncomingContext(context.Background(), metadata.MD{}))
+	if err != nil {
+		t.Fatalf("expected passed without key, but got %v", err)
+	}
+	if _, ok := FromContext(ctx); ok {
+		t.Errorf("expected no principal, but got one")
+	}
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Authenticator authenticates the requests per their incoming metadata.
+type Authenticator interface {
+	// Authenticate returns the context of the authenticated request, carrying the Principal and the incoming metadata
+	// to be forwarded to the backends, or the error with the status code UNAUTHENTICATED.
+	Authenticate(ctx context.Context) (context.Context, error)
+}
+
+// MetadataInjector is implemented by the authenticators forwarding the identities of the callers to the backends as
+// the metadata, whose values supplied by the clients are dropped.
+type MetadataInjector interface {
+	// InjectedMetadata returns the metadata keys set by the authenticator.
+	InjectedMetadata() []string
+}
+
+// InjectedMetadata returns the metadata keys set by the authenticator, none if it's nil or sets none.
+func InjectedMetadata(a Authenticator) []string {
+	if mi, ok := a.(MetadataInjector); ok {
+		return mi.InjectedMetadata()
+	}
+	return nil
+}
+
+// Chain authenticates the requests by all the authenticators in order, e.g. by both the JWT and the API key. An optional
+// authenticator passes the requests without its credentials, so the requests carrying either of the credentials are
+// accepted if all of them are optional, which may be required by the authorization policies.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(ctx context.Context) (context.Context, error) {
+	for _, a := range c {
+		var err error
+		if ctx, err = a.Authenticate(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return ctx, nil
+}
+
+func (c Chain) InjectedMetadata() []string {
+	var keys []string
+	for _, a := range c {
+		keys = append(keys, InjectedMetadata(a)...)
+	}
+	return keys
+}
+
+// StreamServerInterceptor returns the interceptor authenticating the streams, including the proxied ones of grpc-web.
+func StreamServerInterceptor(a Authenticator) grpc.StreamServerInterceptor {
+	return grpc_auth.StreamServerInterceptor(a.Authenticate)
+}
+
+// UnaryServerInterceptor returns the interceptor authenticating the unary calls.
+func UnaryServerInterceptor(a Authenticator) grpc.UnaryServerInterceptor {
+	return grpc_auth.UnaryServerInterceptor(a.Authenticate)
+}
+
+// HTTPHandler returns the handler authenticating the HTTP requests by their headers before serving them by next, which
+// are then the metadata returned by the Authenticator. The rejected requests are responded with the JSON encoded
+// google.rpc.Status.
+func HTTPHandler(a Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		md := metadata.MD{}
+		for k, vs := range r.Header {
+			md.Append(strings.ToLower(k), vs...)
+		}
+		ctx := metadata.NewIncomingContext(r.Context(), md)
+		if _, ok := peer.FromContext(ctx); !ok {
+			ctx = peer.NewContext(ctx, peerOfHTTPRequest(r))
+		}
+		ctx, err := a.Authenticate(ctx)
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		md, _ = metadata.FromIncomingContext(ctx)
+		r = r.WithContext(ctx)
+		r.Header = http.Header{}
+		for k, vs := range md {
+			for _, v := range vs {
+				r.Header.Add(k, v)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	code := http.StatusInternalServerError
+	switch st.Code() {
+	case codes.Unauthenticated:
+		code = http.StatusUnauthorized
+	case codes.PermissionDenied:
+		code = http.StatusForbidden
+	}
+	b, _ := protojson.Marshal(st.Proto())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(b)
+}
+
+// peerOfHTTPRequest returns the peer of the remote address and the TLS connection state of the request.
+func peerOfHTTPRequest(r *http.Request) *peer.Peer {
+	p := &peer.Peer{}
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		p.Addr = addr
+	}
+	if r.TLS != nil {
+		p.AuthInfo = credentials.TLSInfo{State: *r.TLS, CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity}}
+	}
+	return p
+}
@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"path"
+)
+
+const (
+	// EffectAllow allows the calls matched by the policy.
+	EffectAllow = "allow"
+	// EffectDeny denies the calls matched by the policy.
+	EffectDeny = "deny"
+)
+
+// AuthorizationConfig the settings of the authorization of the calls by the policies.
+type AuthorizationConfig struct {
+	// Enabled whether to authorize the calls by the Policies.
+	Enabled bool
+	// DryRun whether to only log the calls which would be denied, without denying them, e.g. for rolling out the policies.
+	DryRun bool
+	// DefaultEffect the effect on the calls matched by none of the Policies, "allow" or "deny". default is "deny".
+	DefaultEffect string
+	// Policies evaluated in order, the first one matching the call decides.
+	Policies []PolicyConfig
+}
+
+// PolicyConfig a policy matching the calls by the methods and the conditions, all of which are required if set.
+type PolicyConfig struct {
+	// Name of the policy, used in the logs.
+	Name string
+	// Effect on the matched calls, "allow" or "deny".
+	Effect string
+	// Methods the globs of the full method names, e.g. "/com.example.admin.*/*". all the methods if empty.
+	Methods []string
+	// Authenticated whether the caller is required to be authenticated.
+	Authenticated bool
+	// Subjects the globs of the subject of the authenticated caller, e.g. the "sub" claim of the JWT.
+	Subjects []string
+	// Claims the verified JWT claims required, each having any of the values.
+	Claims []ClaimCondition
+	// Scopes the scopes granted to the caller, e.g. of the API key, any of which is required.
+	Scopes []string
+	// ClientIdentities the globs of the identities of the verified TLS client certificate, i.e. the subject common name,
+	// the DNS, email, IP and URI SANs, e.g. "spiffe://example.org/ns/prod/*".
+	ClientIdentities []string
+	// ClientFingerprints the SHA-256 fingerprints of the verified TLS client certificate in hex, any of which is required.
+	ClientFingerprints []string
+	// SourceCidrs the source addresses of the calls, e.g. "10.0.0.0/8".
+	SourceCidrs []string
+}
+
+// ClaimCondition requires the claim to have any of the values, or any of its elements if it is an array.
+type ClaimCondition struct {
+	// Claim the name of the claim, or the dot separated path of a nested one, e.g. "realm_access.roles".
+	Claim  string
+	Values []string
+}
+
+// Authorizer authorizes the calls per the policies. It is evaluated by the director of the reverse proxy, after the
+// authentication of the calls.
+type Authorizer struct {
+	cfg      AuthorizationConfig
+	policies []*policy
+}
+
+type policy struct {
+	PolicyConfig
+	sourceNets []*net.IPNet
+}
+
+// NewAuthorizer validates the policies.
+func NewAuthorizer(cfg AuthorizationConfig) (*Authorizer, error) {
+	if cfg.DefaultEffect == "" {
+		cfg.DefaultEffect = EffectDeny
+	}
+	if cfg.DefaultEffect != EffectAllow && cfg.DefaultEffect != EffectDeny {
+		return nil, fmt.Errorf("invalid DefaultEffect %q", cfg.DefaultEffect)
+	}
+	a := &Authorizer{cfg: cfg}
+	for i, pc := range cfg.Policies {
+		if pc.Name == "" {
+			pc.Name = fmt.Sprintf("policy-%d", i)
+		}
+		if pc.Effect != EffectAllow && pc.Effect != EffectDeny {
+			return nil, fmt.Errorf("policy %v: invalid Effect %q", pc.Name, pc.Effect)
+		}
+		p := &policy{PolicyConfig: pc}
+		for _, glob := range append(append(append([]string{}, pc.Methods...), pc.Subjects...), pc.ClientIdentities...) {
+			if _, err := path.Match(glob, ""); err != nil {
+				return nil, fmt.Errorf("policy %v: invalid glob %q", pc.Name, glob)
+			}
+		}
+		for _, cidr := range pc.SourceCidrs {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("policy %v: %v", pc.Name, err)
+			}
+			p.sourceNets = append(p.sourceNets, n)
+		}
+		a.policies = append(a.policies, p)
+	}
+	return a, nil
+}
+
+// Authorize returns the error with the status code PERMISSION_DENIED if the call is denied, which is only logged in the
+// dry-run mode.
+func (a *Authorizer) Authorize(ctx context.Context, fullMethodName string) error {
+	r := newAuthorizationRequest(ctx, fullMethodName)
+	name, effect := "default", a.cfg.DefaultEffect
+	for _, p := range a.policies {
+		if p.matches(r) {
+			name, effect = p.Name, p.Effect
+			break
+		}
+	}
+	if effect == EffectAllow {
+		return nil
+	}
+	fields := logrus.Fields{
+		"grpc.method": fullMethodName,
+		"policy":      name,
+		"subject":     r.subject(),
+		"peer":        r.addr,
+	}
+	if a.cfg.DryRun {
+		logrus.WithFields(fields).Warningf("authorization dry-run: the call would be denied")
+		return nil
+	}
+	logrus.WithFields(fields).Infof("authorization denied")
+	return status.Errorf(codes.PermissionDenied, "permission denied to call %v", fullMethodName)
+}
+
+// authorizationRequest the attributes of the call the policies are matched against.
+type authorizationRequest struct {
+	fullMethodName string
+	principal      *Principal
+	addr           string
+	ip             net.IP
+	identities     []string
+	fingerprint    string
+}
+
+func newAuthorizationRequest(ctx context.Context, fullMethodName string) *authorizationRequest {
+	r := &authorizationRequest{fullMethodName: fullMethodName}
+	r.principal, _ = FromContext(ctx)
+	if p, ok := peer.FromContext(ctx); ok {
+		if p.Addr != nil {
+			r.addr = p.Addr.String()
+			host, _, err := net.SplitHostPort(r.addr)
+			if err != nil {
+				host = r.addr
+			}
+			r.ip = net.ParseIP(host)
+		}
+		if cert := verifiedClientCertificate(p); cert != nil {
+			c := NewClientCertificate(cert)
+			r.identities = certificateIdentities(c)
+			r.fingerprint = c.Fingerprint
+		}
+	}
+	return r
+}
+
+func (r *authorizationRequest) subject() string {
+	if r.principal == nil {
+		return ""
+	}
+	return r.principal.Subject
+}
+
+func (p *policy) matches(r *authorizationRequest) bool {
+	if len(p.Methods) > 0 && !matchAnyGlob(p.Methods, r.fullMethodName) {
+		return false
+	}
+	if (p.Authenticated || len(p.Subjects) > 0 || len(p.Claims) > 0 || len(p.Scopes) > 0) && r.principal == nil {
+		return false
+	}
+	if len(p.Subjects) > 0 && !matchAnyGlob(p.Subjects, r.principal.Subject) {
+		return false
+	}
+	for _, cc := range p.Claims {
+		v, ok := claimValue(r.principal.Claims, cc.Claim)
+		if !ok || !containsAny(cc.Values, metadataValues(v)) {
+			return false
+		}
+	}
+	if len(p.Scopes) > 0 && !containsAny(p.Scopes, r.principal.Scopes) {
+		return false
+	}
+	if len(p.ClientIdentities) > 0 {
+		matched := false
+		for _, id := range r.identities {
+			if matchAnyGlob(p.ClientIdentities, id) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(p.ClientFingerprints) > 0 && !contains(p.ClientFingerprints, r.fingerprint) {
+		return false
+	}
+	if len(p.sourceNets) > 0 {
+		matched := false
+		for _, n := range p.sourceNets {
+			if r.ip != nil && n.Contains(r.ip) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// verifiedClientCertificate returns the client certificate verified by the TLS handshake of the peer, nil if none.
+func verifiedClientCertificate(p *peer.Peer) *x509.Certificate {
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return info.State.VerifiedChains[0][0]
+}
+
+// certificateIdentities returns the subject common name, and the SANs of the certificate.
+func certificateIdentities(c *ClientCertificate) []string {
+	var ids []string
+	if c.CommonName != "" {
+		ids = append(ids, c.CommonName)
+	}
+	return append(ids, c.Sans()...)
+}
+
+func matchAnyGlob(globs []string, s string) bool {
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(list []string, values []string) bool {
+	for _, v := range values {
+		if contains(list, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func testPeerContext(ip string, cert *x509.Certificate) context.Context {
+	p := &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}}
+	if cert != nil {
+		p.AuthInfo = credentials.TLSInfo{State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}}
+	}
+	return peer.NewContext(context.Background(), p)
+}
+
+func TestAuthorizer(t *testing.T) {
+	pinned := &x509.Certificate{Raw: []byte("pinned"), Subject: pkix.Name{CommonName: "legacy"}}
+	a, err := NewAuthorizer(AuthorizationConfig{
+		Enabled: true,
+		Policies: []PolicyConfig{
+			{Name: "no-internal", Effect: EffectDeny, Methods: []string{"/com.example.internal.*/*"}},
+			{Name: "office", Effect: EffectAllow, SourceCidrs: []string{"10.1.0.0/16"}},
+			{Name: "admins", Effect: EffectAllow, Methods: []string{"/com.example.admin.*/*"},
+				Claims: []ClaimCondition{{Claim: "realm_access.roles", Values: []string{"admin"}}}},
+			{Name: "partners", Effect: EffectAllow, Methods: []string{"/com.example.public.*/Get*"}, Scopes: []string{"public:read"}},
+			{Name: "services", Effect: EffectAllow, ClientIdentities: []string{"spiffe://example.org/ns/prod/*"}},
+			{Name: "pinned", Effect: EffectAllow, Methods: []string{"/com.example.admin.*/*"},
+				ClientFingerprints: []string{NewClientCertificate(pinned).Fingerprint}},
+			{Name: "users", Effect: EffectAllow, Methods: []string{"/com.example.public.*/*"}, Authenticated: true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := &Principal{Subject: "alice", Claims: map[string]interface{}{
+		"realm_access": map[string]interface{}{"roles": []interface{}{"dev", "admin"}},
+	}}
+	partner := &Principal{Subject: "partner", Scopes: []string{"public:read"}}
+	spiffe, _ := url.Parse("spiffe://example.org/ns/prod/billing")
+	service := &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}, URIs: []*url.URL{spiffe}}
+	cases := []struct {
+		name      string
+		method    string
+		ip        string
+		principal *Principal
+		cert      *x509.Certificate
+		allowed   bool
+	}{
+		{"internal denied first", "/com.example.internal.Jobs/Run", "10.1.2.3", admin, nil, false},
+		{"office network", "/com.example.admin.Users/Delete", "10.1.2.3", nil, nil, true},
+		{"admin claim", "/com.example.admin.Users/Delete", "192.0.2.1", admin, nil, true},
+		{"no admin claim", "/com.example.admin.Users/Delete", "192.0.2.1", partner, nil, false},
+		{"partner scope", "/com.example.public.Books/GetBook", "192.0.2.1", partner, nil, true},
+		{"authenticated user", "/com.example.public.Books/DeleteBook", "192.0.2.1", &Principal{Subject: "bob"}, nil, true},
+		{"anonymous", "/com.example.public.Books/GetBook", "192.0.2.1", nil, nil, false},
+		{"client identity", "/com.example.admin.Users/Delete", "192.0.2.1", nil, service, true},
+		{"client fingerprint", "/com.example.admin.Users/Delete", "192.0.2.1", nil, pinned, true},
+		{"other fingerprint", "/com.example.admin.Users/Delete", "192.0.2.1", nil, &x509.Certificate{Raw: []byte("other")}, false},
+		{"default deny", "/com.example.other.Svc/Call", "192.0.2.1", admin, nil, false},
+	}
+	for _, c := range cases {
+		ctx := testPeerContext(c.ip, c.cert)
+		if c.principal != nil {
+			ctx = NewContext(ctx, c.principal)
+		}
+		err := a.Authorize(ctx, c.method)
+		if c.allowed && err != nil {
+			t.Errorf("%v: expected allowed, but got %v", c.name, err)
+		} else if !c.allowed && status.Code(err) != codes.PermissionDenied {
+			t.Errorf("%v: expected PermissionDenied, but got %v", c.name, err)
+		}
+	}
+}
+
+func TestAuthorizerDryRunAndDefaultAllow(t *testing.T) {
+	deny := PolicyConfig{Effect: EffectDeny, Methods: []string{"/a.B/*"}}
+	dryRun, err := NewAuthorizer(AuthorizationConfig{Enabled: true, DryRun: true, Policies: []PolicyConfig{deny}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dryRun.Authorize(context.Background(), "/a.B/C"); err != nil {
+		t.Errorf("expected the dry-run not denying, but got %v", err)
+	}
+	allow, err := NewAuthorizer(AuthorizationConfig{Enabled: true, DefaultEffect: EffectAllow, Policies: []PolicyConfig{deny}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = allow.Authorize(context.Background(), "/x.Y/Z"); err != nil {
+		t.Errorf("expected allowed by default, but got %v", err)
+	}
+	if err = allow.Authorize(context.Background(), "/a.B/C"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied, but got %v", err)
+	}
+
+	for _, invalid := range []AuthorizationConfig{
+		{DefaultEffect: "maybe"},
+		{Policies: []PolicyConfig{{Effect: "permit"}}},
+		{Policies: []PolicyConfig{{Effect: EffectAllow, SourceCidrs: []string{"10.0.0.0"}}}},
+		{Policies: []PolicyConfig{{Effect: EffectAllow, Methods: []string{"/a.B/["}}}},
+	} {
+		if _, err = NewAuthorizer(invalid); err == nil {
+			t.Errorf("expected error for %+v", invalid)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus/ctxlogrus"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"strings"
+)
+
+// ClientCertConfig the settings of the identity of the verified TLS client certificate forwarded to the backends. The
+// metadata of the empty keys are not forwarded, and the values of the keys supplied by the clients are dropped anyway.
+type ClientCertConfig struct {
+	// Enabled whether to extract the identity of the client certificate verified by TlsVerifyCert.
+	Enabled bool
+	// SubjectMetadata the metadata key of the subject distinguished name, default is "x-client-cert-subject".
+	SubjectMetadata string
+	// SansMetadata the metadata key of the DNS, email, IP and URI SANs, one value each, default is "x-client-cert-sans".
+	SansMetadata string
+	// UriSansMetadata the metadata key of the URI SANs only, e.g. the SPIFFE IDs, default is "x-client-cert-uri-sans".
+	UriSansMetadata string
+	// FingerprintMetadata the metadata key of the SHA-256 fingerprint of the certificate in hex, default is
+	// "x-client-cert-fingerprint".
+	FingerprintMetadata string
+}
+
+// ClientCertificate the identity of the verified TLS client certificate.
+type ClientCertificate struct {
+	// Subject the distinguished name of the subject, e.g. "CN=client,O=Example".
+	Subject    string
+	CommonName string
+	DNSNames   []string
+	Emails     []string
+	IPs        []string
+	// URIs the URI SANs, e.g. "spiffe://example.org/ns/prod/sa/client".
+	URIs []string
+	// Fingerprint the SHA-256 of the certificate in lower case hex.
+	Fingerprint string
+}
+
+// NewClientCertificate extracts the identity of the certificate.
+func NewClientCertificate(cert *x509.Certificate) *ClientCertificate {
+	sum := sha256.Sum256(cert.Raw)
+	c := &ClientCertificate{
+		Subject:     cert.Subject.String(),
+		CommonName:  cert.Subject.CommonName,
+		DNSNames:    cert.DNSNames,
+		Emails:      cert.EmailAddresses,
+		Fingerprint: hex.EncodeToString(sum[:]),
+	}
+	for _, ip := range cert.IPAddresses {
+		c.IPs = append(c.IPs, ip.String())
+	}
+	for _, u := range cert.URIs {
+		c.URIs = append(c.URIs, u.String())
+	}
+	return c
+}
+
+// Sans returns all the SANs of the certificate.
+func (c *ClientCertificate) Sans() []string {
+	var sans []string
+	sans = append(sans, c.DNSNames...)
+	sans = append(sans, c.Emails...)
+	sans = append(sans, c.IPs...)
+	return append(sans, c.URIs...)
+}
+
+// ClientCertAuthenticator authenticates the requests by the verified TLS client certificate of the peer, which is
+// required by the TLS handshake, so the requests without it pass as unauthenticated.
+type ClientCertAuthenticator struct {
+	cfg ClientCertConfig
+}
+
+// NewClientCertAuthenticator returns the authenticator forwarding the identity by the metadata keys of the settings.
+func NewClientCertAuthenticator(cfg ClientCertConfig) *ClientCertAuthenticator {
+	cfg.SubjectMetadata = strings.ToLower(cfg.SubjectMetadata)
+	cfg.SansMetadata = strings.ToLower(cfg.SansMetadata)
+	cfg.UriSansMetadata = strings.ToLower(cfg.UriSansMetadata)
+	cfg.FingerprintMetadata = strings.ToLower(cfg.FingerprintMetadata)
+	return &ClientCertAuthenticator{cfg: cfg}
+}
+
+func (a *ClientCertAuthenticator) InjectedMetadata() []string {
+	var keys []string
+	for _, k := range []string{a.cfg.SubjectMetadata, a.cfg.SansMetadata, a.cfg.UriSansMetadata, a.cfg.FingerprintMetadata} {
+		if k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func (a *ClientCertAuthenticator) Authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	md = md.Copy()
+	for _, k := range a.InjectedMetadata() {
+		delete(md, k)
+	}
+	ctx = metadata.NewIncomingContext(ctx, md)
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ctx, nil
+	}
+	cert := verifiedClientCertificate(p)
+	if cert == nil {
+		return ctx, nil
+	}
+	c := NewClientCertificate(cert)
+	setMetadata(md, a.cfg.SubjectMetadata, c.Subject)
+	setMetadata(md, a.cfg.SansMetadata, c.Sans()...)
+	setMetadata(md, a.cfg.UriSansMetadata, c.URIs...)
+	setMetadata(md, a.cfg.FingerprintMetadata, c.Fingerprint)
+	ctxlogrus.AddFields(ctx, logrus.Fields{"auth.client_cert": c.Subject})
+
+	principal := principalForUpdate(ctx)
+	principal.ClientCertificate = c
+	if principal.Subject == "" {
+		principal.Subject = c.CommonName
+		if len(c.URIs) > 0 {
+			principal.Subject = c.URIs[0]
+		}
+	}
+	return NewContext(ctx, principal), nil
+}
+
+func setMetadata(md metadata.MD, key string, values ...string) {
+	if key != "" && len(values) > 0 {
+		md.Set(key, values...)
+	}
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"google.golang.org/grpc/metadata"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func testClientCertConfig() ClientCertConfig {
+	return ClientCertConfig{
+		Enabled:             true,
+		SubjectMetadata:     "x-client-cert-subject",
+		SansMetadata:        "x-client-cert-sans",
+		UriSansMetadata:     "x-client-cert-uri-sans",
+		FingerprintMetadata: "x-client-cert-fingerprint",
+	}
+}
+
+func TestClientCertAuthenticator(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/ns/prod/billing")
+	cert := &x509.Certificate{
+		Raw:         []byte("billing"),
+		Subject:     pkix.Name{CommonName: "billing", Organization: []string{"Example"}},
+		DNSNames:    []string{"billing.example.org"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+		URIs:        []*url.URL{spiffe},
+	}
+	sum := sha256.Sum256(cert.Raw)
+	a := NewClientCertAuthenticator(testClientCertConfig())
+	spoofed := metadata.Pairs("x-client-cert-subject", "CN=admin", "x-client-cert-fingerprint", "spoofed")
+
+	ctx, err := a.Authenticate(metadata.NewIncomingContext(testPeerContext("192.0.2.1", cert), spoofed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	expected := metadata.MD{
+		"x-client-cert-subject":     {"CN=billing,O=Example"},
+		"x-client-cert-sans":        {"billing.example.org", "10.0.0.1", spiffe.String()},
+		"x-client-cert-uri-sans":    {spiffe.String()},
+		"x-client-cert-fingerprint": {hex.EncodeToString(sum[:])},
+	}
+	if !reflect.DeepEqual(md, expected) {
+		t.Errorf("expected %v, but got %v", expected, md)
+	}
+	p, _ := FromContext(ctx)
+	if p == nil || p.Subject != spiffe.String() || p.ClientCertificate == nil || p.ClientCertificate.CommonName != "billing" {
+		t.Errorf("expected principal of the certificate, but got %+v", p)
+	}
+
+	// without the certificate, the spoofed values are dropped and the caller is not authenticated.
+	ctx, err = a.Authenticate(metadata.NewIncomingContext(testPeerContext("192.0.2.1", nil), spoofed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md, _ = metadata.FromIncomingContext(ctx); len(md) != 0 {
+		t.Errorf("expected the spoofed metadata dropped, but got %v", md)
+	}
+	if _, ok := FromContext(ctx); ok {
+		t.Errorf("expected no principal, but got one")
+	}
+}
+
+func TestClientCertAuthenticatorKeepsSubject(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "gateway"}}
+	ctx := NewContext(testPeerContext("192.0.2.1", cert), &Principal{Subject: "alice"})
+	ctx, err := NewClientCertAuthenticator(testClientCertConfig()).Authenticate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := FromContext(ctx); p.Subject != "alice" || p.ClientCertificate.CommonName != "gateway" {
+		t.Errorf("expected subject alice with the certificate, but got %+v", p)
+	}
+}
+
+func TestClientCertHTTPHandler(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "web"}}
+	var forwarded http.Header
+	h := HTTPHandler(NewClientCertAuthenticator(testClientCertConfig()), http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/v1/books", nil)
+	r.Header.Set("X-Client-Cert-Subject", "CN=admin")
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if v := forwarded.Values("X-Client-Cert-Subject"); !reflect.DeepEqual(v, []string{"CN=web"}) {
+		t.Errorf("expected CN=web, but got %v", v)
+	}
+}
@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MicahParks/keyfunc"
+	"github.com/golang-jwt/jwt/v4"
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jwtSigningMethods the accepted signing algorithms, which are the asymmetric ones verified by the JWKS public keys.
+var jwtSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// JwtConfig the settings of the JWT authentication.
+type JwtConfig struct {
+	// Enabled whether to authenticate the requests by the bearer JWT in the authorization metadata.
+	Enabled bool
+	// Optional whether to pass the requests without the authorization metadata as unauthenticated, e.g. for the authorization
+	// policies to decide. the invalid tokens are rejected anyway.
+	Optional bool
+	// Issuers the accepted "iss" claims. any issuer is accepted if empty.
+	Issuers []string
+	// Audiences the accepted "aud" claims, any of which is required in the token. the audience is not checked if empty.
+	Audiences []string
+	// JwksFile the JSON Web Key Set file of the public keys verifying the tokens.
+	JwksFile string
+	// JwksUrl the URL of the JSON Web Key Set of the public keys verifying the tokens, e.g. "https://issuer.example/.well-known/jwks.json".
+	JwksUrl string
+	// JwksRefreshInterval the interval to refresh the key set from JwksUrl, e.g. "1h". default is 0, which only refreshes the key
+	// set on the unknown key ids.
+	JwksRefreshInterval time.Duration
+	// ForwardClaims the verified claims forwarded to the backends as the metadata. the values supplied by the clients
+	// in the metadata are dropped.
+	ForwardClaims []ClaimMetadata
+}
+
+// ClaimMetadata maps a claim to the metadata forwarded to the backends.
+type ClaimMetadata struct {
+	// Claim the name of the claim, or the dot separated path of a nested one, e.g. "realm_access.roles".
+	Claim string
+	// Metadata the metadata key, e.g. "x-user-id".
+	Metadata string
+}
+
+// JwtAuthenticator authenticates the requests by the bearer JWT in the authorization metadata.
+type JwtAuthenticator struct {
+	cfg     JwtConfig
+	keySets []*keyfunc.JWKS
+	parser  *jwt.Parser
+}
+
+// NewJwtAuthenticator loads the key sets from the JwksFile and the JwksUrl.
+func NewJwtAuthenticator(cfg JwtConfig) (*JwtAuthenticator, error) {
+	a := &JwtAuthenticator{
+		cfg:    cfg,
+		parser: jwt.NewParser(jwt.WithValidMethods(jwtSigningMethods)),
+	}
+	if cfg.JwksFile != "" {
+		data, err := os.ReadFile(cfg.JwksFile)
+		if err != nil {
+			return nil, err
+		}
+		jwks, err := keyfunc.NewJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS file %v: %v", cfg.JwksFile, err)
+		}
+		a.keySets = append(a.keySets, jwks)
+	}
+	if cfg.JwksUrl != "" {
+		jwks, err := keyfunc.Get(cfg.JwksUrl, keyfunc.Options{
+			RefreshInterval:   cfg.JwksRefreshInterval,
+			RefreshUnknownKID: true,
+			RefreshRateLimit:  time.Minute,
+			RefreshTimeout:    time.Second * 10,
+			RefreshErrorHandler: func(err error) {
+				logrus.Warningf("failed refreshing JWKS from %v: %v", cfg.JwksUrl, err)
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed loading JWKS from %v: %v", cfg.JwksUrl, err)
+		}
+		a.keySets = append(a.keySets, jwks)
+	}
+	if len(a.keySets) == 0 {
+		return nil, errors.New("none of JwksFile or JwksUrl is set")
+	}
+	for _, fc := range cfg.ForwardClaims {
+		if fc.Claim == "" || fc.Metadata == "" {
+			return nil, fmt.Errorf("invalid ForwardClaims %+v, both Claim and Metadata are required", fc)
+		}
+	}
+	return a, nil
+}
+
+// Close stops refreshing the key set from the JwksUrl.
+func (a *JwtAuthenticator) Close() {
+	for _, jwks := range a.keySets {
+		jwks.EndBackground()
+	}
+}
+
+func (a *JwtAuthenticator) InjectedMetadata() []string {
+	var keys []string
+	for _, fc := range a.cfg.ForwardClaims {
+		keys = append(keys, strings.ToLower(fc.Metadata))
+	}
+	return keys
+}
+
+func (a *JwtAuthenticator) Authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	md = md.Copy()
+	for _, fc := range a.cfg.ForwardClaims {
+		delete(md, strings.ToLower(fc.Metadata))
+	}
+	ctx = metadata.NewIncomingContext(ctx, md)
+	if len(md.Get("authorization")) == 0 && a.cfg.Optional {
+		return ctx, nil
+	}
+	raw, err := grpc_auth.AuthFromMD(ctx, "bearer")
+	if err != nil {
+		return nil, err
+	}
+	claims, err := a.verify(raw)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
+	}
+	for _, fc := range a.cfg.ForwardClaims {
+		if v, ok := claimValue(claims, fc.Claim); ok {
+			md.Append(strings.ToLower(fc.Metadata), metadataValues(v)...)
+		}
+	}
+	p := principalForUpdate(ctx)
+	p.Claims = claims
+	if sub, ok := claims["sub"].(string); ok {
+		p.Subject = sub
+	}
+	return NewContext(ctx, p), nil
+}
+
+func (a *JwtAuthenticator) verify(raw string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(raw, claims, a.keyfunc); err != nil {
+		return nil, err
+	}
+	if len(a.cfg.Issuers) > 0 {
+		iss, _ := claims["iss"].(string)
+		if !contains(a.cfg.Issuers, iss) {
+			return nil, fmt.Errorf("issuer %q is not accepted", iss)
+		}
+	}
+	if len(a.cfg.Audiences) > 0 {
+		accepted := false
+		for _, aud := range a.cfg.Audiences {
+			if claims.VerifyAudience(aud, true) {
+				accepted = true
+				break
+			}
+		}
+		if !accepted {
+			return nil, errors.New("audience is not accepted")
+		}
+	}
+	return claims, nil
+}
+
+// keyfunc looks up the key in the key sets in turn.
+func (a *JwtAuthenticator) keyfunc(token *jwt.Token) (interface{}, error) {
+	var err error
+	for _, jwks := range a.keySets {
+		var key interface{}
+		if key, err = jwks.Keyfunc(token); err == nil {
+			return key, nil
+		}
+	}
+	return nil, err
+}
+
+// claimValue returns the value of the claim by the dot separated path.
+func claimValue(claims map[string]interface{}, path string) (interface{}, bool) {
+	var v interface{} = claims
+	for _, name := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[name]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// metadataValues formats the claim value as the metadata values, one for each element of the arrays.
+func metadataValues(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	case bool:
+		return []string{strconv.FormatBool(v)}
+	case []interface{}:
+		var values []string
+		for _, e := range v {
+			values = append(values, metadataValues(e)...)
+		}
+		return values
+	default:
+		b, _ := json.Marshal(v)
+		return []string{string(b)}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v4"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testJwks(t *testing.T, kid string, key *rsa.PrivateKey) []byte {
+	b, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": kid,
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func signToken(t *testing.T, kid string, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestJwtAuthenticator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := testJwks(t, "k1", key)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(jwks)
+	}))
+	defer srv.Close()
+
+	a, err := NewJwtAuthenticator(JwtConfig{
+		Enabled:   true,
+		Issuers:   []string{"https://issuer.example"},
+		Audiences: []string{"gateway"},
+		JwksUrl:   srv.URL,
+		ForwardClaims: []ClaimMetadata{
+			{Claim: "sub", Metadata: "x-user-id"},
+			{Claim: "realm_access.roles", Metadata: "x-user-roles"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	valid := jwt.MapClaims{
+		"iss":          "https://issuer.example",
+		"aud":          []string{"other", "gateway"},
+		"sub":          "alice",
+		"exp":          time.Now().Add(time.Hour).Unix(),
+		"realm_access": map[string]interface{}{"roles": []string{"admin", "dev"}},
+	}
+	authenticate := func(token string) (context.Context, error) {
+		md := metadata.Pairs("x-user-id", "mallory")
+		if token != "" {
+			md.Set("authorization", "Bearer "+token)
+		}
+		return a.Authenticate(metadata.NewIncomingContext(context.Background(), md))
+	}
+
+	ctx, err := authenticate(signToken(t, "k1", key, valid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := FromContext(ctx)
+	if !ok || p.Subject != "alice" {
+		t.Errorf("expected principal alice, but got %+v", p)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if got := md.Get("x-user-id"); !reflect.DeepEqual(got, []string{"alice"}) {
+		t.Errorf("expected x-user-id [alice] replacing the spoofed one, but got %v", got)
+	}
+	if got := md.Get("x-user-roles"); !reflect.DeepEqual(got, []string{"admin", "dev"}) {
+		t.Errorf("expected x-user-roles [admin dev], but got %v", got)
+	}
+
+	with := func(k string, v interface{}) jwt.MapClaims {
+		c := jwt.MapClaims{}
+		for ck, cv := range valid {
+			c[ck] = cv
+		}
+		c[k] = v
+		return c
+	}
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	hs256, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, valid).SignedString([]byte("secret"))
+	for name, token := range map[string]string{
+		"missing":          "",
+		"malformed":        "not-a-jwt",
+		"unknown issuer":   signToken(t, "k1", key, with("iss", "https://evil.example")),
+		"unknown audience": signToken(t, "k1", key, with("aud", "other")),
+		"expired":          signToken(t, "k1", key, with("exp", time.Now().Add(-time.Minute).Unix())),
+		"unknown key":      signToken(t, "k2", otherKey, valid),
+		"wrong signature":  signToken(t, "k1", otherKey, valid),
+		"symmetric":        hs256,
+	} {
+		if _, err = authenticate(token); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("%v: expected Unauthenticated, but got %v", name, err)
+		}
+	}
+}
+
+func TestJwtAuthenticatorOptionalWithFile(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err = os.WriteFile(file, testJwks(t, "k1", key), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewJwtAuthenticator(JwtConfig{Enabled: true, Optional: true, JwksFile: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := a.Authenticate(metadata.NewIncomingContext(context.Background(), metadata.MD{}))
+	if err != nil {
+		t.Fatalf("expected the request without token passing, but got %v", err)
+	}
+	if _, ok := FromContext(ctx); ok {
+		t.Error("expected no principal without token")
+	}
+	md := metadata.Pairs("authorization", "Bearer "+signToken(t, "k1", key, jwt.MapClaims{"sub": "bob"}))
+	if ctx, err = a.Authenticate(metadata.NewIncomingContext(context.Background(), md)); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := FromContext(ctx); p == nil || p.Subject != "bob" {
+		t.Errorf("expected principal bob, but got %+v", p)
+	}
+	md = metadata.Pairs("authorization", "Bearer invalid")
+	if _, err = a.Authenticate(metadata.NewIncomingContext(context.Background(), md)); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated for the invalid token, but got %v", err)
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(file, testJwks(t, "k1", key), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewJwtAuthenticator(JwtConfig{Enabled: true, JwksFile: file, ForwardClaims: []ClaimMetadata{{Claim: "sub", Metadata: "X-User-Id"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var served *http.Request
+	h := HTTPHandler(a, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = r
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/invoke/a.B/C", nil))
+	if w.Code != http.StatusUnauthorized || served != nil {
+		t.Errorf("expected 401 without token, but got %v", w.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/invoke/a.B/C", nil)
+	r.Header.Set("Authorization", "Bearer "+signToken(t, "k1", key, jwt.MapClaims{"sub": "carol"}))
+	r.Header.Set("X-User-Id", "mallory")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if served == nil {
+		t.Fatal("expected the request served")
+	}
+	if got := served.Header.Values("X-User-Id"); !reflect.DeepEqual(got, []string{"carol"}) {
+		t.Errorf("expected X-User-Id [carol], but got %v", got)
+	}
+	if p, _ := FromContext(served.Context()); p == nil || p.Subject != "carol" {
+		t.Errorf("expected principal carol, but got %+v", p)
+	}
+}
@@ -0,0 +1,39 @@
+package auth
+
+import "context"
+
+// Principal the authenticated caller of a request.
+type Principal struct {
+	// Subject identifies the caller, e.g. the "sub" claim of the JWT.
+	Subject string
+	// Claims the verified claims of the JWT, nil if not authenticated by JWT.
+	Claims map[string]interface{}
+	// Scopes the scopes granted to the caller, e.g. of the API key.
+	Scopes []string
+	// ApiKey the label of the API key, empty if not authenticated by API key.
+	ApiKey string
+	// ClientCertificate the identity of the verified TLS client certificate, nil if none.
+	ClientCertificate *ClientCertificate
+}
+
+type principalKey struct{}
+
+// NewContext returns the context carrying the principal.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// principalForUpdate returns a copy of the principal of the context to be updated by another authenticator, or a new one.
+func principalForUpdate(ctx context.Context) *Principal {
+	if p, ok := FromContext(ctx); ok {
+		c := *p
+		return &c
+	}
+	return &Principal{}
+}
+
+// FromContext returns the principal of the context, if the request is authenticated.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
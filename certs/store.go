@@ -0,0 +1,155 @@
+// Package certs loads the TLS certificates and CA bundles from the files, and reloads them on changes without
+// restarting the servers and the backend connections.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	certificateExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grpc_gateway_tls_certificate_expiry_timestamp_seconds",
+		Help: "The expiry time of the loaded TLS certificate, or the earliest one of the CA bundle, in unix seconds.",
+	}, []string{"name", "file"})
+	reloadFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_gateway_tls_reload_failures_total",
+		Help: "Total number of the failures reloading the TLS certificates and CA bundles.",
+	}, []string{"name"})
+)
+
+func init() {
+	prometheus.MustRegister(certificateExpiry, reloadFailures)
+}
+
+// Store holds the key pair and the CA bundle loaded from the files, either of which may be absent. The loaded ones are
+// kept if a reload fails, e.g. when the files are being rotated.
+type Store struct {
+	sync.RWMutex
+	// name identifies the store in the metrics, e.g. "server" or "backend".
+	name     string
+	certFile string
+	keyFile  string
+	caFile   string
+	cert     *tls.Certificate
+	cas      *x509.CertPool
+}
+
+// NewStore loads the key pair of the certFile and keyFile if set, and the CA bundle of the caFile if set.
+func NewStore(name, certFile, keyFile, caFile string) (*Store, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("both of the certificate and the key files must be set")
+	}
+	s := &Store{name: name, certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Files returns the files to be watched for changes.
+func (s *Store) Files() []string {
+	var files []string
+	for _, f := range []string{s.certFile, s.keyFile, s.caFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// Reload loads the files again.
+func (s *Store) Reload() error {
+	err := s.reload()
+	if err != nil {
+		reloadFailures.WithLabelValues(s.name).Inc()
+	}
+	return err
+}
+
+func (s *Store) reload() error {
+	var cert *tls.Certificate
+	if s.certFile != "" {
+		c, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+		if err != nil {
+			return fmt.Errorf("failed loading key pair %v: %v", s.certFile, err)
+		}
+		if c.Leaf, err = x509.ParseCertificate(c.Certificate[0]); err != nil {
+			return fmt.Errorf("failed parsing certificate %v: %v", s.certFile, err)
+		}
+		cert = &c
+	}
+	var cas *x509.CertPool
+	var caExpiry time.Time
+	if s.caFile != "" {
+		data, err := os.ReadFile(s.caFile)
+		if err != nil {
+			return fmt.Errorf("failed reading CA file %v: %v", s.caFile, err)
+		}
+		cas = x509.NewCertPool()
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return fmt.Errorf("failed parsing CA file %v: %v", s.caFile, err)
+			}
+			cas.AddCert(c)
+			if caExpiry.IsZero() || c.NotAfter.Before(caExpiry) {
+				caExpiry = c.NotAfter
+			}
+		}
+		if caExpiry.IsZero() {
+			return fmt.Errorf("no certificate found in CA file %v", s.caFile)
+		}
+	}
+	s.Lock()
+	s.cert, s.cas = cert, cas
+	s.Unlock()
+	if cert != nil {
+		certificateExpiry.WithLabelValues(s.name, s.certFile).Set(float64(cert.Leaf.NotAfter.Unix()))
+	}
+	if cas != nil {
+		certificateExpiry.WithLabelValues(s.name, s.caFile).Set(float64(caExpiry.Unix()))
+	}
+	return nil
+}
+
+// Certificate returns the loaded key pair, nil if none.
+func (s *Store) Certificate() *tls.Certificate {
+	s.RLock()
+	defer s.RUnlock()
+	return s.cert
+}
+
+// CAs returns the loaded CA bundle, nil if none.
+func (s *Store) CAs() *x509.CertPool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.cas
+}
+
+// GetCertificate is the tls.Config.GetCertificate of the servers.
+func (s *Store) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert := s.Certificate(); cert != nil {
+		return cert, nil
+	}
+	return nil, errors.New("no certificate loaded")
+}
+
+// GetClientCertificate is the tls.Config.GetClientCertificate of the clients, which sends no certificate if none is
+// loaded.
+func (s *Store) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if cert := s.Certificate(); cert != nil {
+		return cert, nil
+	}
+	return &tls.Certificate{}, nil
+}
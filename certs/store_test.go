@@ -0,0 +1,100 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, notAfter time.Time) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid, tmpl.KeyUsage = true, true, x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if keyFile == "" {
+		return
+	}
+	der, _ := x509.MarshalECPrivateKey(c.key)
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	ca := newTestCert(t, "ca", nil, time.Now().Add(48*time.Hour))
+	ca.write(t, caFile, "")
+	first := newTestCert(t, "first.example", ca, time.Now().Add(24*time.Hour))
+	first.write(t, certFile, keyFile)
+
+	s, err := NewStore("test", certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Files()) != 3 {
+		t.Errorf("expected 3 files, but got %v", s.Files())
+	}
+	cert, _ := s.GetCertificate(nil)
+	if cert.Leaf.Subject.CommonName != "first.example" {
+		t.Errorf("expected first.example, but got %v", cert.Leaf.Subject.CommonName)
+	}
+
+	second := newTestCert(t, "second.example", ca, time.Now().Add(24*time.Hour))
+	second.write(t, certFile, keyFile)
+	if err = s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if cert, _ = s.GetCertificate(nil); cert.Leaf.Subject.CommonName != "second.example" {
+		t.Errorf("expected second.example, but got %v", cert.Leaf.Subject.CommonName)
+	}
+
+	// a half-written rotation keeps the loaded certificate.
+	if err = os.WriteFile(keyFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Reload(); err == nil {
+		t.Errorf("expected error of invalid key, but got nil")
+	}
+	if cert, _ = s.GetCertificate(nil); cert.Leaf.Subject.CommonName != "second.example" {
+		t.Errorf("expected second.example kept, but got %v", cert.Leaf.Subject.CommonName)
+	}
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
+	klog "github.com/go-kratos/kratos/v2/log"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_logrus "github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
@@ -16,11 +18,21 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"golang.org/x/net/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	grpcReflection "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"grpc-gateway-x/accesslog"
+	"grpc-gateway-x/auth"
+	"grpc-gateway-x/certs"
 	"grpc-gateway-x/discovery"
+	"grpc-gateway-x/logging"
 	reverse_proxy "grpc-gateway-x/reverse-proxy"
+	"grpc-gateway-x/tracing"
 	"net"
 	"net/http"
 	"os"
@@ -30,12 +42,12 @@ import (
 	"time"
 )
 
+const (
+	httpInvokePathPrefix = "/v1/invoke/"
+	ssePathPrefix        = "/v1/sse/"
+)
+
 func run(cmd *cobra.Command, _ []string) error {
-	logrus.SetOutput(os.Stdout)
-	logrus.SetReportCaller(false)
-	l := logrus.StandardLogger()
-	l.SetLevel(logrus.TraceLevel)
-	logEntry := logrus.NewEntry(l)
 	configFile, _ := cmd.Flags().GetString("config")
 	if configFile != "" {
 		viper.SetConfigFile(configFile)
@@ -49,74 +61,216 @@ func run(cmd *cobra.Command, _ []string) error {
 	if err != nil {
 		return err
 	}
+	if err = logging.Setup(cfg.Log); err != nil {
+		return err
+	}
+	// the resolution of the backends by kratos logs as the discovery.
+	klog.SetLogger(discovery.NewKratosLogger(logging.For(logging.SubsystemDiscovery)))
+	logEntry := logrus.NewEntry(logging.For(logging.SubsystemProxy))
+	if cfg.EnableLatencyHistograms {
+		var opts []grpc_prometheus.HistogramOption
+		if len(cfg.LatencyHistogramBuckets) != 0 {
+			opts = append(opts, grpc_prometheus.WithHistogramBuckets(cfg.LatencyHistogramBuckets))
+		}
+		grpc_prometheus.EnableHandlingTimeHistogram(opts...)
+		reverse_proxy.EnableBackendHandlingTimeHistogram(cfg.LatencyHistogramBuckets)
+	}
 	if cfg.AllowAllOrigins && len(cfg.AllowedOrigins) != 0 {
 		return errors.New("ambiguous AllowAllOrigins and AllowedOrigins configuration. Either set AllowAllOrigins to true OR specify one or more origins to whitelist in AllowedOrigins, not both")
 	}
+	if cfg.SinglePort && cfg.HttpPort <= 0 && cfg.HttpUnixSocket == "" {
+		return errors.New("SinglePort requires HttpPort or HttpUnixSocket to be set")
+	}
 	cfg.Init()
+	if cfg.Tracing.Enabled {
+		shutdownTracing, err := tracing.Setup(cfg.Tracing)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			// flushes the spans pending in the batch.
+			ctx, cls := context.WithTimeout(context.Background(), time.Second*5)
+			defer cls()
+			if err := shutdownTracing(ctx); err != nil {
+				logrus.Warningf("failed shutting down tracing: %v", err)
+			}
+		}()
+	}
 
-	errChan := make(chan error, 3)
-	var servingHttpServer *http.Server
-	if cfg.HttpPort > 0 {
-		grpcServerForWeb := buildGrpcProxyServer(logEntry, cfg)
-		options := []grpcweb.Option{
-			grpcweb.WithCorsForRegisteredEndpointsOnly(false),
-			grpcweb.WithOriginFunc(cfg.IsOriginAllowed),
+	var accessLogger *accesslog.Logger
+	if cfg.AccessLog.Enabled {
+		accessLogger, err = accesslog.New(cfg.AccessLog)
+		if err != nil {
+			return err
 		}
+		defer func() { _ = accessLogger.Close() }()
+	}
+
+	listeners, err := cfg.ListenerConfigs()
+	if err != nil {
+		return err
+	}
+	if len(listeners) == 0 {
+		return errors.New("no listener is configured, set HttpPort, GrpcPort or Listeners")
+	}
 
-		if len(cfg.AllowedHeaders) > 0 {
-			options = append(
-				options,
-				grpcweb.WithAllowedRequestHeaders(cfg.AllowedHeaders),
-			)
+	health := newHealthState(len(listeners))
+	errChan := make(chan error, 2*len(listeners)+1)
+	stopChan := make(chan struct{})
+	defer close(stopChan)
+	// the listeners serving the same routes by the same authorization and metadata rules share the reverse proxy, so as the backend connections
+	// and the descriptors.
+	reverseProxies := map[string]*reverse_proxy.GrpcReverseProxy{}
+	reloadDescriptorSets := func() {
+		reloaded := true
+		for _, rp := range reverseProxies {
+			if err := rp.ReloadDescriptorSets(); err != nil {
+				logging.For(logging.SubsystemReflection).Warningf("failed reloading descriptor sets: %v", err)
+				reloaded = false
+			}
+		}
+		if reloaded {
+			logging.For(logging.SubsystemReflection).Infof("descriptor sets reloaded")
 		}
+	}
 
-		wrappedGrpc := grpcweb.WrapServer(grpcServerForWeb, options...)
+	// the listeners using the same API key file share the store, so as the rate limits of the keys.
+	apiKeyStores := map[string]*auth.ApiKeyStore{}
+	// the shortest of the reload intervals of the listeners using the API key file.
+	apiKeyReloadIntervals := map[string]time.Duration{}
+	reloadApiKeyFile := func(file string) {
+		if err := apiKeyStores[file].Reload(); err != nil {
+			logrus.Warningf("failed reloading API keys from %v: %v", file, err)
+			return
+		}
+		logrus.Infof("API keys reloaded from %v", file)
+	}
+	reloadApiKeys := func() {
+		for file := range apiKeyStores {
+			reloadApiKeyFile(file)
+		}
+	}
 
-		serveMux := http.NewServeMux()
-		serveMux.Handle("/", wrappedGrpc)
-		if cfg.EnableMetrics {
-			serveMux.Handle("/metrics", promhttp.Handler())
+	// the listeners having the same JWT configuration share the authenticator, so as the JWKS refreshing in the background,
+	// which is stopped on the shutdown.
+	jwtAuthenticators := map[string]*auth.JwtAuthenticator{}
+	defer func() {
+		for _, authenticator := range jwtAuthenticators {
+			authenticator.Close()
 		}
-		if cfg.EnableRequestTracing {
-			serveMux.HandleFunc("/debug/requests", func(resp http.ResponseWriter, req *http.Request) {
-				trace.Traces(resp, req)
-			})
-			serveMux.HandleFunc("/debug/events", func(resp http.ResponseWriter, req *http.Request) {
-				trace.Events(resp, req)
-			})
+	}()
+
+	// the server and the backend certificates are reloaded on changes, so as the rotated ones are taken by the new
+	// connections without a restart.
+	var certStores []*certs.Store
+	reloadCertificates := func() {
+		for _, store := range certStores {
+			if err := store.Reload(); err != nil {
+				logrus.Warningf("failed reloading TLS certificates: %v", err)
+			}
 		}
-		servingHttpServer = buildServer(serveMux, cfg)
-		servingListener := buildListenerOrFail("grpc-web", cfg.BindHost, cfg.HttpPort)
-		if cfg.EnableTls {
-			servingListener = tls.NewListener(servingListener, buildServerTlsOrFail(cfg))
+		for _, rp := range reverseProxies {
+			if err := rp.ReloadBackendTls(); err != nil {
+				logrus.Warningf("failed reloading backend TLS certificates: %v", err)
+			}
 		}
-		serveGrpcWebServer(servingHttpServer, servingListener, errChan)
 	}
 
-	var grpcServer *grpc.Server
-	if cfg.GrpcPort > 0 {
-		grpcServer := buildGrpcProxyServer(logEntry, cfg)
-		grpcServingListener := buildListenerOrFail("grpc", cfg.BindHost, cfg.GrpcPort)
-		if cfg.EnableTls {
-			grpcServingListener = tls.NewListener(grpcServingListener, buildServerTlsOrFail(cfg))
+	var grpcServers []*grpc.Server
+	var httpServers []*http.Server
+	for _, lc := range listeners {
+		authenticator := buildAuthenticatorOrFail(&lc.Config, jwtAuthenticators, apiKeyStores)
+		injectedMetadata := auth.InjectedMetadata(authenticator)
+		rpKey := reverseProxyKey(lc, injectedMetadata)
+		rp, ok := reverseProxies[rpKey]
+		if !ok {
+			rp = buildReverseProxy(cfg, lc.Routes, lc.MetadataRules, injectedMetadata, buildAuthorizerOrFail(&lc.Config))
+			reverseProxies[rpKey] = rp
+			health.addReverseProxy(rp)
+		}
+		if lc.ApiKey.Enabled && lc.ApiKey.ReloadInterval > 0 {
+			if interval, ok := apiKeyReloadIntervals[lc.ApiKey.File]; !ok || lc.ApiKey.ReloadInterval < interval {
+				apiKeyReloadIntervals[lc.ApiKey.File] = lc.ApiKey.ReloadInterval
+			}
+		}
+		var healthServer *reverse_proxy.HealthServer
+		if lc.EnableHealthCheck {
+			healthServer = reverse_proxy.NewHealthServer(rp, health.ready, lc.HealthCheckBackends)
+			if authenticator != nil {
+				healthServer.Authenticate = authenticator.Authenticate
+			}
 		}
-		serveGrpcServer(grpcServer, grpcServingListener, errChan)
+		servingListener := buildServingListenerOrFail(lc.Name, lc.Address, &lc.Config)
+		var sniCerts sniCertificates
+		if lc.EnableTls {
+			var tlsConfig *tls.Config
+			tlsConfig, sniCerts = buildServerTlsOrFail(lc.Name, &lc.Config)
+			certStores = append(certStores, sniCerts.stores()...)
+			servingListener = tls.NewListener(servingListener, tlsConfig)
+		}
+		services := listenerServices{
+			authenticator: authenticator,
+			accessLogger:  accessLogger,
+			healthServer:  healthServer,
+			health:        health,
+			sniCerts:      sniCerts,
+		}
+		grpcServer := buildGrpcProxyServer(logEntry, &lc.Config, rp, services)
+		if lc.servesGrpcOnly() {
+			grpcServers = append(grpcServers, grpcServer)
+			serveGrpcServer(lc.Name, grpcServer, servingListener, health, errChan)
+			continue
+		}
+		handler := buildHttpHandler(lc, rp, grpcServer, services, cmd.Root().Name())
+		if sniCerts != nil {
+			handler = sniCerts.httpHandler(handler)
+		}
+		httpServer := buildServer(handler, &lc.Config)
+		httpServers = append(httpServers, httpServer)
+		serveGrpcWebServer(lc.Name, httpServer, servingListener, health, errChan)
+	}
+	watchFiles(cfg.DescriptorSetFiles, cfg.DescriptorSetReloadInterval, reloadDescriptorSets, stopChan)
+	for _, store := range certStores {
+		watchFiles(store.Files(), cfg.TlsReloadInterval, reloadCertificates, stopChan)
+	}
+	for _, rp := range reverseProxies {
+		watchFiles(rp.BackendTlsFiles(), cfg.TlsReloadInterval, reloadCertificates, stopChan)
+	}
+	for file := range apiKeyStores {
+		file := file
+		watchFiles([]string{file}, apiKeyReloadIntervals[file], func() { reloadApiKeyFile(file) }, stopChan)
 	}
 
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	select {
-	case <-sigChan:
-		if grpcServer != nil {
-			grpcServer.GracefulStop()
-		}
-		ctx, cls := context.WithTimeout(context.Background(), cfg.GracefulShutdownTimeout)
-		defer cls()
-		if servingHttpServer != nil {
-			err = servingHttpServer.Shutdown(ctx)
-			errChan <- err
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
+WaitSig:
+	for sig := range sigChan {
+		switch sig {
+		case syscall.SIGHUP:
+			reloadDescriptorSets()
+			reloadApiKeys()
+			reloadCertificates()
+		case syscall.SIGUSR1:
+			logrus.Warningf("log level raised to %v", logging.ShiftLevel(1))
+		case syscall.SIGUSR2:
+			logrus.Warningf("log level lowered to %v", logging.ShiftLevel(-1))
+		default:
+			break WaitSig
 		}
 	}
+	health.drain()
+	if cfg.ShutdownDrainDelay > 0 {
+		logrus.Infof("draining for %v before shutting down", cfg.ShutdownDrainDelay)
+		time.Sleep(cfg.ShutdownDrainDelay)
+	}
+	for _, grpcServer := range grpcServers {
+		grpcServer.GracefulStop()
+	}
+	ctx, cls := context.WithTimeout(context.Background(), cfg.GracefulShutdownTimeout)
+	defer cls()
+	for _, httpServer := range httpServers {
+		errChan <- httpServer.Shutdown(ctx)
+	}
 ErrH:
 	for {
 		select {
@@ -131,6 +285,136 @@ ErrH:
 	return nil
 }
 
+// listenerServices the optional services of a listener shared by its grpc server and HTTP handler, the zero value
+// enables none of them.
+type listenerServices struct {
+	// authenticator authenticates the calls, nil for none.
+	authenticator auth.Authenticator
+	// accessLogger logs the calls, nil for none.
+	accessLogger *accesslog.Logger
+	// healthServer the grpc health service, nil for none.
+	healthServer *reverse_proxy.HealthServer
+	// health the state served by /healthz and /readyz, nil for none.
+	health *healthState
+	// sniCerts the certificates selected by the SNI, whose authorities are checked, nil without TLS.
+	sniCerts sniCertificates
+}
+
+// buildHttpHandler builds the handler of the HTTP listener, dispatching the requests of the protocols served by the listener,
+// i.e. grpc-web, native grpc over HTTP/2, Connect and the enabled HTTP endpoints. If the native grpc is served without TLS,
+// the cleartext HTTP/2 (h2c) is accepted for the grpc clients; with TLS, the HTTP/2 is negotiated by ALPN.
+// The requests bridged to the grpc calls are authenticated by the authenticator of the services if it is not nil, as the
+// grpc ones are by the interceptors of the grpcServer. The /healthz and /readyz of the health are served if it is not nil.
+func buildHttpHandler(lc *ListenerConfig, rp *reverse_proxy.GrpcReverseProxy, grpcServer *grpc.Server, services listenerServices, title string) http.Handler {
+	cfg := &lc.Config
+	authenticator, health := services.authenticator, services.health
+	rootHandler := &protocolHandler{
+		fallback:    http.NotFoundHandler(),
+		originAllow: cfg.IsOriginAllowed,
+	}
+	if lc.serves(protocolGrpc) {
+		rootHandler.grpcServer = grpcServer
+	}
+	if lc.serves(protocolGrpcWeb) {
+		rootHandler.grpcWeb = buildGrpcWebServer(grpcServer, cfg)
+		rootHandler.fallback = rootHandler.grpcWeb
+	}
+	serveMux := http.NewServeMux()
+	serveMux.Handle("/", rootHandler)
+	if health != nil && lc.EnableHealthCheck {
+		serveMux.Handle("/healthz", health.livenessHandler())
+		serveMux.Handle("/readyz", health.readinessHandler())
+	}
+	if !lc.serves(protocolHttp) {
+		return withH2c(lc, serveMux)
+	}
+	// the bridged requests carry the request id, so as the authentication failures.
+	bridged := func(h http.Handler) http.Handler {
+		if authenticator != nil {
+			h = auth.HTTPHandler(authenticator, h)
+		}
+		h = reverse_proxy.RequestIdHandler(cfg.RequestIdHeader, h)
+		if cfg.Tracing.Enabled {
+			h = tracing.HTTPHandler(h)
+		}
+		return h
+	}
+	if cfg.EnableHttpTranscoding {
+		rootHandler.fallback = bridged(rp.HttpTranscoder())
+	}
+	if cfg.EnableConnect {
+		rootHandler.connect = bridged(rp.ConnectHandler())
+	}
+	if cfg.EnableHttpInvoke {
+		serveMux.Handle(httpInvokePathPrefix, bridged(rp.HttpInvoker(httpInvokePathPrefix)))
+	}
+	if cfg.EnableSSE {
+		serveMux.Handle(ssePathPrefix, bridged(rp.SSEHandler(ssePathPrefix, nil)))
+	}
+	if cfg.EnableOpenAPI {
+		serveMux.Handle("/openapi.json", rp.OpenAPIHandler(title))
+		if cfg.EnableSwaggerUI {
+			serveMux.Handle("/swagger/", reverse_proxy.SwaggerUIHandler("/openapi.json", cfg.SwaggerUI))
+		}
+	}
+	if cfg.EnableMetrics {
+		serveMux.Handle("/metrics", promhttp.Handler())
+	}
+	// the log levels are changed by the authenticated callers only, if the authentication is enabled on the listener.
+	if cfg.EnableLogLevelEndpoint {
+		var h http.Handler = logging.Handler()
+		if authenticator != nil {
+			h = auth.HTTPHandler(authenticator, h)
+		}
+		serveMux.Handle("/debug/loglevel", h)
+	}
+	if cfg.EnableRequestTracing {
+		serveMux.HandleFunc("/debug/requests", func(resp http.ResponseWriter, req *http.Request) {
+			trace.Traces(resp, req)
+		})
+		serveMux.HandleFunc("/debug/events", func(resp http.ResponseWriter, req *http.Request) {
+			trace.Events(resp, req)
+		})
+	}
+	return withH2c(lc, serveMux)
+}
+
+func withH2c(lc *ListenerConfig, handler http.Handler) http.Handler {
+	if lc.serves(protocolGrpc) && !lc.EnableTls {
+		return h2c.NewHandler(handler, &http2.Server{})
+	}
+	return handler
+}
+
+func buildGrpcWebServer(grpcServer *grpc.Server, cfg *Config) *grpcweb.WrappedGrpcServer {
+	options := []grpcweb.Option{
+		grpcweb.WithCorsForRegisteredEndpointsOnly(false),
+		grpcweb.WithOriginFunc(cfg.IsOriginAllowed),
+	}
+
+	if len(cfg.AllowedHeaders) > 0 {
+		options = append(
+			options,
+			grpcweb.WithAllowedRequestHeaders(append([]string{cfg.RequestIdHeader}, cfg.AllowedHeaders...)),
+		)
+	}
+
+	if cfg.EnableWebsockets {
+		options = append(
+			options,
+			grpcweb.WithWebsockets(true),
+			grpcweb.WithWebsocketOriginFunc(func(req *http.Request) bool {
+				// the non-browser clients don't send the Origin header, and are not subject to the cross-origin restrictions.
+				origin := req.Header.Get("Origin")
+				return origin == "" || cfg.IsOriginAllowed(origin)
+			}),
+			grpcweb.WithWebsocketPingInterval(cfg.WebsocketPingInterval),
+			grpcweb.WithWebsocketsMessageReadLimit(cfg.WebsocketMessageReadLimit),
+		)
+	}
+	return grpcweb.WrapServer(grpcServer, options...)
+}
+
 func buildServer(handler http.Handler, cfg *Config) *http.Server {
 	return &http.Server{
 		WriteTimeout: cfg.ClientWriteTimeout * time.Millisecond,
@@ -139,26 +423,28 @@ func buildServer(handler http.Handler, cfg *Config) *http.Server {
 	}
 }
 
-func serveGrpcWebServer(server *http.Server, listener net.Listener, errChan chan error) {
+func serveGrpcWebServer(name string, server *http.Server, listener net.Listener, health *healthState, errChan chan error) {
 	go func() {
-		logrus.Infof("serving grpc-web on: %v", listener.Addr().String())
+		logrus.Infof("serving '%v' on: %v", name, listener.Addr().String())
+		health.listenerUp()
+		defer health.listenerDown()
 		if err := server.Serve(listener); err != nil {
 			errChan <- fmt.Errorf("serve error: %v", err)
 		}
 	}()
 }
 
-func serveGrpcServer(server *grpc.Server, listener net.Listener, errChan chan error) {
+func serveGrpcServer(name string, server *grpc.Server, listener net.Listener, health *healthState, errChan chan error) {
 	go func() {
-		logrus.Infof("serving grpc on: %v", listener.Addr().String())
+		logrus.Infof("serving '%v' on: %v", name, listener.Addr().String())
+		health.listenerUp()
+		defer health.listenerDown()
 		if err := server.Serve(listener); err != nil {
 			errChan <- fmt.Errorf("serve error: %v", err)
 		}
 	}()
 }
-func buildGrpcProxyServer(logger *logrus.Entry, cfg *Config) *grpc.Server {
-	grpc.EnableTracing = true
-	grpc_logrus.ReplaceGrpcLogger(logger)
+func buildReverseProxy(cfg *Config, routes []string, metadataRules []reverse_proxy.MetadataRule, injectedMetadata []string, authorizer reverse_proxy.Authorizer) *reverse_proxy.GrpcReverseProxy {
 	consulConfig := &api.Config{
 		Address: cfg.Consul.Addr,
 		Token:   cfg.Consul.Token,
@@ -172,6 +458,8 @@ func buildGrpcProxyServer(logger *logrus.Entry, cfg *Config) *grpc.Server {
 		if cfg.Consul.TlsCaFile != "" {
 			consulConfig.TLSConfig.CAFile = cfg.Consul.TlsCaFile
 		}
+		consulConfig.TLSConfig.CertFile = cfg.Consul.TlsCertFile
+		consulConfig.TLSConfig.KeyFile = cfg.Consul.TlsKeyFile
 	}
 	d := discovery.NewConsul(consulConfig)
 	rp, err := reverse_proxy.NewReverseProxy(
@@ -180,30 +468,177 @@ func buildGrpcProxyServer(logger *logrus.Entry, cfg *Config) *grpc.Server {
 		reverse_proxy.WithBackendDiscovery(d),
 		reverse_proxy.WithBackendTlsVerifyCert(cfg.BackendTlsVerifyCert),
 		reverse_proxy.WithBackendTlsCaFile(cfg.BackendTlsCaFile),
+		reverse_proxy.WithBackendTlsClientCert(cfg.BackendTlsCertFile, cfg.BackendTlsKeyFile),
+		reverse_proxy.WithBackendTlsServerNames(cfg.BackendTlsServerNames...),
+		reverse_proxy.WithDescriptorSetFiles(cfg.DescriptorSetFiles...),
+		reverse_proxy.WithDescriptorCacheTTL(cfg.DescriptorCacheTTL),
+		reverse_proxy.WithMaxMessageSize(cfg.GrpcMaxMessageSize),
+		reverse_proxy.WithRoutes(routes...),
+		reverse_proxy.WithAuthorizer(authorizer),
+		reverse_proxy.WithMetadataRules(metadataRules...),
+		reverse_proxy.WithInjectedMetadata(injectedMetadata...),
+		reverse_proxy.WithRequestIdHeader(cfg.RequestIdHeader),
+		reverse_proxy.WithPrincipalFunc(func(ctx context.Context) interface{} {
+			if p, ok := auth.FromContext(ctx); ok {
+				return p
+			}
+			return nil
+		}),
 	)
 	if err != nil {
 		panic(err)
 	}
+	return rp
+}
 
+// buildGrpcProxyServer builds the grpc server of the listener proxying the calls by the rp, with the services of the
+// listener enabled.
+func buildGrpcProxyServer(logger *logrus.Entry, cfg *Config, rp *reverse_proxy.GrpcReverseProxy, services listenerServices) *grpc.Server {
+	grpc.EnableTracing = true
+	grpc_logrus.ReplaceGrpcLogger(logger)
+
+	var unaryInterceptors []grpc.UnaryServerInterceptor
+	var streamInterceptors []grpc.StreamServerInterceptor
+	var serverOpts []grpc.ServerOption
+	// the calls whose authority mismatches the SNI are rejected before anything else, as they might have bypassed the
+	// client authentication of the host.
+	if services.sniCerts != nil {
+		unaryInterceptors = append(unaryInterceptors, services.sniCerts.UnaryServerInterceptor())
+		streamInterceptors = append(streamInterceptors, services.sniCerts.StreamServerInterceptor())
+		serverOpts = append(serverOpts, grpc.Creds(tlsConnCredentials{}))
+	}
+	// the span of the call covers the logging, the authentication and the proxying.
+	if cfg.Tracing.Enabled {
+		unaryInterceptors = append(unaryInterceptors, tracing.UnaryServerInterceptor())
+		streamInterceptors = append(streamInterceptors, tracing.StreamServerInterceptor())
+	}
+	unaryInterceptors = append(unaryInterceptors,
+		grpc_logrus.UnaryServerInterceptor(logger),
+		grpc_prometheus.UnaryServerInterceptor,
+		reverse_proxy.RequestIdUnaryServerInterceptor(cfg.RequestIdHeader),
+	)
+	streamInterceptors = append(streamInterceptors,
+		grpc_logrus.StreamServerInterceptor(logger),
+		grpc_prometheus.StreamServerInterceptor,
+		reverse_proxy.RequestIdStreamServerInterceptor(cfg.RequestIdHeader),
+	)
+	// the request id is logged before the authentication as well, so as the rejected calls carry it.
+	if services.accessLogger != nil {
+		unaryInterceptors = append(unaryInterceptors, accesslog.UnaryServerInterceptor(accessLogFields))
+		streamInterceptors = append(streamInterceptors, accesslog.StreamServerInterceptor(accessLogFields))
+		serverOpts = append(serverOpts, grpc.StatsHandler(services.accessLogger))
+	}
+	if services.authenticator != nil {
+		unaryInterceptors = append(unaryInterceptors, auth.UnaryServerInterceptor(services.authenticator))
+		streamInterceptors = append(streamInterceptors, auth.StreamServerInterceptor(services.authenticator))
+		if services.accessLogger != nil {
+			unaryInterceptors = append(unaryInterceptors, accesslog.UnaryServerInterceptor(accessLogFields))
+			streamInterceptors = append(streamInterceptors, accesslog.StreamServerInterceptor(accessLogFields))
+		}
+	}
+	streamInterceptors = append(streamInterceptors, rp.StreamServerInterceptor(), reflectionStreamInterceptor(cfg.EnableReflection))
 	// Server with logging and monitoring enabled.
-	srv := grpc.NewServer(
+	srv := grpc.NewServer(append(serverOpts,
 		grpc.UnknownServiceHandler(proxy.TransparentHandler(proxy.StreamDirector(rp.Director()))),
 		grpc.MaxRecvMsgSize(cfg.GrpcMaxMessageSize),
-		grpc_middleware.WithUnaryServerChain(
-			grpc_logrus.UnaryServerInterceptor(logger),
-			grpc_prometheus.UnaryServerInterceptor,
-		),
-		grpc_middleware.WithStreamServerChain(
-			grpc_logrus.StreamServerInterceptor(logger),
-			grpc_prometheus.StreamServerInterceptor,
-		),
-	)
-	grpcReflection.RegisterServerReflectionServer(srv, rp)
+		grpc_middleware.WithUnaryServerChain(unaryInterceptors...),
+		grpc_middleware.WithStreamServerChain(streamInterceptors...),
+	)...)
+	if cfg.EnableReflection {
+		grpcReflection.RegisterServerReflectionServer(srv, rp)
+	}
+	if services.healthServer != nil {
+		healthpb.RegisterHealthServer(srv, services.healthServer)
+	}
 	return srv
 }
 
-func buildListenerOrFail(name string, host string, port int) net.Listener {
-	addr := fmt.Sprintf("%s:%d", host, port)
+// buildServingListenerOrFail listens on the address, either "unix:///path/to/file.sock" or "host:port".
+func buildServingListenerOrFail(name string, address string, cfg *Config) net.Listener {
+	if strings.HasPrefix(address, unixSocketScheme) {
+		return buildUnixListenerOrFail(name, address, cfg)
+	}
+	listener := buildListenerOrFail(name, address)
+	if cfg.EnableProxyProtocol {
+		listener = buildProxyProtocolListenerOrFail(listener, cfg)
+	}
+	return listener
+}
+
+// buildAuthenticatorOrFail builds the authenticator of the requests per the configuration, nil if none is enabled.
+// The JWT authenticators are shared by the configuration, and the API key stores by the file.
+func buildAuthenticatorOrFail(cfg *Config, jwtAuthenticators map[string]*auth.JwtAuthenticator, apiKeyStores map[string]*auth.ApiKeyStore) auth.Authenticator {
+	var chain auth.Chain
+	if cfg.Jwt.Enabled {
+		key, _ := json.Marshal(cfg.Jwt)
+		authenticator, ok := jwtAuthenticators[string(key)]
+		if !ok {
+			var err error
+			if authenticator, err = auth.NewJwtAuthenticator(cfg.Jwt); err != nil {
+				logrus.Fatalf("failed building JWT authenticator: %v", err)
+			}
+			jwtAuthenticators[string(key)] = authenticator
+		}
+		chain = append(chain, authenticator)
+	}
+	if cfg.ApiKey.Enabled {
+		store, ok := apiKeyStores[cfg.ApiKey.File]
+		if !ok {
+			var err error
+			if store, err = auth.NewApiKeyStore(cfg.ApiKey.File); err != nil {
+				logrus.Fatalf("failed loading API keys: %v", err)
+			}
+			apiKeyStores[cfg.ApiKey.File] = store
+		}
+		authenticator, err := auth.NewApiKeyAuthenticator(cfg.ApiKey, store)
+		if err != nil {
+			logrus.Fatalf("failed building API key authenticator: %v", err)
+		}
+		chain = append(chain, authenticator)
+	}
+	// the last one, so the subject of the certificate is taken only if the caller is not authenticated otherwise.
+	if cfg.ClientCert.Enabled {
+		if !cfg.EnableTls || !cfg.TlsVerifyCert {
+			logrus.Warningf("ClientCert is enabled without EnableTls and TlsVerifyCert, no client certificate is forwarded")
+		}
+		chain = append(chain, auth.NewClientCertAuthenticator(cfg.ClientCert))
+	}
+	switch len(chain) {
+	case 0:
+		return nil
+	case 1:
+		return chain[0]
+	}
+	return chain
+}
+
+// buildAuthorizerOrFail builds the authorizer of the calls per the configuration, nil if the authorization is disabled.
+func buildAuthorizerOrFail(cfg *Config) reverse_proxy.Authorizer {
+	if !cfg.Authorization.Enabled {
+		return nil
+	}
+	authorizer, err := auth.NewAuthorizer(cfg.Authorization)
+	if err != nil {
+		logrus.Fatalf("failed building authorizer: %v", err)
+	}
+	if cfg.Authorization.DryRun {
+		logrus.Warningf("authorization is in dry-run mode, the calls are not denied")
+	}
+	return authorizer
+}
+
+// reflectionStreamInterceptor rejects the reflection requests if the reflection is disabled, which would be proxied to the
+// backends otherwise.
+func reflectionStreamInterceptor(enabled bool) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !enabled && strings.HasPrefix(info.FullMethod, "/grpc.reflection.") {
+			return status.Errorf(codes.Unimplemented, "unknown service %v", info.FullMethod)
+		}
+		return handler(srv, ss)
+	}
+}
+
+func buildListenerOrFail(name string, addr string) net.Listener {
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		logrus.Fatalf("failed listening for '%v' on %v: %v", name, addr, err)
@@ -214,3 +649,20 @@ func buildListenerOrFail(name string, host string, port int) net.Listener {
 		conntrack.TrackWithTracing(),
 	)
 }
+
+// accessLogFields the identity of the caller and the request id of the call for the access log.
+func accessLogFields(ctx context.Context) logrus.Fields {
+	fields := logrus.Fields{}
+	if p, ok := auth.FromContext(ctx); ok {
+		if p.Subject != "" {
+			fields["user"] = p.Subject
+		}
+		if p.ApiKey != "" {
+			fields["api_key"] = p.ApiKey
+		}
+	}
+	if id, ok := reverse_proxy.RequestIdFromContext(ctx); ok {
+		fields["request_id"] = id
+	}
+	return fields
+}
@@ -2,6 +2,11 @@ package main
 
 import (
 	"github.com/spf13/viper"
+	"grpc-gateway-x/accesslog"
+	"grpc-gateway-x/auth"
+	"grpc-gateway-x/logging"
+	reverse_proxy "grpc-gateway-x/reverse-proxy"
+	"grpc-gateway-x/tracing"
 	"time"
 )
 
@@ -12,12 +17,58 @@ type Config struct {
 	// HttpPort is the TCP port to listen on for grpc-web, default is 8080. set it to 0 to disable grpc-web.
 	HttpPort int `yaml:"http_port"`
 	// GrpcPort is the TCP port to listen on for grpc, default is 8181. set it to 0 to disable grpc.
-	GrpcPort      int
+	GrpcPort int
+	// HttpUnixSocket the unix socket to listen on for grpc-web instead of HttpPort, e.g. "unix:///var/run/grpc-gateway-x/http.sock".
+	HttpUnixSocket string
+	// GrpcUnixSocket the unix socket to listen on for grpc instead of GrpcPort, e.g. "unix:///var/run/grpc-gateway-x/grpc.sock".
+	GrpcUnixSocket string
+	// UnixSocketMode the file permission of the unix sockets in octal, default is 0660.
+	UnixSocketMode string
+	// UnixSocketOwner the owner of the unix sockets in the form of "user:group", by names or numeric ids. either of them may be omitted.
+	UnixSocketOwner string
+	// EnableProxyProtocol whether to parse the HAProxy PROXY protocol v1/v2 header on the TCP listeners, so the real client address
+	// behind the TCP load balancers is used for logging and forwarded to the backends as x-forwarded-for.
+	EnableProxyProtocol bool
+	// ProxyProtocolTrustedCIDRs the sources whose PROXY headers are trusted, e.g. the load balancers' "10.0.0.0/8". the headers from the
+	// other sources are ignored. it is required by EnableProxyProtocol.
+	ProxyProtocolTrustedCIDRs []string
+	// ProxyProtocolHeaderTimeout the timeout on reading the PROXY header, e.g. "10s". default is 10s.
+	ProxyProtocolHeaderTimeout time.Duration
+	// Jwt the authentication of the grpc, grpc-web and the bridged HTTP requests by the bearer JWT in the authorization metadata.
+	Jwt auth.JwtConfig
+	// ApiKey the authentication of the requests by the API key in the metadata, checked against the file of hashed keys.
+	ApiKey auth.ApiKeyConfig
+	// ClientCert forwarding the identity of the TLS client certificate verified by TlsVerifyCert to the backends.
+	ClientCert auth.ClientCertConfig
+	// Authorization the authorization of the calls by the policies, evaluated after the authentication. see config.example.yaml.
+	Authorization auth.AuthorizationConfig
+	// Listeners the named listeners, replacing the ones on HttpPort and GrpcPort. each of them has the Name, the Address to listen on
+	// ("host:port" or "unix:///path/to/file.sock") and the Protocols to serve ("grpc", "grpc-web" and "http"), and overrides any of the
+	// settings here for itself, e.g. EnableTls, AllowedOrigins, EnableReflection, Routes and the Enable* features, except the backend and
+	// Consul ones. see config.example.yaml.
+	Listeners []map[string]interface{}
+	// EnableReflection whether to serve the grpc reflection service aggregated from the backends. default is true.
+	EnableReflection bool
+	// Routes the prefixes of the full method names to be proxied, e.g. "/com.example.public." or "/com.example.Greeter/SayHello".
+	// the other methods are rejected and hidden from the reflection and the HTTP APIs. all the methods are proxied if empty.
+	Routes []string
+	// MetadataRules transform the request metadata forwarded to the backends, and the response headers and trailers sent
+	// to the clients, by the routes, e.g. dropping the cookies or setting the client IP. see config.example.yaml.
+	MetadataRules []reverse_proxy.MetadataRule
+	// SinglePort whether to serve the native grpc on HttpPort as well, along with grpc-web, metrics and debug, so one port is exposed.
+	// the cleartext HTTP/2 (h2c) is accepted without TLS, and the HTTP/2 is negotiated by ALPN with TLS. GrpcPort is ignored if set.
+	SinglePort    bool
 	EnableTls     bool
 	TlsCertFile   string
 	TlsKeyFile    string
 	TlsCaFile     string
 	TlsVerifyCert bool
+	// TlsCertificates the certificates selected by the SNI of the clients, along with or instead of the default TlsCertFile
+	// and TlsKeyFile. the first one is the default one if TlsCertFile is not set, and may go without ServerNames. see config.example.yaml.
+	TlsCertificates []TlsCertificateConfig
+	// TlsReloadInterval the interval to check the TLS certificate, key and CA files of the listeners and the backends for
+	// changes, e.g. "1m". default is 0, which disables the check. the files are reloaded on SIGHUP anyway.
+	TlsReloadInterval time.Duration
 	// ClientReadTimeout the timeout on reading data from client in ms. default is 10000 ms.
 	ClientReadTimeout time.Duration
 	// ClientWriteTimeout the timeout on sending data to client in ms. default is 10000 ms.
@@ -34,6 +85,9 @@ type Config struct {
 		TlsVerifyCert bool
 		// TlsCaFile the ca file that can be used to verify the peer's cert if TlsVerifyCert is enabled.
 		TlsCaFile string
+		// TlsCertFile and TlsKeyFile the client certificate presented to consul if it requires mTLS.
+		TlsCertFile string
+		TlsKeyFile  string
 		// Addr the address of consul.
 		Addr string
 		//Token the consul authentication token.
@@ -47,13 +101,89 @@ type Config struct {
 	AllowedOrigins []string
 	// AllowedHeaders list of headers which are allowed to propagate to the gRPC backend.
 	AllowedHeaders []string
-	// BackendAddress when explicitly set the grpc backend address/ip:port, the service auto-discovery via consul will be disabled.
+	// RequestIdHeader the header of the request id taken from the clients, or generated if absent, which is forwarded to
+	// the backends, logged as "request_id", and sent back in the response headers and trailers. default is "x-request-id".
+	RequestIdHeader string
+	// Tracing the OpenTelemetry spans of the calls, the discovery resolutions, the backend dials and the reflection
+	// fan-outs, exported by OTLP. The trace context is propagated to the backends.
+	Tracing tracing.Config
+	// AccessLog one line per grpc and grpc-web call, in JSON or logfmt, written to the stdout or a rotated file.
+	AccessLog accesslog.Config
+	// Log the level, format and output of the logs, with the level overrides of the subsystems "discovery", "proxy" and
+	// "reflection". the base level is raised by SIGUSR1 and lowered by SIGUSR2 at runtime.
+	Log logging.Config
+	// BackendAddress when explicitly set the grpc backend address/ip:port, or unix:///path/to/file.sock of a unix socket, the service
+	// auto-discovery via consul will be disabled.
 	BackendAddress       string
 	BackendEnableTls     bool
 	BackendTlsVerifyCert bool
 	BackendTlsCaFile     string
-	EnableMetrics        bool
-	EnableRequestTracing bool
+	// BackendTlsCertFile and BackendTlsKeyFile the client certificate presented to the backends requiring mTLS.
+	BackendTlsCertFile string
+	BackendTlsKeyFile  string
+	// BackendTlsServerNames the server names verified against the backend certificates instead of the dialed hosts, by the
+	// prefixes of the full method names, e.g. [{Route: /com.example.billing., ServerName: billing.internal.example}]. the
+	// reflection of the backends verifies the server name of the routes of the services they are discovered by.
+	BackendTlsServerNames []reverse_proxy.BackendTlsServerName
+	EnableMetrics         bool
+	// EnableLatencyHistograms whether to record the latency histograms of the calls, of both the incoming ones and the
+	// ones proxied to the backends, by LatencyHistogramBuckets in seconds, default is the prometheus.DefBuckets.
+	EnableLatencyHistograms bool
+	LatencyHistogramBuckets []float64
+	EnableRequestTracing    bool
+	// EnableHealthCheck whether to serve the grpc.health.v1.Health service, and `/healthz` and `/readyz` on HttpPort. the
+	// gateway is ready if all the listeners are serving, the service discovery is reachable and it is not draining.
+	// default is true.
+	EnableHealthCheck bool
+	// HealthCheckBackends whether to proxy the health checks of the non-empty service names to the backends the names are
+	// resolved to, as the calls of the services are. they are authenticated and authorized as the calls of
+	// `/{service}/Check`, while the checks of the empty name are not.
+	HealthCheckBackends bool
+	// ShutdownDrainDelay the delay of the shutdown after the gateway turns unready on SIGTERM, for the orchestrators to
+	// stop routing to it.
+	ShutdownDrainDelay time.Duration
+	// EnableLogLevelEndpoint whether to serve `/debug/loglevel` on HttpPort, responding the log levels on GET and changing
+	// them on POST by the form values "level" and "subsystem". the requests are authenticated as the proxied ones, and
+	// the cross-origin changes are rejected. enable it on the listeners not exposed to the public only, e.g. the admin one
+	// of the Listeners, if the authentication is not enabled.
+	EnableLogLevelEndpoint bool
+	// DescriptorSetFiles compiled FileDescriptorSet files (`protoc --descriptor_set_out` or `buf build`) merged into the reflection answers,
+	// for backends having the reflection service disabled. the files are reloaded on SIGHUP.
+	DescriptorSetFiles []string
+	// DescriptorSetReloadInterval the interval to check the DescriptorSetFiles for changes, e.g. "30s". default is 0, which disables the check.
+	DescriptorSetReloadInterval time.Duration
+	// DescriptorCacheTTL how long the service descriptors reflected from the backends, and the backends discovered, are
+	// cached, e.g. "1m". an expired cache keeps being served while it's rebuilt in the background, and as well when the
+	// discovery fails. (default 1m)
+	DescriptorCacheTTL time.Duration
+	// EnableHttpTranscoding whether to serve the REST/JSON requests on HttpPort, mapped to the grpc calls per the google.api.http annotations
+	// of the methods. the descriptors are from the backends' reflection and the DescriptorSetFiles.
+	EnableHttpTranscoding bool
+	// EnableHttpInvoke whether to serve `POST /v1/invoke/{full.Service}/{Method}` on HttpPort, invoking any method with the JSON encoded request,
+	// without the need of the google.api.http annotations.
+	EnableHttpInvoke bool
+	// EnableSSE whether to serve the server-streaming methods as Server-Sent Events on HttpPort, by `GET /v1/sse/{full.Service}/{Method}`
+	// with the request in the query, or `POST` with the request in JSON as the body.
+	EnableSSE bool
+	// EnableOpenAPI whether to serve the OpenAPI 3 document on HttpPort at /openapi.json, describing the HTTP transcodable methods of all the
+	// discovered services.
+	EnableOpenAPI bool
+	// EnableSwaggerUI whether to serve the Swagger UI page of the OpenAPI document at /swagger/. it requires EnableOpenAPI.
+	EnableSwaggerUI bool
+	// SwaggerUI where the Swagger UI page loads its assets from, by default the pinned release of swagger-ui-dist on unpkg.
+	// set AssetsUrl to self-hosted copies, and CssIntegrity and BundleIntegrity to have the browsers verify them.
+	SwaggerUI reverse_proxy.SwaggerUIAssets
+	// EnableConnect whether to serve the Connect protocol (https://connect.build/docs/protocol) requests on HttpPort, e.g. from connect-web.
+	// the JSON encoded unary requests are required to carry the Connect-Protocol-Version header to be told from the REST/JSON ones.
+	EnableConnect bool
+	// EnableWebsockets whether to serve the grpc-web requests over websocket on HttpPort, which supports the client-streaming and bidi methods
+	// for the browsers. the Origin of the websocket requests is checked per AllowAllOrigins and AllowedOrigins.
+	EnableWebsockets bool
+	// WebsocketPingInterval the interval to ping the websocket clients for keeping the connections alive, e.g. "30s".
+	// default is 0, which disables the ping. the minimum is 1s.
+	WebsocketPingInterval time.Duration
+	// WebsocketMessageReadLimit the maximum size in bytes of a message read from the websocket clients. default is 32768.
+	WebsocketMessageReadLimit int64
 }
 
 func (c *Config) IsOriginAllowed(origin string) bool {
@@ -79,12 +209,31 @@ func init() {
 	viper.SetDefault("BindHost", "0.0.0.0")
 	viper.SetDefault("HttpPort", 8080)
 	viper.SetDefault("GrpcPort", 8181)
+	viper.SetDefault("ProxyProtocolHeaderTimeout", time.Second*10)
 	viper.SetDefault("AllowAllOrigins", true)
+	viper.SetDefault("EnableReflection", true)
+	viper.SetDefault("RequestIdHeader", "x-request-id")
+	viper.SetDefault("Log.Level", "info")
+	viper.SetDefault("Log.Format", logging.FormatText)
+	viper.SetDefault("Log.Output", "stdout")
+	viper.SetDefault("AccessLog.Format", accesslog.FormatJson)
+	viper.SetDefault("AccessLog.MaxSizeMb", 100)
+	viper.SetDefault("AccessLog.SampleRatio", 1)
+	viper.SetDefault("Tracing.ServiceName", "grpc-gateway-x")
+	viper.SetDefault("Tracing.SampleRatio", 1)
+	viper.SetDefault("Tracing.Propagators", []string{tracing.PropagatorTraceContext, tracing.PropagatorBaggage})
+	viper.SetDefault("ClientCert.SubjectMetadata", "x-client-cert-subject")
+	viper.SetDefault("ClientCert.SansMetadata", "x-client-cert-sans")
+	viper.SetDefault("ClientCert.UriSansMetadata", "x-client-cert-uri-sans")
+	viper.SetDefault("ClientCert.FingerprintMetadata", "x-client-cert-fingerprint")
 	viper.SetDefault("ClientReadTimeout", time.Second*10)
 	viper.SetDefault("ClientWriteTimeout", time.Second*10)
 	viper.SetDefault("GracefulShutdownTimeout", time.Second*11)
+	viper.SetDefault("EnableHealthCheck", true)
 	viper.SetDefault("Consul.Scheme", "http")
 	viper.SetDefault("GrpcMaxMessageSize", 4194304)
+	viper.SetDefault("DescriptorCacheTTL", time.Minute)
+	viper.SetDefault("WebsocketMessageReadLimit", 32768)
 	viper.AddConfigPath(".")
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
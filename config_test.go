@@ -7,6 +7,7 @@ import (
 
 func TestConfig(t *testing.T) {
 	cfg := &Config{}
+	viper.SetConfigFile("config.example.yaml")
 	err := viper.ReadInConfig()
 	if err != nil {
 		t.Error(err)
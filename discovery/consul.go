@@ -1,6 +1,8 @@
 package discovery
 
 import (
+	"context"
+	"errors"
 	kc "github.com/go-kratos/kratos/contrib/registry/consul/v2"
 	"github.com/hashicorp/consul/api"
 )
@@ -11,5 +13,22 @@ func NewConsul(cfg *api.Config) Discovery {
 		panic(err)
 	}
 	discovery := kc.New(client, kc.WithHealthCheck(true))
-	return discovery
+	return instrumentedDiscovery{consulDiscovery{Discovery: discovery, client: client}}
+}
+
+type consulDiscovery struct {
+	Discovery
+	client *api.Client
+}
+
+// Check checks the consul agent is reachable and the cluster has a leader.
+func (d consulDiscovery) Check(ctx context.Context) error {
+	leader, err := d.client.Status().LeaderWithQueryOptions((&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if leader == "" {
+		return errors.New("consul cluster has no leader")
+	}
+	return nil
 }
@@ -1,8 +1,17 @@
 package discovery
 
-import "github.com/go-kratos/kratos/v2/registry"
+import (
+	"context"
+	"github.com/go-kratos/kratos/v2/registry"
+)
 
 type Discovery interface {
 	registry.Discovery
 	ListServices() (allServices map[string][]*registry.ServiceInstance, err error)
 }
+
+// Checker is implemented by the discoveries able to check their connectivity, e.g. for the readiness of the gateway.
+type Checker interface {
+	// Check returns the error if the discovery is unreachable.
+	Check(ctx context.Context) error
+}
@@ -0,0 +1,47 @@
+package discovery
+
+import (
+	"fmt"
+	klog "github.com/go-kratos/kratos/v2/log"
+	"github.com/sirupsen/logrus"
+)
+
+// kratosLogger adapts the logrus logger to the kratos one, which the resolution of the backends by kratos logs to.
+type kratosLogger struct {
+	logger *logrus.Logger
+}
+
+// NewKratosLogger returns the kratos logger writing to the logrus one, to be set by klog.SetLogger.
+func NewKratosLogger(logger *logrus.Logger) klog.Logger {
+	return &kratosLogger{logger: logger}
+}
+
+func (l *kratosLogger) Log(level klog.Level, keyvals ...interface{}) error {
+	fields := logrus.Fields{}
+	var msg string
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		var value interface{}
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		if key == klog.DefaultMessageKey {
+			msg = fmt.Sprint(value)
+			continue
+		}
+		fields[key] = value
+	}
+	entry := l.logger.WithFields(fields)
+	switch level {
+	case klog.LevelDebug:
+		entry.Debug(msg)
+	case klog.LevelWarn:
+		entry.Warn(msg)
+	case klog.LevelError, klog.LevelFatal:
+		// the fatal logs of kratos are not to exit the gateway.
+		entry.Error(msg)
+	default:
+		entry.Info(msg)
+	}
+	return nil
+}
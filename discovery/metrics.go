@@ -0,0 +1,67 @@
+package discovery
+
+import (
+	"context"
+	"github.com/go-kratos/kratos/v2/registry"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var discoveredInstances = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "grpc_gateway_discovery_instances",
+	Help: "Number of the instances of the services last discovered.",
+}, []string{"service"})
+
+func init() {
+	prometheus.MustRegister(discoveredInstances)
+}
+
+// instrumentedDiscovery records the numbers of the instances discovered of the services.
+type instrumentedDiscovery struct {
+	Discovery
+}
+
+func (d instrumentedDiscovery) GetService(ctx context.Context, serviceName string) ([]*registry.ServiceInstance, error) {
+	ins, err := d.Discovery.GetService(ctx, serviceName)
+	if err == nil {
+		discoveredInstances.WithLabelValues(serviceName).Set(float64(len(ins)))
+	}
+	return ins, err
+}
+
+func (d instrumentedDiscovery) Watch(ctx context.Context, serviceName string) (registry.Watcher, error) {
+	w, err := d.Discovery.Watch(ctx, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	return instrumentedWatcher{Watcher: w, serviceName: serviceName}, nil
+}
+
+func (d instrumentedDiscovery) ListServices() (map[string][]*registry.ServiceInstance, error) {
+	sis, err := d.Discovery.ListServices()
+	if err == nil {
+		for name, ins := range sis {
+			discoveredInstances.WithLabelValues(name).Set(float64(len(ins)))
+		}
+	}
+	return sis, err
+}
+
+func (d instrumentedDiscovery) Check(ctx context.Context) error {
+	if c, ok := d.Discovery.(Checker); ok {
+		return c.Check(ctx)
+	}
+	return nil
+}
+
+type instrumentedWatcher struct {
+	registry.Watcher
+	serviceName string
+}
+
+func (w instrumentedWatcher) Next() ([]*registry.ServiceInstance, error) {
+	ins, err := w.Watcher.Next()
+	if err == nil {
+		discoveredInstances.WithLabelValues(w.serviceName).Set(float64(len(ins)))
+	}
+	return ins, err
+}
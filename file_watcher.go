@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// watchFiles polls the modification time and size of the files every interval and calls onChange
+// once for each round in which any of them changed. It stops when stop is closed.
+func watchFiles(paths []string, interval time.Duration, onChange func(), stop <-chan struct{}) {
+	if len(paths) == 0 || interval <= 0 {
+		return
+	}
+	stat := func() map[string]string {
+		st := map[string]string{}
+		for _, p := range paths {
+			fi, err := os.Stat(p)
+			if err != nil {
+				st[p] = ""
+				continue
+			}
+			st[p] = fmt.Sprintf("%v/%d", fi.ModTime(), fi.Size())
+		}
+		return st
+	}
+	go func() {
+		last := stat()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				cur := stat()
+				changed := false
+				for p, s := range cur {
+					if last[p] != s {
+						changed = true
+						break
+					}
+				}
+				last = cur
+				if changed {
+					onChange()
+				}
+			}
+		}
+	}()
+}
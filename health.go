@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	reverse_proxy "grpc-gateway-x/reverse-proxy"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// discoveryCheckTimeout the timeout of checking the connectivity of the service discovery for the readiness.
+const discoveryCheckTimeout = time.Second * 2
+
+// discoveryCheckCacheTTL the duration the result of checking the service discoveries is reused for, so as the health
+// watches and the probes don't query the discoveries on each poll.
+const discoveryCheckCacheTTL = time.Second
+
+// healthState the liveness and readiness of the gateway, served by /healthz, /readyz and the grpc health service. The
+// gateway is ready if all the listeners are serving, the service discoveries are reachable and it is not draining.
+type healthState struct {
+	listeners int32
+	serving   atomic.Int32
+	draining  atomic.Bool
+
+	mu             sync.Mutex
+	reverseProxies []*reverse_proxy.GrpcReverseProxy
+
+	// checkMu serializes the checks of the service discoveries, whose result is cached by checkedAt.
+	checkMu   sync.Mutex
+	checkedAt time.Time
+	checkErr  error
+}
+
+func newHealthState(listeners int) *healthState {
+	return &healthState{listeners: int32(listeners)}
+}
+
+func (h *healthState) addReverseProxy(rp *reverse_proxy.GrpcReverseProxy) {
+	h.mu.Lock()
+	h.reverseProxies = append(h.reverseProxies, rp)
+	h.mu.Unlock()
+}
+
+// listenerUp and listenerDown track the listeners serving.
+func (h *healthState) listenerUp() {
+	h.serving.Add(1)
+}
+
+func (h *healthState) listenerDown() {
+	h.serving.Add(-1)
+}
+
+// drain makes the gateway unready, so the orchestrators stop routing to it before the shutdown.
+func (h *healthState) drain() {
+	h.draining.Store(true)
+}
+
+func (h *healthState) ready(ctx context.Context) error {
+	if h.draining.Load() {
+		return fmt.Errorf("draining")
+	}
+	if serving := h.serving.Load(); serving < h.listeners {
+		return fmt.Errorf("%d of %d listeners serving", serving, h.listeners)
+	}
+	return h.checkDiscoveries(ctx)
+}
+
+// checkDiscoveries checks the service discoveries of the reverse proxies, or returns the result of the last check
+// within discoveryCheckCacheTTL.
+func (h *healthState) checkDiscoveries(ctx context.Context) error {
+	h.checkMu.Lock()
+	defer h.checkMu.Unlock()
+	if !h.checkedAt.IsZero() && time.Since(h.checkedAt) < discoveryCheckCacheTTL {
+		return h.checkErr
+	}
+	h.mu.Lock()
+	rps := append([]*reverse_proxy.GrpcReverseProxy(nil), h.reverseProxies...)
+	h.mu.Unlock()
+	checkCtx, cls := context.WithTimeout(ctx, discoveryCheckTimeout)
+	defer cls()
+	var err error
+	for _, rp := range rps {
+		if err = rp.CheckDiscovery(checkCtx); err != nil {
+			err = fmt.Errorf("service discovery unreachable: %v", err)
+			break
+		}
+	}
+	// the failures of the canceled callers are not of the discoveries.
+	if ctx.Err() == nil {
+		h.checkedAt, h.checkErr = time.Now(), err
+	}
+	return err
+}
+
+// livenessHandler responds OK as long as the gateway serves the requests.
+func (h *healthState) livenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte("ok\n"))
+	})
+}
+
+// readinessHandler responds OK if the gateway is ready, or 503 with the reason.
+func (h *healthState) readinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := h.ready(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte("ok\n"))
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kratos/kratos/v2/registry"
+	reverse_proxy "grpc-gateway-x/reverse-proxy"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthState(t *testing.T) {
+	h := newHealthState(2)
+	readyz := func() (int, string) {
+		rec := httptest.NewRecorder()
+		h.readinessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rec.Code, rec.Body.String()
+	}
+	h.listenerUp()
+	if code, body := readyz(); code != http.StatusServiceUnavailable || !strings.Contains(body, "1 of 2 listeners") {
+		t.Errorf("expected unready of the listener not serving, but got %v %q", code, body)
+	}
+	h.listenerUp()
+	if code, _ := readyz(); code != http.StatusOK {
+		t.Errorf("expected ready, but got %v", code)
+	}
+	h.drain()
+	if code, body := readyz(); code != http.StatusServiceUnavailable || !strings.Contains(body, "draining") {
+		t.Errorf("expected unready of draining, but got %v %q", code, body)
+	}
+
+	rec := httptest.NewRecorder()
+	h.livenessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected alive while draining, but got %v", rec.Code)
+	}
+}
+
+// checkedDiscovery the discovery counting the checks of its connectivity, failing them if unreachable.
+type checkedDiscovery struct {
+	checks      *atomic.Int32
+	unreachable *atomic.Bool
+}
+
+func (d checkedDiscovery) GetService(context.Context, string) ([]*registry.ServiceInstance, error) {
+	return nil, nil
+}
+
+func (d checkedDiscovery) Watch(context.Context, string) (registry.Watcher, error) {
+	return nil, errors.New("not watchable")
+}
+
+func (d checkedDiscovery) ListServices() (map[string][]*registry.ServiceInstance, error) {
+	return nil, nil
+}
+
+func (d checkedDiscovery) Check(context.Context) error {
+	d.checks.Add(1)
+	if d.unreachable.Load() {
+		return errors.New("no leader")
+	}
+	return nil
+}
+
+func TestHealthStateCachesDiscoveryCheck(t *testing.T) {
+	d := checkedDiscovery{checks: &atomic.Int32{}, unreachable: &atomic.Bool{}}
+	rp, err := reverse_proxy.NewReverseProxy(reverse_proxy.WithBackendDiscovery(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newHealthState(0)
+	h.addReverseProxy(rp)
+	for i := 0; i < 10; i++ {
+		if err = h.ready(context.Background()); err != nil {
+			t.Fatalf("expected ready, but got %v", err)
+		}
+	}
+	if checks := d.checks.Load(); checks != 1 {
+		t.Errorf("expected 1 check of the discovery, but got %v", checks)
+	}
+	d.unreachable.Store(true)
+	h.checkedAt = time.Now().Add(-discoveryCheckCacheTTL)
+	if err = h.ready(context.Background()); err == nil || !strings.Contains(err.Error(), "no leader") {
+		t.Errorf("expected unready of the discovery unreachable, but got %v", err)
+	}
+	if checks := d.checks.Load(); checks != 2 {
+		t.Errorf("expected the expired check to be repeated, but got %v checks", checks)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mitchellh/mapstructure"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// protocolGrpc the native grpc.
+	protocolGrpc = "grpc"
+	// protocolGrpcWeb the grpc-web, including the websocket transport if EnableWebsockets.
+	protocolGrpcWeb = "grpc-web"
+	// protocolHttp the HTTP endpoints enabled by the Enable* settings, e.g. metrics, debug, Connect and the REST/JSON ones.
+	protocolHttp = "http"
+)
+
+// ListenerConfig the settings of a listener. The embedded Config holds the settings inherited from the top level ones and
+// overridden by the listener, e.g. EnableTls, AllowedOrigins, EnableReflection, Routes and the Enable* features.
+type ListenerConfig struct {
+	// Name of the listener, used in the logs and the metrics.
+	Name string
+	// Address to listen on, "host:port" or "unix:///path/to/file.sock".
+	Address string
+	// Protocols served on the listener, any of "grpc", "grpc-web" and "http". a listener serving "grpc" along with the others
+	// accepts the cleartext HTTP/2 (h2c) without TLS, and negotiates the HTTP/2 by ALPN with TLS.
+	Protocols []string
+	Config    `mapstructure:",squash"`
+}
+
+// reverseProxyKey the key of the reverse proxy serving the listener, which is shared by the listeners of the same key.
+// injectedMetadata the metadata keys injected by the authenticator of the listener, kept under the allow rules.
+func reverseProxyKey(lc *ListenerConfig, injectedMetadata []string) string {
+	b, _ := json.Marshal([]interface{}{lc.Routes, lc.Authorization, lc.MetadataRules, injectedMetadata})
+	return string(b)
+}
+
+func (lc *ListenerConfig) serves(protocol string) bool {
+	for _, p := range lc.Protocols {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// servesGrpcOnly whether the listener is served by the grpc server itself instead of the HTTP server.
+func (lc *ListenerConfig) servesGrpcOnly() bool {
+	return len(lc.Protocols) == 1 && lc.Protocols[0] == protocolGrpc
+}
+
+// ListenerConfigs returns the settings of the Listeners, or of the listeners on HttpPort and GrpcPort if Listeners is not set.
+func (c *Config) ListenerConfigs() ([]*ListenerConfig, error) {
+	if len(c.Listeners) == 0 {
+		return c.defaultListenerConfigs(), nil
+	}
+	var listeners []*ListenerConfig
+	names := map[string]struct{}{}
+	for i, raw := range c.Listeners {
+		lc, err := c.decodeListenerConfig(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listener #%d: %v", i, err)
+		}
+		if lc.Name == "" {
+			lc.Name = "listener-" + strconv.Itoa(i)
+		}
+		if _, ok := names[lc.Name]; ok {
+			return nil, fmt.Errorf("duplicated listener name %v", lc.Name)
+		}
+		names[lc.Name] = struct{}{}
+		if lc.Address == "" {
+			return nil, fmt.Errorf("listener %v: Address is required", lc.Name)
+		}
+		if len(lc.Protocols) == 0 {
+			return nil, fmt.Errorf("listener %v: Protocols is required", lc.Name)
+		}
+		for _, p := range lc.Protocols {
+			if p != protocolGrpc && p != protocolGrpcWeb && p != protocolHttp {
+				return nil, fmt.Errorf("listener %v: unknown protocol %v", lc.Name, p)
+			}
+		}
+		if lc.AllowAllOrigins && len(lc.AllowedOrigins) != 0 {
+			return nil, fmt.Errorf("listener %v: ambiguous AllowAllOrigins and AllowedOrigins configuration", lc.Name)
+		}
+		lc.Init()
+		listeners = append(listeners, lc)
+	}
+	return listeners, nil
+}
+
+// decodeListenerConfig decodes the listener settings over the top level ones.
+func (c *Config) decodeListenerConfig(raw map[string]interface{}) (*ListenerConfig, error) {
+	lc := &ListenerConfig{Config: *c}
+	lc.Listeners = nil
+	lc.SinglePort = false
+	keys := map[string]struct{}{}
+	for k := range raw {
+		keys[strings.ToLower(k)] = struct{}{}
+	}
+	_, hasOrigins := keys["allowedorigins"]
+	_, hasAllowAll := keys["allowallorigins"]
+	if hasOrigins && !hasAllowAll {
+		// the listener whitelisting the origins doesn't inherit allowing all of them.
+		lc.AllowAllOrigins = false
+	}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+		WeaklyTypedInput: true,
+		Result:           lc,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = decoder.Decode(raw); err != nil {
+		return nil, err
+	}
+	return lc, nil
+}
+
+// defaultListenerConfigs the listeners per HttpPort/HttpUnixSocket, GrpcPort/GrpcUnixSocket and SinglePort.
+func (c *Config) defaultListenerConfigs() []*ListenerConfig {
+	var listeners []*ListenerConfig
+	if c.HttpPort > 0 || c.HttpUnixSocket != "" {
+		lc := &ListenerConfig{
+			Name:      "grpc-web",
+			Address:   listenAddress(c.BindHost, c.HttpPort, c.HttpUnixSocket),
+			Protocols: []string{protocolGrpcWeb, protocolHttp},
+			Config:    *c,
+		}
+		if c.SinglePort {
+			lc.Name = "single-port"
+			lc.Protocols = append(lc.Protocols, protocolGrpc)
+		}
+		listeners = append(listeners, lc)
+	}
+	if (c.GrpcPort > 0 || c.GrpcUnixSocket != "") && !c.SinglePort {
+		listeners = append(listeners, &ListenerConfig{
+			Name:      "grpc",
+			Address:   listenAddress(c.BindHost, c.GrpcPort, c.GrpcUnixSocket),
+			Protocols: []string{protocolGrpc},
+			Config:    *c,
+		})
+	}
+	return listeners
+}
+
+func listenAddress(host string, port int, unixSocket string) string {
+	if unixSocket != "" {
+		return unixSocketScheme + unixSocketPath(unixSocket)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
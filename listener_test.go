@@ -0,0 +1,115 @@
+package main
+
+import (
+	"github.com/spf13/viper"
+	"grpc-gateway-x/auth"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const listenersConfig = `
+EnableMetrics: true
+ClientWriteTimeout: 5s
+Listeners:
+  - Name: internal
+    Address: 127.0.0.1:9090
+    Protocols: [grpc, grpc-web, http]
+    EnableRequestTracing: true
+  - Name: public
+    Address: unix:///tmp/public.sock
+    Protocols: [grpc-web, http]
+    EnableTls: true
+    EnableMetrics: false
+    EnableReflection: false
+    AllowedOrigins: [https://example.com]
+    ClientWriteTimeout: 30s
+    Routes: [/com.example.public.]
+`
+
+func TestListenerConfigs(t *testing.T) {
+	v := viper.New()
+	v.SetDefault("AllowAllOrigins", true)
+	v.SetDefault("EnableReflection", true)
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(strings.NewReader(listenersConfig)); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{}
+	if err := v.Unmarshal(cfg); err != nil {
+		t.Fatal(err)
+	}
+	listeners, err := cfg.ListenerConfigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 2 {
+		t.Fatalf("expected 2 listeners, but got %v", len(listeners))
+	}
+	internal, public := listeners[0], listeners[1]
+	if internal.Name != "internal" || internal.Address != "127.0.0.1:9090" || !internal.serves(protocolGrpc) || internal.servesGrpcOnly() {
+		t.Errorf("unexpected internal listener %+v", internal)
+	}
+	if !internal.EnableMetrics || !internal.EnableRequestTracing || !internal.EnableReflection || !internal.IsOriginAllowed("https://any.example") {
+		t.Errorf("expected internal listener inheriting the top level settings, but got %+v", internal.Config)
+	}
+	if internal.ClientWriteTimeout != 5*time.Second {
+		t.Errorf("expected internal ClientWriteTimeout 5s, but got %v", internal.ClientWriteTimeout)
+	}
+	if public.serves(protocolGrpc) || !public.EnableTls || public.EnableMetrics || public.EnableReflection {
+		t.Errorf("unexpected public listener %+v", public)
+	}
+	if public.IsOriginAllowed("https://any.example") || !public.IsOriginAllowed("https://example.com") {
+		t.Errorf("expected public listener allowing https://example.com only")
+	}
+	if public.ClientWriteTimeout != 30*time.Second || !reflect.DeepEqual(public.Routes, []string{"/com.example.public."}) {
+		t.Errorf("unexpected public ClientWriteTimeout %v or Routes %v", public.ClientWriteTimeout, public.Routes)
+	}
+
+	for _, invalid := range []map[string]interface{}{
+		{"Name": "no-address", "Protocols": []interface{}{"grpc"}},
+		{"Name": "no-protocols", "Address": ":9090"},
+		{"Name": "unknown-protocol", "Address": ":9090", "Protocols": []interface{}{"ftp"}},
+	} {
+		if _, err = (&Config{Listeners: []map[string]interface{}{invalid}}).ListenerConfigs(); err == nil {
+			t.Errorf("expected error for listener %v", invalid["Name"])
+		}
+	}
+}
+
+func TestDefaultListenerConfigs(t *testing.T) {
+	listeners, err := (&Config{BindHost: "0.0.0.0", HttpPort: 8080, GrpcPort: 8181}).ListenerConfigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 2 || listeners[0].Address != "0.0.0.0:8080" || listeners[0].serves(protocolGrpc) ||
+		listeners[1].Address != "0.0.0.0:8181" || !listeners[1].servesGrpcOnly() {
+		t.Errorf("unexpected listeners %+v, %+v", listeners[0], listeners[1])
+	}
+	listeners, _ = (&Config{HttpUnixSocket: "/tmp/http.sock", GrpcPort: 8181, SinglePort: true}).ListenerConfigs()
+	if len(listeners) != 1 || listeners[0].Address != "unix:///tmp/http.sock" || !listeners[0].serves(protocolGrpc) {
+		t.Errorf("unexpected single-port listeners %+v", listeners)
+	}
+}
+
+func TestBuildAuthenticatorSharesJwt(t *testing.T) {
+	jwks := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(jwks, []byte(`{"keys":[]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	jwtAuthenticators := map[string]*auth.JwtAuthenticator{}
+	build := func(audience string) auth.Authenticator {
+		cfg := &Config{Jwt: auth.JwtConfig{Enabled: true, JwksFile: jwks, Audiences: []string{audience}}}
+		return buildAuthenticatorOrFail(cfg, jwtAuthenticators, map[string]*auth.ApiKeyStore{})
+	}
+	a, b, c := build("api"), build("api"), build("admin")
+	if a != b {
+		t.Error("expected the listeners of the same JWT configuration to share the authenticator")
+	}
+	if a == c || len(jwtAuthenticators) != 2 {
+		t.Errorf("expected 2 authenticators, but got %v", len(jwtAuthenticators))
+	}
+}
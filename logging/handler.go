@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+// Handler returns the admin handler of the levels. GET responds the current levels, and POST or PUT changes the level
+// of the form value "subsystem", or the base one if absent, to the form value "level", e.g.
+//
+//	curl -d level=debug -d subsystem=proxy http://localhost:8080/debug/loglevel
+//
+// The changes of the cross-origin requests are rejected, as the forms of any site could post them from the browsers.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost, http.MethodPut:
+			if isCrossOrigin(r) {
+				http.Error(w, "cross-origin requests are not allowed", http.StatusForbidden)
+				return
+			}
+			if err := SetLevel(r.FormValue("subsystem"), r.FormValue("level")); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST, PUT")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(CurrentLevels())
+	})
+}
+
+// isCrossOrigin whether the request is sent by the browser from another origin, per the Sec-Fetch-Site header, or the
+// Origin header of the browsers not sending it. the non-browser clients send neither of them.
+func isCrossOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site != "same-origin" && site != "none"
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || u.Host != r.Host
+}
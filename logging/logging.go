@@ -0,0 +1,213 @@
+// Package logging configures the level, format and output of the logs, and the loggers of the subsystems, whose levels
+// can be overridden and changed at runtime.
+package logging
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	// SubsystemDiscovery the service discovery, e.g. the consul resolution of the backends.
+	SubsystemDiscovery = "discovery"
+	// SubsystemProxy the proxied calls, e.g. the per-call logs and the metadata rules.
+	SubsystemProxy = "proxy"
+	// SubsystemReflection the reflection of the backends and the descriptor sets.
+	SubsystemReflection = "reflection"
+
+	FormatText = "text"
+	FormatJson = "json"
+)
+
+// Config the settings of the logs.
+type Config struct {
+	// Level the level of the logs, one of "trace", "debug", "info", "warning", "error", "fatal" and "panic". default is
+	// "info".
+	Level string
+	// Format "text" or "json". default is "text".
+	Format string
+	// Output "stdout", "stderr" or the path of the file to append to. default is "stdout".
+	Output string
+	// Subsystems the levels overriding Level for the subsystems "discovery", "proxy" and "reflection".
+	Subsystems map[string]string
+}
+
+var (
+	mu sync.Mutex
+	// level the base level, which the subsystems without an override follow.
+	level     = logrus.InfoLevel
+	overrides = map[string]logrus.Level{}
+	loggers   = map[string]*logrus.Logger{
+		SubsystemDiscovery:  logrus.New(),
+		SubsystemProxy:      logrus.New(),
+		SubsystemReflection: logrus.New(),
+	}
+)
+
+// For returns the logger of the subsystem, or the standard logger if the subsystem is unknown. The loggers are
+// configured in place by Setup, so they can be kept by the callers.
+func For(subsystem string) *logrus.Logger {
+	if l, ok := loggers[subsystem]; ok {
+		return l
+	}
+	return logrus.StandardLogger()
+}
+
+// Subsystems returns the names of the subsystems.
+func Subsystems() []string {
+	names := make([]string, 0, len(loggers))
+	for name := range loggers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Setup configures the standard logger and the loggers of the subsystems.
+func Setup(cfg Config) error {
+	base, err := parseLevel(cfg.Level, logrus.InfoLevel)
+	if err != nil {
+		return err
+	}
+	subsystemLevels := map[string]logrus.Level{}
+	for name, lvl := range cfg.Subsystems {
+		if _, ok := loggers[name]; !ok {
+			return fmt.Errorf("unknown log subsystem %q", name)
+		}
+		if subsystemLevels[name], err = parseLevel(lvl, base); err != nil {
+			return err
+		}
+	}
+	var formatter logrus.Formatter
+	switch cfg.Format {
+	case "", FormatText:
+		formatter = &logrus.TextFormatter{FullTimestamp: true}
+	case FormatJson:
+		formatter = &logrus.JSONFormatter{}
+	default:
+		return fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+	var out io.Writer
+	switch cfg.Output {
+	case "", "stdout":
+		out = os.Stdout
+	case "stderr":
+		out = os.Stderr
+	default:
+		f, err := os.OpenFile(cfg.Output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed opening log output: %v", err)
+		}
+		out = f
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, l := range append([]*logrus.Logger{logrus.StandardLogger()}, values(loggers)...) {
+		l.SetOutput(out)
+		l.SetFormatter(formatter)
+		l.SetReportCaller(false)
+	}
+	level = base
+	overrides = subsystemLevels
+	applyLevels()
+	return nil
+}
+
+func values(m map[string]*logrus.Logger) []*logrus.Logger {
+	ls := make([]*logrus.Logger, 0, len(m))
+	for _, l := range m {
+		ls = append(ls, l)
+	}
+	return ls
+}
+
+func parseLevel(lvl string, dflt logrus.Level) (logrus.Level, error) {
+	if lvl == "" {
+		return dflt, nil
+	}
+	l, err := logrus.ParseLevel(lvl)
+	if err != nil {
+		return 0, err
+	}
+	return l, nil
+}
+
+// applyLevels sets the levels of the loggers by the base one and the overrides. mu must be held.
+func applyLevels() {
+	logrus.SetLevel(level)
+	for name, l := range loggers {
+		if o, ok := overrides[name]; ok {
+			l.SetLevel(o)
+		} else {
+			l.SetLevel(level)
+		}
+	}
+}
+
+// SetLevel changes the level at runtime, of the subsystem, or the base one if the subsystem is empty. An empty level
+// of a subsystem removes its override, so it follows the base level again.
+func SetLevel(subsystem, lvl string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if subsystem == "" {
+		l, err := logrus.ParseLevel(lvl)
+		if err != nil {
+			return err
+		}
+		level = l
+	} else {
+		if _, ok := loggers[subsystem]; !ok {
+			return fmt.Errorf("unknown log subsystem %q", subsystem)
+		}
+		if lvl == "" {
+			delete(overrides, subsystem)
+		} else {
+			l, err := logrus.ParseLevel(lvl)
+			if err != nil {
+				return err
+			}
+			overrides[subsystem] = l
+		}
+	}
+	applyLevels()
+	return nil
+}
+
+// ShiftLevel raises the base level by the delta, e.g. 1 from "info" to "debug", or lowers it by a negative one, within
+// "panic" and "trace". It returns the new level.
+func ShiftLevel(delta int) logrus.Level {
+	mu.Lock()
+	defer mu.Unlock()
+	l := int(level) + delta
+	if l < int(logrus.PanicLevel) {
+		l = int(logrus.PanicLevel)
+	}
+	if l > int(logrus.TraceLevel) {
+		l = int(logrus.TraceLevel)
+	}
+	level = logrus.Level(l)
+	applyLevels()
+	return level
+}
+
+// Levels the current levels, the base one and the ones of the subsystems.
+type Levels struct {
+	Level      string            `json:"level"`
+	Subsystems map[string]string `json:"subsystems"`
+}
+
+// CurrentLevels returns the current levels.
+func CurrentLevels() Levels {
+	mu.Lock()
+	defer mu.Unlock()
+	ls := Levels{Level: level.String(), Subsystems: map[string]string{}}
+	for name, l := range loggers {
+		ls.Subsystems[name] = l.GetLevel().String()
+	}
+	return ls
+}
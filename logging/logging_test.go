@@ -0,0 +1,99 @@
+package logging
+
+import (
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetupAndLevels(t *testing.T) {
+	err := Setup(Config{Level: "warning", Format: FormatJson, Output: filepath.Join(t.TempDir(), "gateway.log"),
+		Subsystems: map[string]string{SubsystemProxy: "debug"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = Setup(Config{}) }()
+	if logrus.GetLevel() != logrus.WarnLevel || For(SubsystemDiscovery).GetLevel() != logrus.WarnLevel {
+		t.Errorf("expected the base level warning, but got %v and %v", logrus.GetLevel(), For(SubsystemDiscovery).GetLevel())
+	}
+	if For(SubsystemProxy).GetLevel() != logrus.DebugLevel {
+		t.Errorf("expected the proxy level debug, but got %v", For(SubsystemProxy).GetLevel())
+	}
+
+	if ShiftLevel(1) != logrus.InfoLevel || For(SubsystemReflection).GetLevel() != logrus.InfoLevel {
+		t.Errorf("expected the base level raised to info, but got %v", For(SubsystemReflection).GetLevel())
+	}
+	if For(SubsystemProxy).GetLevel() != logrus.DebugLevel {
+		t.Errorf("expected the overridden proxy level kept, but got %v", For(SubsystemProxy).GetLevel())
+	}
+	if ShiftLevel(100) != logrus.TraceLevel {
+		t.Errorf("expected the level bounded at trace")
+	}
+	if err = SetLevel(SubsystemProxy, ""); err != nil || For(SubsystemProxy).GetLevel() != logrus.TraceLevel {
+		t.Errorf("expected the proxy level to follow the base one, but got %v, %v", For(SubsystemProxy).GetLevel(), err)
+	}
+
+	for _, cfg := range []Config{{Level: "loud"}, {Format: "xml"}, {Subsystems: map[string]string{"nope": "info"}}} {
+		if err = Setup(cfg); err == nil {
+			t.Errorf("expected error of %+v, but got nil", cfg)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	if err := Setup(Config{}); err != nil {
+		t.Fatal(err)
+	}
+	h := Handler()
+	rec := httptest.NewRecorder()
+	form := url.Values{"level": {"error"}, "subsystem": {SubsystemDiscovery}}
+	req := httptest.NewRequest(http.MethodPost, "/debug/loglevel", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.ServeHTTP(rec, req)
+	var levels Levels
+	if err := json.Unmarshal(rec.Body.Bytes(), &levels); err != nil {
+		t.Fatalf("expected the levels, but got %v: %v", rec.Body.String(), err)
+	}
+	if levels.Level != "info" || levels.Subsystems[SubsystemDiscovery] != "error" || levels.Subsystems[SubsystemProxy] != "info" {
+		t.Errorf("expected the discovery level error, but got %+v", levels)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/debug/loglevel?level=loud", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 of invalid level, but got %v", rec.Code)
+	}
+	for _, headers := range []map[string]string{
+		{"Origin": "https://evil.example.com"},
+		{"Origin": "null"},
+		{"Sec-Fetch-Site": "cross-site", "Origin": "http://example.com"},
+	} {
+		rec = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodPost, "http://example.com/debug/loglevel", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("expected status 403 of %v, but got %v", headers, rec.Code)
+		}
+	}
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "http://example.com/debug/loglevel?level=info", nil)
+	req.Header.Set("Origin", "http://example.com")
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status 200 of the same origin, but got %v", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/debug/loglevel", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, but got %v", rec.Code)
+	}
+}
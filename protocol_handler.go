@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"google.golang.org/grpc"
+	"grpc-gateway-x/accesslog"
+	reverse_proxy "grpc-gateway-x/reverse-proxy"
+	"net/http"
+	"strings"
+)
+
+// protocolHandler dispatches the requests on the HTTP listener per their protocol, so the same port serves
+// grpc-web, Connect and native grpc over HTTP/2 along with the other HTTP requests.
+type protocolHandler struct {
+	// grpcServer the native grpc server, nil if not served.
+	grpcServer *grpc.Server
+	// grpcWeb the grpc-web server, nil if not served.
+	grpcWeb *grpcweb.WrappedGrpcServer
+	// connect the Connect protocol handler, nil if disabled.
+	connect http.Handler
+	// fallback the handler of the requests of none of the protocols.
+	fallback    http.Handler
+	originAllow func(origin string) bool
+}
+
+func (h *protocolHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	switch {
+	case h.grpcWeb != nil && (h.grpcWeb.IsGrpcWebRequest(req) || h.grpcWeb.IsAcceptableGrpcCorsRequest(req) || h.grpcWeb.IsGrpcWebSocketRequest(req)):
+		h.grpcWeb.ServeHTTP(resp, req.WithContext(accesslog.WithProtocol(req.Context(), accesslog.ProtocolGrpcWeb)))
+	case h.grpcServer != nil && req.ProtoMajor == 2 && strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc"):
+		h.grpcServer.ServeHTTP(resp, req)
+	case h.connect != nil && isConnectCorsRequest(req):
+		h.serveConnectCors(resp, req)
+	case h.connect != nil && reverse_proxy.IsConnectRequest(req):
+		if origin := req.Header.Get("Origin"); origin != "" && h.originAllow(origin) {
+			resp.Header().Set("Access-Control-Allow-Origin", origin)
+			resp.Header().Set("Access-Control-Expose-Headers", "*")
+			resp.Header().Add("Vary", "Origin")
+		}
+		h.connect.ServeHTTP(resp, req)
+	default:
+		h.fallback.ServeHTTP(resp, req)
+	}
+}
+
+// isConnectCorsRequest reports whether the request is the CORS preflight of a Connect request, which is told by
+// the Connect-Protocol-Version header requested.
+func isConnectCorsRequest(req *http.Request) bool {
+	return req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" &&
+		strings.Contains(strings.ToLower(req.Header.Get("Access-Control-Request-Headers")), "connect-protocol-version")
+}
+
+func (h *protocolHandler) serveConnectCors(resp http.ResponseWriter, req *http.Request) {
+	origin := req.Header.Get("Origin")
+	if origin == "" || !h.originAllow(origin) {
+		resp.WriteHeader(http.StatusForbidden)
+		return
+	}
+	resp.Header().Set("Access-Control-Allow-Origin", origin)
+	resp.Header().Set("Access-Control-Allow-Methods", "POST, GET")
+	resp.Header().Set("Access-Control-Allow-Headers", req.Header.Get("Access-Control-Request-Headers"))
+	resp.Header().Set("Access-Control-Max-Age", "7200")
+	resp.Header().Add("Vary", "Origin")
+	resp.WriteHeader(http.StatusNoContent)
+}
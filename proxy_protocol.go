@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"github.com/pires/go-proxyproto"
+	"github.com/sirupsen/logrus"
+	"net"
+)
+
+// buildProxyProtocolListenerOrFail wraps the listener to parse the HAProxy PROXY protocol v1/v2 header, so the address of the
+// real client is the remote address of the connections. The header is only used from ProxyProtocolTrustedCIDRs, and
+// ignored from the other sources. It fails if ProxyProtocolTrustedCIDRs is empty, as any client could spoof its address then.
+func buildProxyProtocolListenerOrFail(listener net.Listener, cfg *Config) net.Listener {
+	policy, err := proxyProtocolPolicy(cfg.ProxyProtocolTrustedCIDRs)
+	if err != nil {
+		logrus.Fatalf("invalid ProxyProtocolTrustedCIDRs: %v", err)
+	}
+	return &proxyproto.Listener{
+		Listener:          listener,
+		ReadHeaderTimeout: cfg.ProxyProtocolHeaderTimeout,
+		Policy:            policy,
+	}
+}
+
+// proxyProtocolPolicy uses the PROXY headers from the CIDRs only, which must not be empty.
+func proxyProtocolPolicy(trustedCIDRs []string) (proxyproto.PolicyFunc, error) {
+	if len(trustedCIDRs) == 0 {
+		return nil, errors.New("the trusted sources of the PROXY headers must be set")
+	}
+	return proxyproto.LaxWhiteListPolicy(trustedCIDRs)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/pires/go-proxyproto"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProxyProtocolListener(t *testing.T) {
+	client := &net.TCPAddr{IP: net.ParseIP("203.0.113.9"), Port: 5000}
+	cases := []struct {
+		name    string
+		trusted []string
+		version byte
+		expect  string
+	}{
+		{"v1 trusted", []string{"127.0.0.0/8"}, 1, "203.0.113.9"},
+		{"v2 trusted", []string{"127.0.0.0/8"}, 2, "203.0.113.9"},
+		{"v1 untrusted", []string{"10.0.0.0/8"}, 1, "127.0.0.1"},
+	}
+	for _, c := range cases {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener := buildProxyProtocolListenerOrFail(lis, &Config{ProxyProtocolTrustedCIDRs: c.trusted, ProxyProtocolHeaderTimeout: time.Second})
+		go func() {
+			conn, err := net.Dial("tcp", lis.Addr().String())
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, _ = proxyproto.HeaderProxyFromAddrs(c.version, client, conn.RemoteAddr()).WriteTo(conn)
+			_, _ = conn.Write([]byte("hi"))
+		}()
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, 2)
+		if _, err = io.ReadFull(conn, data); err != nil || string(data) != "hi" {
+			t.Errorf("%v: expected the data after the header, but got %q, %v", c.name, data, err)
+		}
+		if host, _, _ := net.SplitHostPort(conn.RemoteAddr().String()); host != c.expect {
+			t.Errorf("%v: expected remote address %v, but got %v", c.name, c.expect, conn.RemoteAddr())
+		}
+		_ = conn.Close()
+		_ = listener.Close()
+	}
+}
+
+func TestProxyProtocolPolicy(t *testing.T) {
+	if _, err := proxyProtocolPolicy(nil); err == nil {
+		t.Error("expected error on no trusted sources")
+	}
+	if _, err := proxyProtocolPolicy([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("expected error on invalid CIDR")
+	}
+	if _, err := proxyProtocolPolicy([]string{"10.0.0.0/8"}); err != nil {
+		t.Errorf("expected no error, but got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package reverse_proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"google.golang.org/grpc/credentials"
+	"net"
+	"strings"
+)
+
+// BackendTlsServerName the server name verified against the certificates of the backends serving the route, e.g. when
+// the backends are dialed by the addresses discovered, whose certificates are issued for the service names.
+type BackendTlsServerName struct {
+	// Route the prefix of the full method names, e.g. "/com.example.billing.". the longest matching route takes effect.
+	Route string
+	// ServerName the name sent by SNI and verified against the backend certificates, e.g. "billing.internal.example".
+	ServerName string
+}
+
+// ReloadBackendTls re-reads the backend TLS files, which take effect on the new connections to the backends and the
+// reconnections of the existing ones. On failure the previously loaded ones stay in use.
+func (grp *GrpcReverseProxy) ReloadBackendTls() error {
+	if grp.backendCerts == nil {
+		return nil
+	}
+	return grp.backendCerts.Reload()
+}
+
+// BackendTlsFiles returns the backend TLS files to be watched for changes.
+func (grp *GrpcReverseProxy) BackendTlsFiles() []string {
+	if grp.backendCerts == nil {
+		return nil
+	}
+	return grp.backendCerts.Files()
+}
+
+// backendTlsConfig builds the TLS config of all the backend connections, both of the proxied calls and the reflection,
+// by the currently loaded client certificate and CA bundle. The serverName overrides the one of the dialed address if set.
+func (grp *GrpcReverseProxy) backendTlsConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		ServerName:           serverName,
+		InsecureSkipVerify:   !grp.opts.BackendTlsVerifyCert,
+		RootCAs:              grp.backendCerts.CAs(),
+		GetClientCertificate: grp.backendCerts.GetClientCertificate,
+	}
+}
+
+// backendCredentials returns the transport credentials building the TLS config on each handshake, so the reloaded CA
+// bundle takes effect on the reconnections of the existing backend connections too.
+func (grp *GrpcReverseProxy) backendCredentials(serverName string) credentials.TransportCredentials {
+	return &reloadingTlsCredentials{
+		TransportCredentials: credentials.NewTLS(grp.backendTlsConfig(serverName)),
+		build:                func() *tls.Config { return grp.backendTlsConfig(serverName) },
+	}
+}
+
+type reloadingTlsCredentials struct {
+	credentials.TransportCredentials
+	build func() *tls.Config
+}
+
+func (c *reloadingTlsCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return credentials.NewTLS(c.build()).ClientHandshake(ctx, authority, conn)
+}
+
+func (c *reloadingTlsCredentials) Clone() credentials.TransportCredentials {
+	return &reloadingTlsCredentials{TransportCredentials: c.TransportCredentials.Clone(), build: c.build}
+}
+
+// backendServerName returns the server name of the longest route of the BackendTlsServerNames matching the method,
+// empty if none.
+func (grp *GrpcReverseProxy) backendServerName(fullMethodName string) string {
+	var matched *BackendTlsServerName
+	for i, sn := range grp.opts.BackendTlsServerNames {
+		if strings.HasPrefix(fullMethodName, sn.Route) && (matched == nil || len(sn.Route) > len(matched.Route)) {
+			matched = &grp.opts.BackendTlsServerNames[i]
+		}
+	}
+	if matched == nil {
+		return ""
+	}
+	return matched.ServerName
+}
+
+// backendServerNameOf returns the server name of the backend serving the methods of the prefix, e.g. "/com.example.billing."
+// for the reflection of it: the one of the longest route covering the prefix, or else the one shared by all the routes
+// within the prefix, empty if none or they differ.
+func (grp *GrpcReverseProxy) backendServerNameOf(prefix string) string {
+	if serverName := grp.backendServerName(prefix); serverName != "" {
+		return serverName
+	}
+	serverName := ""
+	for _, sn := range grp.opts.BackendTlsServerNames {
+		if !strings.HasPrefix(sn.Route, prefix) {
+			continue
+		}
+		if serverName != "" && serverName != sn.ServerName {
+			return ""
+		}
+		serverName = sn.ServerName
+	}
+	return serverName
+}
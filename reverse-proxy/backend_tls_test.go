@@ -0,0 +1,242 @@
+package reverse_proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// issueTestCert issues a certificate of the name by the CA, or a self-signed CA if ca is nil, and writes the PEM files.
+// The name is the IP address SAN if it is an IP address.
+func issueTestCert(t *testing.T, dir, name string, ca *tls.Certificate) (tls.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{name}
+	}
+	parent, parentKey := tmpl, interface{}(key)
+	if ca == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid, tmpl.KeyUsage = true, true, x509.KeyUsageCertSign
+	} else {
+		parent, parentKey = ca.Leaf, ca.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key.pem")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, certFile, keyFile
+}
+
+// startTlsBackend starts the health service serving the certificate.
+func startTlsBackend(t *testing.T, cert tls.Certificate) string {
+	srv := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{cert}})))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+func TestBackendTlsVerification(t *testing.T) {
+	dir := t.TempDir()
+	ca, caFile, _ := issueTestCert(t, dir, "ca", nil)
+	ipCert, _, _ := issueTestCert(t, dir, "127.0.0.1", &ca)
+	otherCert, _, _ := issueTestCert(t, dir, "other.example", &ca)
+	newProxy := func(addr string) *GrpcReverseProxy {
+		rp, err := NewReverseProxy(WithBackendAddr(addr), WithBackendTlsVerifyCert(true), WithBackendTlsCaFile(caFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rp
+	}
+	dial := func(rp *GrpcReverseProxy, addr string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		conn, err := rp.DialBackend(ctx, addr, grpc.FailOnNonTempDialError(true))
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err
+	}
+	call := func(rp *GrpcReverseProxy) error {
+		conn, err := rp.resolveServerConnection(context.Background(), "/grpc.health.v1.Health/Check")
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		return err
+	}
+
+	addr := startTlsBackend(t, ipCert)
+	rp := newProxy(addr)
+	if err := dial(rp, addr); err != nil {
+		t.Errorf("expected the reflection dial verified, but got %v", err)
+	}
+	if err := call(rp); err != nil {
+		t.Errorf("expected the proxied call verified, but got %v", err)
+	}
+
+	// the backend dialed by the IP address presenting a certificate of another name of the same CA is rejected.
+	otherAddr := startTlsBackend(t, otherCert)
+	otherRp := newProxy(otherAddr)
+	if err := dial(otherRp, otherAddr); err == nil {
+		t.Errorf("expected error of the reflection dial to the mismatched certificate, but got nil")
+	}
+	if err := call(otherRp); err == nil {
+		t.Errorf("expected error of the proxied call to the mismatched certificate, but got nil")
+	}
+
+	// the backend certificate is no longer trusted once the CA is rotated.
+	_, anotherCaFile, _ := issueTestCert(t, dir, "another-ca", nil)
+	data, _ := os.ReadFile(anotherCaFile)
+	if err := os.WriteFile(caFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := rp.ReloadBackendTls(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dial(rp, addr); err == nil {
+		t.Errorf("expected error of the untrusted certificate after the reload, but got nil")
+	}
+}
+
+func TestBackendMutualTls(t *testing.T) {
+	dir := t.TempDir()
+	ca, caFile, _ := issueTestCert(t, dir, "ca", nil)
+	serverCert, _, _ := issueTestCert(t, dir, "backend.example", &ca)
+	_, clientCertFile, clientKeyFile := issueTestCert(t, dir, "gateway", &ca)
+	cas := x509.NewCertPool()
+	cas.AddCert(ca.Leaf)
+	srv := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    cas,
+	})))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	newProxy := func(opts ...GrpcReverseProxyOption) *GrpcReverseProxy {
+		opts = append([]GrpcReverseProxyOption{
+			WithBackendAddr(lis.Addr().String()),
+			WithBackendTlsVerifyCert(true),
+			WithBackendTlsCaFile(caFile),
+		}, opts...)
+		rp, err := NewReverseProxy(opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rp
+	}
+	check := func(rp *GrpcReverseProxy) error {
+		conn, err := rp.resolveServerConnection(context.Background(), "/grpc.health.v1.Health/Check")
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		return err
+	}
+
+	rp := newProxy(
+		WithBackendTlsClientCert(clientCertFile, clientKeyFile),
+		WithBackendTlsServerNames(BackendTlsServerName{Route: "/grpc.health.", ServerName: "backend.example"}),
+	)
+	if err = check(rp); err != nil {
+		t.Errorf("expected the call by mTLS succeeded, but got %v", err)
+	}
+	// the reflection dialer shares the TLS config, verifying the host of the address.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err = rp.DialBackend(ctx, lis.Addr().String(), grpc.FailOnNonTempDialError(true)); err == nil {
+		t.Errorf("expected error of the mismatched server name, but got nil")
+	}
+	// the reflection verifies the server name of the services it reflects.
+	eps, err := rp.reflectionEndpoints(context.Background())
+	if err != nil || len(eps) != 1 || eps[0].serverName != "backend.example" {
+		t.Fatalf("expected the reflection endpoint of backend.example, but got %v, %v", eps, err)
+	}
+	dialCtx, dialCancel := context.WithTimeout(context.Background(), time.Second)
+	defer dialCancel()
+	conn, err := rp.dialBackend(dialCtx, eps[0].addr, eps[0].serverName, grpc.FailOnNonTempDialError(true))
+	if err != nil {
+		t.Errorf("expected the reflection dial succeeded, but got %v", err)
+	} else {
+		_ = conn.Close()
+	}
+	if err = check(newProxy(WithBackendTlsServerNames(BackendTlsServerName{Route: "/grpc.health.", ServerName: "backend.example"}))); err == nil {
+		t.Errorf("expected error without the client certificate, but got nil")
+	}
+}
+
+func TestBackendServerName(t *testing.T) {
+	rp := &GrpcReverseProxy{opts: &GrpcReverseProxyOptions{BackendTlsServerNames: []BackendTlsServerName{
+		{Route: "/com.example.", ServerName: "example.internal"},
+		{Route: "/com.example.billing.", ServerName: "billing.internal"},
+	}}}
+	cases := map[string]string{
+		"/com.example.billing.Invoices/Get": "billing.internal",
+		"/com.example.Books/Get":            "example.internal",
+		"/org.other.Svc/Call":               "",
+	}
+	for method, expected := range cases {
+		if actual := rp.backendServerName(method); actual != expected {
+			t.Errorf("%v: expected %q, but got %q", method, expected, actual)
+		}
+	}
+	for prefix, expected := range map[string]string{
+		"/com.example.billing.": "billing.internal",
+		"/com.example.":         "example.internal",
+		"/":                     "",
+		"/com.":                 "",
+	} {
+		if actual := rp.backendServerNameOf(prefix); actual != expected {
+			t.Errorf("%v: expected %q, but got %q", prefix, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,466 @@
+package reverse_proxy
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	connectFlagCompressed = 0x01
+	connectFlagEndStream  = 0x02
+)
+
+// connectRequestHeaders the headers of the Connect protocol itself, which are not forwarded to the backends.
+var connectRequestHeaders = []string{
+	"Connect-Protocol-Version",
+	"Connect-Timeout-Ms",
+	"Connect-Content-Encoding",
+	"Connect-Accept-Encoding",
+	"Content-Encoding",
+	"Accept-Encoding",
+}
+
+var connectCodeNames = map[codes.Code]string{
+	codes.Canceled:           "canceled",
+	codes.Unknown:            "unknown",
+	codes.InvalidArgument:    "invalid_argument",
+	codes.DeadlineExceeded:   "deadline_exceeded",
+	codes.NotFound:           "not_found",
+	codes.AlreadyExists:      "already_exists",
+	codes.PermissionDenied:   "permission_denied",
+	codes.ResourceExhausted:  "resource_exhausted",
+	codes.FailedPrecondition: "failed_precondition",
+	codes.Aborted:            "aborted",
+	codes.OutOfRange:         "out_of_range",
+	codes.Unimplemented:      "unimplemented",
+	codes.Internal:           "internal",
+	codes.Unavailable:        "unavailable",
+	codes.DataLoss:           "data_loss",
+	codes.Unauthenticated:    "unauthenticated",
+}
+
+// connectHTTPStatuses the HTTP status codes of the unary call errors by the Connect protocol, which differs from the
+// google.api.http mapping, e.g. UNIMPLEMENTED is 404.
+var connectHTTPStatuses = map[codes.Code]int{
+	codes.Canceled:           http.StatusRequestTimeout,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusRequestTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusPreconditionFailed,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotFound,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+// IsConnectRequest reports whether the request is of the Connect protocol, i.e. one of
+//
+//   - the streaming requests of the content type application/connect+proto or application/connect+json.
+//   - the unary requests of the content type application/proto.
+//   - the unary requests of the content type application/json with the Connect-Protocol-Version header,
+//     to be told from the REST/JSON ones.
+func IsConnectRequest(r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch ct {
+	case "application/connect+proto", "application/connect+json", "application/proto":
+		return true
+	case "application/json":
+		return r.Header.Get("Connect-Protocol-Version") != ""
+	}
+	return false
+}
+
+// ConnectHandler is the HTTP handler which translates the Connect protocol requests, see https://connect.build/docs/protocol,
+// into the grpc calls through the director of the proxy. The proto encoded messages are forwarded as they are, while the
+// JSON encoded ones are converted with the method descriptors from the catalog.
+type ConnectHandler struct {
+	grp *GrpcReverseProxy
+}
+
+func (grp *GrpcReverseProxy) ConnectHandler() *ConnectHandler {
+	return &ConnectHandler{grp: grp}
+}
+
+func (h *ConnectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	streaming := strings.HasPrefix(ct, "application/connect+")
+	codec := &connectCodec{json: strings.HasSuffix(ct, "json")}
+	writeError := func(err error) {
+		if streaming {
+			w.Header().Set("Content-Type", ct)
+			w.WriteHeader(http.StatusOK)
+			_ = writeConnectEnvelope(w, connectFlagEndStream, marshalConnectEndStream(err, nil))
+			return
+		}
+		writeConnectError(w, err)
+	}
+	fullMethodName := r.URL.Path
+	desc := &grpc.StreamDesc{ServerStreams: streaming, ClientStreams: streaming}
+	var callOpts []grpc.CallOption
+	if codec.json {
+		catalog, err := h.grp.ServiceCatalog(r.Context())
+		if err != nil {
+			writeError(status.Errorf(codes.Unavailable, "failed resolving services: %v", err))
+			return
+		}
+		md, ok := catalog.FindMethod(fullMethodName)
+		if !ok {
+			writeError(status.Errorf(codes.Unimplemented, "unknown method %v", fullMethodName))
+			return
+		}
+		codec.method, codec.resolver = md, catalog.Resolver()
+		desc.ServerStreams, desc.ClientStreams = md.IsStreamingServer(), md.IsStreamingClient()
+	} else {
+		callOpts = append(callOpts, grpc.ForceCodec(rawCodec{}))
+	}
+	if enc := r.Header.Get("Content-Encoding"); !streaming && enc != "" && enc != "identity" && enc != "gzip" {
+		writeError(status.Errorf(codes.Unimplemented, "unsupported content encoding %v", enc))
+		return
+	}
+
+	fwd := r.Clone(r.Context())
+	for _, k := range connectRequestHeaders {
+		fwd.Header.Del(k)
+	}
+	ctx := IncomingContextFromHTTPRequest(fwd)
+	if ms := r.Header.Get("Connect-Timeout-Ms"); ms != "" {
+		timeout, err := strconv.ParseInt(ms, 10, 64)
+		if err != nil {
+			writeError(status.Errorf(codes.InvalidArgument, "invalid Connect-Timeout-Ms %v", ms))
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Millisecond)
+		defer cancel()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	outCtx, backendConn, err := h.grp.streamDirector(ctx, fullMethodName)
+	var stream grpc.ClientStream
+	if err == nil {
+		stream, err = grpc.NewClientStream(outCtx, desc, backendConn, fullMethodName, callOpts...)
+	}
+	if err != nil {
+		writeError(err)
+		return
+	}
+	if streaming {
+		h.serveStream(w, r, ct, codec, stream, cancel)
+	} else {
+		h.serveUnary(w, r, ct, codec, stream)
+	}
+}
+
+func (h *ConnectHandler) serveUnary(w http.ResponseWriter, r *http.Request, ct string, codec *connectCodec, stream grpc.ClientStream) {
+	h.grp.limitRequestBody(w, r)
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			writeConnectError(w, status.Errorf(codes.InvalidArgument, "invalid gzip body: %v", err))
+			return
+		}
+		defer func() { _ = gz.Close() }()
+		body = gz
+	}
+	// the decompressed body is limited as well as the compressed one.
+	maxSize := h.grp.opts.MaxMessageSize
+	b, err := io.ReadAll(io.LimitReader(body, int64(maxSize)+1))
+	if err == nil && len(b) > maxSize {
+		err = &http.MaxBytesError{Limit: int64(maxSize)}
+	}
+	if err != nil {
+		writeConnectError(w, requestBodyError(err))
+		return
+	}
+	req, err := codec.decodeRequest(b)
+	if err != nil {
+		writeConnectError(w, status.Errorf(codes.InvalidArgument, "invalid request: %v", err))
+		return
+	}
+	if err = stream.SendMsg(req); err == nil {
+		err = stream.CloseSend()
+	}
+	resp := codec.newResponse()
+	if err == nil {
+		err = stream.RecvMsg(resp)
+	}
+	if header, hErr := stream.Header(); hErr == nil {
+		writeResponseMetadata(w.Header(), header, "")
+	}
+	writeResponseMetadata(w.Header(), stream.Trailer(), "Trailer-")
+	if err != nil {
+		writeConnectError(w, err)
+		return
+	}
+	if b, err = codec.encode(resp); err != nil {
+		writeConnectError(w, status.Errorf(codes.Internal, "failed encoding response: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", ct)
+	_, _ = w.Write(b)
+}
+
+func (h *ConnectHandler) serveStream(w http.ResponseWriter, r *http.Request, ct string, codec *connectCodec, stream grpc.ClientStream, cancel context.CancelFunc) {
+	// the request messages are read concurrently, so the bidi streams work over HTTP/2.
+	sendErr := make(chan error, 1)
+	go func() {
+		err := func() error {
+			for {
+				flags, b, err := readConnectEnvelope(r.Body, h.grp.opts.MaxMessageSize)
+				if err == io.EOF {
+					return stream.CloseSend()
+				}
+				if err != nil {
+					if _, ok := status.FromError(err); ok {
+						return err
+					}
+					return status.Errorf(codes.InvalidArgument, "failed reading request: %v", err)
+				}
+				if flags&connectFlagCompressed != 0 {
+					return status.Error(codes.Unimplemented, "compressed messages are not supported")
+				}
+				req, err := codec.decodeRequest(b)
+				if err != nil {
+					return status.Errorf(codes.InvalidArgument, "invalid request: %v", err)
+				}
+				if err = stream.SendMsg(req); err != nil {
+					// the error of the call is to be received by RecvMsg.
+					return nil
+				}
+			}
+		}()
+		if err != nil {
+			cancel()
+		}
+		sendErr <- err
+	}()
+
+	// the body must not be read once the handler returns, so the sender is stopped by closing the body and the stream,
+	// and waited for, if the handler returns before it's done.
+	stopSender := func() {
+		cancel()
+		_ = r.Body.Close()
+		<-sendErr
+	}
+
+	flusher, _ := w.(http.Flusher)
+	if header, err := stream.Header(); err == nil {
+		writeResponseMetadata(w.Header(), header, "")
+	}
+	w.Header().Set("Content-Type", ct)
+	w.WriteHeader(http.StatusOK)
+	var err error
+	for {
+		resp := codec.newResponse()
+		if err = stream.RecvMsg(resp); err != nil {
+			break
+		}
+		var b []byte
+		if b, err = codec.encode(resp); err != nil {
+			err = status.Errorf(codes.Internal, "failed encoding response: %v", err)
+			cancel()
+			break
+		}
+		if err = writeConnectEnvelope(w, 0, b); err != nil {
+			stopSender()
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	select {
+	case sErr := <-sendErr:
+		if sErr != nil {
+			err = sErr
+		}
+	default:
+		stopSender()
+	}
+	_ = writeConnectEnvelope(w, connectFlagEndStream, marshalConnectEndStream(err, stream.Trailer()))
+}
+
+// connectCodec encodes and decodes the messages, either as the raw proto bytes or per the method descriptor in JSON.
+type connectCodec struct {
+	json     bool
+	method   protoreflect.MethodDescriptor
+	resolver *CatalogTypeResolver
+}
+
+func (c *connectCodec) decodeRequest(b []byte) (interface{}, error) {
+	if !c.json {
+		return &rawFrame{payload: b}, nil
+	}
+	req := dynamicpb.NewMessage(c.method.Input())
+	if len(b) == 0 {
+		return req, nil
+	}
+	return req, jsonUnmarshalOptions(c.resolver).Unmarshal(b, req)
+}
+
+func (c *connectCodec) newResponse() interface{} {
+	if !c.json {
+		return &rawFrame{}
+	}
+	return dynamicpb.NewMessage(c.method.Output())
+}
+
+func (c *connectCodec) encode(m interface{}) ([]byte, error) {
+	if !c.json {
+		return m.(*rawFrame).payload, nil
+	}
+	opts := jsonMarshalOptions(c.resolver)
+	opts.EmitUnpopulated = false
+	return opts.Marshal(m.(proto.Message))
+}
+
+// rawFrame holds the encoded message forwarded as it is.
+type rawFrame struct {
+	payload []byte
+}
+
+// rawCodec passes the rawFrame through without decoding.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	f, ok := v.(*rawFrame)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+	return f.payload, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	f, ok := v.(*rawFrame)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	f.payload = append(f.payload[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// readConnectEnvelope reads the next enveloped message, failing with RESOURCE_EXHAUSTED if it's larger than maxSize.
+func readConnectEnvelope(r io.Reader, maxSize int) (byte, []byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, errors.New("truncated envelope")
+		}
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if int64(size) > int64(maxSize) {
+		return 0, nil, status.Errorf(codes.ResourceExhausted, "message of %d bytes larger than %d bytes", size, maxSize)
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, errors.New("truncated envelope")
+	}
+	return prefix[0], b, nil
+}
+
+func writeConnectEnvelope(w io.Writer, flags byte, b []byte) error {
+	var prefix [5]byte
+	prefix[0] = flags
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(b)))
+	if _, err := w.Write(prefix[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+type connectErrorDetail struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type connectError struct {
+	Code    string               `json:"code"`
+	Message string               `json:"message,omitempty"`
+	Details []connectErrorDetail `json:"details,omitempty"`
+}
+
+func newConnectError(err error) *connectError {
+	st := status.Convert(err)
+	e := &connectError{Code: connectCodeNames[st.Code()], Message: st.Message()}
+	if e.Code == "" {
+		e.Code = connectCodeNames[codes.Unknown]
+	}
+	for _, d := range st.Proto().GetDetails() {
+		typ := d.GetTypeUrl()
+		if i := strings.LastIndexByte(typ, '/'); i >= 0 {
+			typ = typ[i+1:]
+		}
+		e.Details = append(e.Details, connectErrorDetail{
+			Type:  typ,
+			Value: base64.RawStdEncoding.EncodeToString(d.GetValue()),
+		})
+	}
+	return e
+}
+
+// writeConnectError writes the error of a unary call as the Connect error JSON with the HTTP status code mapped by
+// the Connect protocol.
+func writeConnectError(w http.ResponseWriter, err error) {
+	b, _ := json.Marshal(newConnectError(err))
+	httpStatus, ok := connectHTTPStatuses[status.Code(err)]
+	if !ok {
+		httpStatus = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	_, _ = w.Write(b)
+}
+
+// marshalConnectEndStream marshals the end of stream message with the error if any, and the trailers.
+func marshalConnectEndStream(err error, trailer metadata.MD) []byte {
+	end := struct {
+		Error    *connectError       `json:"error,omitempty"`
+		Metadata map[string][]string `json:"metadata,omitempty"`
+	}{}
+	if err != nil {
+		end.Error = newConnectError(err)
+	}
+	if len(trailer) > 0 {
+		end.Metadata = trailer
+	}
+	b, _ := json.Marshal(end)
+	return b
+}
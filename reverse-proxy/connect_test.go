@@ -0,0 +1,133 @@
+package reverse_proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConnectHandlerUnary(t *testing.T) {
+	rp := startTestBackend(t)
+	h := rp.ConnectHandler()
+
+	r := httptest.NewRequest(http.MethodPost, "/grpc.health.v1.Health/Check", strings.NewReader(`{"service": "books"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Connect-Protocol-Version", "1")
+	if !IsConnectRequest(r) {
+		t.Fatal("expected a Connect request")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"NOT_SERVING"`) {
+		t.Errorf("unexpected JSON response %v: %v", w.Code, w.Body.String())
+	}
+
+	b, _ := proto.Marshal(&healthpb.HealthCheckRequest{Service: "books"})
+	r = httptest.NewRequest(http.MethodPost, "/grpc.health.v1.Health/Check", bytes.NewReader(b))
+	r.Header.Set("Content-Type", "application/proto")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	resp := &healthpb.HealthCheckResponse{}
+	if err := proto.Unmarshal(w.Body.Bytes(), resp); err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("unexpected proto response %v: %v, %v", w.Code, resp, err)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/grpc.health.v1.Health/Check", strings.NewReader(`{"service": "unknown"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Connect-Protocol-Version", "1")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	e := &connectError{}
+	if err := json.Unmarshal(w.Body.Bytes(), e); err != nil || w.Code != http.StatusNotFound || e.Code != "not_found" {
+		t.Errorf("unexpected error response %v: %v", w.Code, w.Body.String())
+	}
+}
+
+func TestConnectHandlerStream(t *testing.T) {
+	rp := startTestBackend(t)
+	srv := httptest.NewServer(rp.ConnectHandler())
+	defer srv.Close()
+
+	body := &bytes.Buffer{}
+	_ = writeConnectEnvelope(body, 0, []byte(`{"service": "books"}`))
+	resp, err := http.Post(srv.URL+"/grpc.health.v1.Health/Watch", "application/connect+json", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(resp *http.Response) { _ = resp.Body.Close() }(resp)
+	flags, b, err := readConnectEnvelope(resp.Body, DefaultMaxMessageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags != 0 || !strings.Contains(string(b), `"NOT_SERVING"`) {
+		t.Errorf("unexpected message %v: %s", flags, b)
+	}
+
+	body.Reset()
+	_ = writeConnectEnvelope(body, 0, []byte(`{"service": 1}`))
+	resp, err = http.Post(srv.URL+"/grpc.health.v1.Health/Watch", "application/connect+json", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(resp *http.Response) { _ = resp.Body.Close() }(resp)
+	flags, b, err = readConnectEnvelope(resp.Body, DefaultMaxMessageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags != connectFlagEndStream || !strings.Contains(string(b), `"invalid_argument"`) {
+		t.Errorf("unexpected end of stream %v: %s", flags, b)
+	}
+}
+
+func TestConnectHandlerLimits(t *testing.T) {
+	rp := startTestBackend(t, WithMaxMessageSize(64))
+	h := rp.ConnectHandler()
+	large := `{"service": "` + strings.Repeat("x", 64) + `"}`
+
+	// the gzip compressed body is small, but not the decompressed one.
+	compressed := &bytes.Buffer{}
+	gz := gzip.NewWriter(compressed)
+	_, _ = gz.Write([]byte(large))
+	_ = gz.Close()
+	r := httptest.NewRequest(http.MethodPost, "/grpc.health.v1.Health/Check", compressed)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Content-Encoding", "gzip")
+	r.Header.Set("Connect-Protocol-Version", "1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), `"resource_exhausted"`) {
+		t.Errorf("unexpected response of the large body %v: %v", w.Code, w.Body.String())
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/grpc.health.v1.Health/Nope", strings.NewReader(`{}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Connect-Protocol-Version", "1")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), `"unimplemented"`) {
+		t.Errorf("unexpected response of the unknown method %v: %v", w.Code, w.Body.String())
+	}
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	// the envelope claims a message far larger than the limit, without sending it.
+	body := bytes.NewReader([]byte{0, 0xff, 0xff, 0xff, 0xff})
+	resp, err := http.Post(srv.URL+"/grpc.health.v1.Health/Watch", "application/connect+json", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(resp *http.Response) { _ = resp.Body.Close() }(resp)
+	flags, b, err := readConnectEnvelope(resp.Body, DefaultMaxMessageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags != connectFlagEndStream || !strings.Contains(string(b), `"resource_exhausted"`) {
+		t.Errorf("unexpected end of stream %v: %s", flags, b)
+	}
+}
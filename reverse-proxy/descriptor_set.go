@@ -0,0 +1,221 @@
+package reverse_proxy
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"os"
+	"sync"
+)
+
+// descriptorSetStore holds the file descriptors loaded from compiled FileDescriptorSet files,
+// e.g. the output of `protoc --descriptor_set_out` or `buf build -o`.
+type descriptorSetStore struct {
+	sync.RWMutex
+	paths []string
+	files *protoregistry.Files
+	// raw serialized FileDescriptorProto by file name, as served by the reflection service.
+	raw map[string][]byte
+	// generation is increased on each successful load.
+	generation uint64
+}
+
+func newDescriptorSetStore(paths []string) *descriptorSetStore {
+	return &descriptorSetStore{
+		paths: paths,
+		files: &protoregistry.Files{},
+		raw:   map[string][]byte{},
+	}
+}
+
+// load (re)reads all the descriptor set files. The previously loaded descriptors are kept if any file fails to load.
+func (s *descriptorSetStore) load() error {
+	fdps := map[string]*descriptorpb.FileDescriptorProto{}
+	var order []string
+	for _, p := range s.paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return fmt.Errorf("failed reading descriptor set file %v: %v", p, err)
+		}
+		fds := &descriptorpb.FileDescriptorSet{}
+		if err = proto.Unmarshal(b, fds); err != nil {
+			return fmt.Errorf("failed parsing descriptor set file %v: %v", p, err)
+		}
+		for _, fdp := range fds.File {
+			if _, ok := fdps[fdp.GetName()]; ok {
+				continue
+			}
+			fdps[fdp.GetName()] = fdp
+			order = append(order, fdp.GetName())
+		}
+	}
+	files := &protoregistry.Files{}
+	raw := map[string][]byte{}
+	var register func(name string) error
+	register = func(name string) error {
+		if _, err := files.FindFileByPath(name); err == nil {
+			return nil
+		}
+		fdp, ok := fdps[name]
+		if !ok {
+			return fmt.Errorf("dependency %v not found in descriptor sets", name)
+		}
+		for _, dep := range fdp.Dependency {
+			if err := register(dep); err != nil {
+				return err
+			}
+		}
+		fd, err := protodesc.NewFile(fdp, files)
+		if err != nil {
+			return fmt.Errorf("invalid file descriptor %v: %v", name, err)
+		}
+		if err = files.RegisterFile(fd); err != nil {
+			return err
+		}
+		raw[name], err = proto.Marshal(fdp)
+		return err
+	}
+	for _, name := range order {
+		if err := register(name); err != nil {
+			return err
+		}
+	}
+	s.Lock()
+	s.files = files
+	s.raw = raw
+	s.generation++
+	s.Unlock()
+	return nil
+}
+
+// fileWithDependencies returns the serialized file descriptor of fd followed by its transitive dependencies.
+func (s *descriptorSetStore) fileWithDependencies(fd protoreflect.FileDescriptor) [][]byte {
+	var out [][]byte
+	seen := map[string]struct{}{}
+	var walk func(fd protoreflect.FileDescriptor)
+	walk = func(fd protoreflect.FileDescriptor) {
+		if _, ok := seen[fd.Path()]; ok {
+			return
+		}
+		seen[fd.Path()] = struct{}{}
+		if b, ok := s.raw[fd.Path()]; ok {
+			out = append(out, b)
+		}
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			walk(imports.Get(i).FileDescriptor)
+		}
+	}
+	walk(fd)
+	return out
+}
+
+func (s *descriptorSetStore) FileByFilename(name string) [][]byte {
+	s.RLock()
+	defer s.RUnlock()
+	fd, err := s.files.FindFileByPath(name)
+	if err != nil {
+		return nil
+	}
+	return s.fileWithDependencies(fd)
+}
+
+func (s *descriptorSetStore) FileContainingSymbol(symbol string) [][]byte {
+	s.RLock()
+	defer s.RUnlock()
+	d, err := s.files.FindDescriptorByName(protoreflect.FullName(symbol))
+	if err != nil {
+		return nil
+	}
+	return s.fileWithDependencies(d.ParentFile())
+}
+
+func (s *descriptorSetStore) FileContainingExtension(extendee string, number int32) [][]byte {
+	s.RLock()
+	defer s.RUnlock()
+	var found protoreflect.FileDescriptor
+	s.rangeExtensions(func(xd protoreflect.ExtensionDescriptor) bool {
+		if string(xd.ContainingMessage().FullName()) == extendee && int32(xd.Number()) == number {
+			found = xd.ParentFile()
+			return false
+		}
+		return true
+	})
+	if found == nil {
+		return nil
+	}
+	return s.fileWithDependencies(found)
+}
+
+func (s *descriptorSetStore) AllExtensionNumbersOfType(extendee string) []int32 {
+	s.RLock()
+	defer s.RUnlock()
+	var numbers []int32
+	s.rangeExtensions(func(xd protoreflect.ExtensionDescriptor) bool {
+		if string(xd.ContainingMessage().FullName()) == extendee {
+			numbers = append(numbers, int32(xd.Number()))
+		}
+		return true
+	})
+	return numbers
+}
+
+func (s *descriptorSetStore) ListServices() []string {
+	s.RLock()
+	defer s.RUnlock()
+	var services []string
+	s.files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		for i := 0; i < fd.Services().Len(); i++ {
+			services = append(services, string(fd.Services().Get(i).FullName()))
+		}
+		return true
+	})
+	return services
+}
+
+func (s *descriptorSetStore) Generation() uint64 {
+	s.RLock()
+	defer s.RUnlock()
+	return s.generation
+}
+
+// snapshot returns the currently loaded files and the names of the services in them.
+// The files are replaced as a whole on reloading, so the returned registry is never modified afterwards.
+func (s *descriptorSetStore) snapshot() (*protoregistry.Files, []protoreflect.FullName) {
+	s.RLock()
+	defer s.RUnlock()
+	var services []protoreflect.FullName
+	s.files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		for i := 0; i < fd.Services().Len(); i++ {
+			services = append(services, fd.Services().Get(i).FullName())
+		}
+		return true
+	})
+	return s.files, services
+}
+
+func (s *descriptorSetStore) rangeExtensions(f func(protoreflect.ExtensionDescriptor) bool) {
+	var walkMessages func(mds protoreflect.MessageDescriptors) bool
+	walkExtensions := func(xds protoreflect.ExtensionDescriptors) bool {
+		for i := 0; i < xds.Len(); i++ {
+			if !f(xds.Get(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	walkMessages = func(mds protoreflect.MessageDescriptors) bool {
+		for i := 0; i < mds.Len(); i++ {
+			if !walkExtensions(mds.Get(i).Extensions()) || !walkMessages(mds.Get(i).Messages()) {
+				return false
+			}
+		}
+		return true
+	}
+	s.files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		return walkExtensions(fd.Extensions()) && walkMessages(fd.Messages())
+	})
+}
@@ -0,0 +1,50 @@
+package reverse_proxy
+
+import (
+	grpcReflection "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDescriptorSetStore(t *testing.T) {
+	fds := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{protodesc.ToFileDescriptorProto(grpcReflection.File_grpc_reflection_v1alpha_reflection_proto)},
+	}
+	b, err := proto.Marshal(fds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "reflection.protoset")
+	if err = os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := newDescriptorSetStore([]string{path})
+	if err = s.load(); err != nil {
+		t.Fatal(err)
+	}
+	services := s.ListServices()
+	if len(services) != 1 || services[0] != "grpc.reflection.v1alpha.ServerReflection" {
+		t.Errorf("unexpected services: %v", services)
+	}
+	if files := s.FileContainingSymbol("grpc.reflection.v1alpha.ServerReflectionRequest"); len(files) != 1 {
+		t.Errorf("expected 1 file containing symbol, but got %v", len(files))
+	}
+	if files := s.FileByFilename("not/exists.proto"); files != nil {
+		t.Errorf("expected no file, but got %v", len(files))
+	}
+
+	// a broken file keeps the previously loaded descriptors.
+	if err = os.WriteFile(path, []byte("broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.load(); err == nil {
+		t.Error("expected error on loading broken descriptor set")
+	}
+	if len(s.ListServices()) != 1 {
+		t.Error("expected previously loaded descriptors to be kept")
+	}
+}
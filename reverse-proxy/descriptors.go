@@ -0,0 +1,500 @@
+package reverse_proxy
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcReflection "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"grpc-gateway-x/logging"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultDescriptorCacheTTL = time.Minute
+
+const catalogBuildTimeout = time.Second * 10
+
+// ServiceCatalog is a snapshot of the service descriptors known by the proxy, aggregated from the static descriptor sets
+// and the reflection services of the backends.
+type ServiceCatalog struct {
+	services    []protoreflect.ServiceDescriptor
+	known       map[protoreflect.FullName]struct{}
+	methods     map[string]protoreflect.MethodDescriptor
+	files       []*protoregistry.Files
+	fingerprint string
+	// generation the generation of the descriptor sets the catalog is built of.
+	generation uint64
+	builtAt    time.Time
+	// routed reports whether the service is routed, nil for all.
+	routed func(service string) bool
+}
+
+type serviceCatalogCache struct {
+	sync.Mutex
+	catalog *ServiceCatalog
+	// refreshedAt when the catalog was last refreshed, or attempted to, which expires by the DescriptorCacheTTL.
+	refreshedAt time.Time
+	// endpoints the reflection endpoints last listed, which are reflected while the discovery fails.
+	endpoints []reflectionEndpoint
+	// builds the in-flight builds by the generation of the descriptor sets, shared by all the requests waiting for the
+	// same catalog.
+	builds map[uint64]*catalogBuild
+}
+
+type catalogBuild struct {
+	done    chan struct{}
+	catalog *ServiceCatalog
+	err     error
+}
+
+func newServiceCatalog(fingerprint string, routed func(service string) bool) *ServiceCatalog {
+	return &ServiceCatalog{
+		routed:      routed,
+		known:       map[protoreflect.FullName]struct{}{},
+		methods:     map[string]protoreflect.MethodDescriptor{},
+		fingerprint: fingerprint,
+		builtAt:     time.Now(),
+	}
+}
+
+// Services returns all the known services, sorted by full name.
+func (c *ServiceCatalog) Services() []protoreflect.ServiceDescriptor {
+	return c.services
+}
+
+// FindMethod finds the method by the full method name in the form of "/{service}/{method}".
+func (c *ServiceCatalog) FindMethod(fullMethodName string) (protoreflect.MethodDescriptor, bool) {
+	md, ok := c.methods[fullMethodName]
+	return md, ok
+}
+
+// Resolver returns the type resolver for marshaling the dynamic messages, e.g. the google.protobuf.Any fields, in JSON.
+func (c *ServiceCatalog) Resolver() *CatalogTypeResolver {
+	return &CatalogTypeResolver{files: c.files}
+}
+
+func (c *ServiceCatalog) addFiles(files *protoregistry.Files, services []protoreflect.FullName) {
+	c.files = append(c.files, files)
+	for _, name := range services {
+		if _, ok := c.known[name]; ok || !c.isRouted(string(name)) {
+			continue
+		}
+		d, err := files.FindDescriptorByName(name)
+		if err != nil {
+			continue
+		}
+		sd, ok := d.(protoreflect.ServiceDescriptor)
+		if !ok {
+			continue
+		}
+		c.known[name] = struct{}{}
+		c.services = append(c.services, sd)
+		for i := 0; i < sd.Methods().Len(); i++ {
+			md := sd.Methods().Get(i)
+			c.methods[fullMethodNameOf(md)] = md
+		}
+	}
+}
+
+func (c *ServiceCatalog) isRouted(service string) bool {
+	return c.routed == nil || c.routed(service)
+}
+
+func (c *ServiceCatalog) hasService(name string) bool {
+	_, ok := c.known[protoreflect.FullName(name)]
+	return ok
+}
+
+func fullMethodNameOf(md protoreflect.MethodDescriptor) string {
+	return "/" + string(md.Parent().FullName()) + "/" + string(md.Name())
+}
+
+// CatalogTypeResolver resolves message and extension types from the catalog files as dynamic types,
+// falling back to the types linked into the binary.
+type CatalogTypeResolver struct {
+	files []*protoregistry.Files
+}
+
+func (r *CatalogTypeResolver) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	for _, files := range r.files {
+		d, err := files.FindDescriptorByName(name)
+		if err != nil {
+			continue
+		}
+		if md, ok := d.(protoreflect.MessageDescriptor); ok {
+			return dynamicpb.NewMessageType(md), nil
+		}
+	}
+	return protoregistry.GlobalTypes.FindMessageByName(name)
+}
+
+func (r *CatalogTypeResolver) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	name := url
+	if i := strings.LastIndexByte(url, '/'); i >= 0 {
+		name = url[i+1:]
+	}
+	return r.FindMessageByName(protoreflect.FullName(name))
+}
+
+func (r *CatalogTypeResolver) FindExtensionByName(field protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	for _, files := range r.files {
+		d, err := files.FindDescriptorByName(field)
+		if err != nil {
+			continue
+		}
+		if xd, ok := d.(protoreflect.ExtensionDescriptor); ok {
+			return dynamicpb.NewExtensionType(xd), nil
+		}
+	}
+	return protoregistry.GlobalTypes.FindExtensionByName(field)
+}
+
+func (r *CatalogTypeResolver) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	return protoregistry.GlobalTypes.FindExtensionByNumber(message, field)
+}
+
+// reflectionEndpoint is a backend to be asked for reflection.
+type reflectionEndpoint struct {
+	// addr the address dialed.
+	addr string
+	// serverName the name verified against the backend certificate, the host of addr if empty.
+	serverName string
+}
+
+func (ep reflectionEndpoint) String() string {
+	if ep.serverName == "" {
+		return ep.addr
+	}
+	return ep.addr + "#" + ep.serverName
+}
+
+// reflectionEndpoints lists the backends to be asked for reflection, with the server names of the services they serve.
+func (grp *GrpcReverseProxy) reflectionEndpoints(ctx context.Context) ([]reflectionEndpoint, error) {
+	if grp.opts.BackendAddr != "" {
+		return []reflectionEndpoint{{addr: grp.opts.BackendAddr, serverName: grp.backendServerNameOf("/")}}, nil
+	}
+	_, span := startSpan(ctx, "discovery.list_services")
+	sis, err := grp.opts.BackendDiscovery.ListServices()
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	var uniqueGrpcServiceEndpoints []reflectionEndpoint
+	for name, si := range sis {
+		// the first of the sorted addresses is asked, as the instances are listed in no particular order.
+		var addr string
+		for _, sie := range si {
+			for _, ep := range sie.Endpoints {
+				if strings.Index(ep, "grpc://") == 0 && (addr == "" || ep[7:] < addr) {
+					addr = ep[7:]
+				}
+			}
+		}
+		if addr == "" {
+			continue
+		}
+		uniqueGrpcServiceEndpoints = append(uniqueGrpcServiceEndpoints, reflectionEndpoint{
+			addr: addr,
+			// the services are registered by the endpoints parsed from the full method names, i.e. the packages of
+			// the services.
+			serverName: grp.backendServerNameOf("/" + name),
+		})
+	}
+	sort.Slice(uniqueGrpcServiceEndpoints, func(i, j int) bool {
+		return uniqueGrpcServiceEndpoints[i].String() < uniqueGrpcServiceEndpoints[j].String()
+	})
+	return uniqueGrpcServiceEndpoints, nil
+}
+
+// ServiceCatalog returns the aggregated service descriptors. The catalog is cached, and refreshed in the background
+// when the cache expires, along with the discovered backends, or rebuilt when the static descriptor sets change.
+func (grp *GrpcReverseProxy) ServiceCatalog(ctx context.Context) (*ServiceCatalog, error) {
+	generation := grp.descriptorSets.Generation()
+	grp.catalogCache.Lock()
+	c := grp.catalogCache.catalog
+	if c != nil && c.generation == generation {
+		// an expired catalog keeps being served while it's refreshed in the background, so that the requests never wait
+		// for the discovery and the reflection of the backends unless the descriptor sets changed.
+		if time.Since(grp.catalogCache.refreshedAt) >= grp.opts.DescriptorCacheTTL {
+			grp.catalogCache.refreshedAt = time.Now()
+			grp.startCatalogBuild(ctx, generation)
+		}
+		grp.catalogCache.Unlock()
+		return c, nil
+	}
+	b := grp.startCatalogBuild(ctx, generation)
+	grp.catalogCache.Unlock()
+	select {
+	case <-b.done:
+		// the last good catalog is served if the backends are never discovered.
+		if b.err != nil && c != nil {
+			return c, nil
+		}
+		return b.catalog, b.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// startCatalogBuild starts building the catalog of the generation of the descriptor sets unless it's already being
+// built, the catalogCache must be locked.
+func (grp *GrpcReverseProxy) startCatalogBuild(ctx context.Context, generation uint64) *catalogBuild {
+	if b, ok := grp.catalogCache.builds[generation]; ok {
+		return b
+	}
+	if grp.catalogCache.builds == nil {
+		grp.catalogCache.builds = map[uint64]*catalogBuild{}
+	}
+	b := &catalogBuild{done: make(chan struct{})}
+	grp.catalogCache.builds[generation] = b
+	// the catalog is shared by all the requests, so it is built regardless of the requesting one being canceled.
+	buildCtx := detachedContext{ctx}
+	go func() {
+		var endpoints []reflectionEndpoint
+		if endpoints, b.err = grp.listReflectionEndpoints(buildCtx); b.err == nil {
+			b.catalog = grp.buildServiceCatalog(buildCtx, generation, endpoints)
+		}
+		grp.catalogCache.Lock()
+		delete(grp.catalogCache.builds, generation)
+		if cur := grp.catalogCache.catalog; b.err == nil && (cur == nil || !cur.builtAt.After(b.catalog.builtAt)) {
+			grp.catalogCache.catalog = b.catalog
+			grp.catalogCache.refreshedAt = b.catalog.builtAt
+		}
+		grp.catalogCache.Unlock()
+		close(b.done)
+	}()
+	return b
+}
+
+// listReflectionEndpoints lists the reflection endpoints, or returns the ones last listed if the discovery fails.
+func (grp *GrpcReverseProxy) listReflectionEndpoints(ctx context.Context) ([]reflectionEndpoint, error) {
+	endpoints, err := grp.reflectionEndpoints(ctx)
+	grp.catalogCache.Lock()
+	defer grp.catalogCache.Unlock()
+	if err != nil {
+		if grp.catalogCache.endpoints == nil {
+			return nil, err
+		}
+		logging.For(logging.SubsystemReflection).Warningf("failed listing the backends, reflecting the last listed: %v", err)
+		return grp.catalogCache.endpoints, nil
+	}
+	grp.catalogCache.endpoints = endpoints
+	return endpoints, nil
+}
+
+func (grp *GrpcReverseProxy) buildServiceCatalog(ctx context.Context, generation uint64, endpoints []reflectionEndpoint) *ServiceCatalog {
+	sorted := make([]string, 0, len(endpoints))
+	for _, ep := range endpoints {
+		sorted = append(sorted, ep.String())
+	}
+	c := newServiceCatalog(fmt.Sprintf("%d|%s", generation, strings.Join(sorted, ",")), grp.isServiceRouted)
+	c.generation = generation
+	files, services := grp.descriptorSets.snapshot()
+	c.addFiles(files, services)
+	buildCtx, cls := context.WithTimeout(ctx, catalogBuildTimeout)
+	defer cls()
+	buildCtx, span := startSpan(buildCtx, "reflection.fan_out", attribute.Int("reflection.endpoints", len(endpoints)))
+	fanOutStart := time.Now()
+	for _, ep := range endpoints {
+		// backends having reflection disabled or being unreachable are skipped, their services are then only known
+		// from the static descriptor sets.
+		if err := grp.reflectEndpointServices(buildCtx, ep, c); err != nil {
+			logging.For(logging.SubsystemReflection).Debugf("skipped reflecting services of %v: %v", ep, err)
+		}
+	}
+	reflectionFanOutSeconds.Observe(time.Since(fanOutStart).Seconds())
+	span.End()
+	sort.Slice(c.services, func(i, j int) bool {
+		return c.services[i].FullName() < c.services[j].FullName()
+	})
+	return c
+}
+
+// isMethodCataloged whether the routed method is of the cached catalog, which is not built for the check.
+func (grp *GrpcReverseProxy) isMethodCataloged(fullMethodName string) bool {
+	grp.catalogCache.Lock()
+	c := grp.catalogCache.catalog
+	grp.catalogCache.Unlock()
+	if c == nil {
+		return false
+	}
+	_, ok := c.FindMethod(fullMethodName)
+	return ok
+}
+
+// FindMethodDescriptor finds the descriptor of the method by the full method name in the form of "/{service}/{method}".
+func (grp *GrpcReverseProxy) FindMethodDescriptor(ctx context.Context, fullMethodName string) (protoreflect.MethodDescriptor, error) {
+	c, err := grp.ServiceCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	md, ok := c.FindMethod(fullMethodName)
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "unknown method %v", fullMethodName)
+	}
+	return md, nil
+}
+
+func (grp *GrpcReverseProxy) reflectEndpointServices(ctx context.Context, endpoint reflectionEndpoint, c *ServiceCatalog) (err error) {
+	ctx, span := startSpan(ctx, "reflection.endpoint", attribute.String("backend.endpoint", endpoint.addr))
+	defer func() { endSpan(span, err) }()
+	dialCtx, cls := context.WithTimeout(ctx, time.Second*3)
+	defer cls()
+	conn, err := grp.dialBackend(dialCtx, endpoint.addr, endpoint.serverName)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	streamCtx, streamCls := context.WithCancel(ctx)
+	defer streamCls()
+	stream, err := grpcReflection.NewServerReflectionClient(conn).ServerReflectionInfo(streamCtx, grpc.WaitForReady(false))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = stream.CloseSend() }()
+	l := &reflectionFileLoader{
+		stream:  stream,
+		files:   &protoregistry.Files{},
+		pending: map[string]*descriptorpb.FileDescriptorProto{},
+	}
+	resp, err := l.request(&grpcReflection.ServerReflectionRequest{
+		MessageRequest: &grpcReflection.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return err
+	}
+	var services []protoreflect.FullName
+	for _, svc := range resp.GetListServicesResponse().GetService() {
+		if c.hasService(svc.Name) || !c.isRouted(svc.Name) || strings.HasPrefix(svc.Name, "grpc.reflection.") {
+			continue
+		}
+		if err = l.loadSymbol(svc.Name); err != nil {
+			continue
+		}
+		services = append(services, protoreflect.FullName(svc.Name))
+	}
+	c.addFiles(l.files, services)
+	return nil
+}
+
+// reflectionFileLoader loads the file descriptors along with their dependencies over a reflection stream.
+type reflectionFileLoader struct {
+	stream  grpcReflection.ServerReflection_ServerReflectionInfoClient
+	files   *protoregistry.Files
+	pending map[string]*descriptorpb.FileDescriptorProto
+}
+
+func (l *reflectionFileLoader) request(req *grpcReflection.ServerReflectionRequest) (*grpcReflection.ServerReflectionResponse, error) {
+	if err := l.stream.Send(req); err != nil {
+		return nil, err
+	}
+	resp, err := l.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if e := resp.GetErrorResponse(); e != nil {
+		return nil, status.Error(codes.Code(e.ErrorCode), e.ErrorMessage)
+	}
+	for _, b := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+		fdp := &descriptorpb.FileDescriptorProto{}
+		if err = proto.Unmarshal(b, fdp); err != nil {
+			return nil, err
+		}
+		if _, ok := l.pending[fdp.GetName()]; !ok {
+			l.pending[fdp.GetName()] = fdp
+		}
+	}
+	return resp, nil
+}
+
+func (l *reflectionFileLoader) loadSymbol(symbol string) error {
+	resp, err := l.request(&grpcReflection.ServerReflectionRequest{
+		MessageRequest: &grpcReflection.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: symbol},
+	})
+	if err != nil {
+		return err
+	}
+	for _, b := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+		fdp := &descriptorpb.FileDescriptorProto{}
+		if err = proto.Unmarshal(b, fdp); err != nil {
+			return err
+		}
+		if err = l.register(fdp.GetName()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *reflectionFileLoader) register(name string) error {
+	if _, err := l.files.FindFileByPath(name); err == nil {
+		return nil
+	}
+	fdp, ok := l.pending[name]
+	if !ok {
+		_, err := l.request(&grpcReflection.ServerReflectionRequest{
+			MessageRequest: &grpcReflection.ServerReflectionRequest_FileByFilename{FileByFilename: name},
+		})
+		if err != nil {
+			return err
+		}
+		if fdp, ok = l.pending[name]; !ok {
+			return fmt.Errorf("file %v not found", name)
+		}
+	}
+	for _, dep := range fdp.Dependency {
+		if err := l.register(dep); err != nil {
+			// the well-known files linked into the binary can be used in case the backend fails to provide them.
+			if _, gErr := protoregistry.GlobalFiles.FindFileByPath(dep); gErr != nil {
+				return err
+			}
+		}
+	}
+	fd, err := protodesc.FileOptions{AllowUnresolvable: true}.New(fdp, filesResolver{l.files, protoregistry.GlobalFiles})
+	if err != nil {
+		return err
+	}
+	return l.files.RegisterFile(fd)
+}
+
+// filesResolver resolves the descriptors from the registries in order.
+type filesResolver []*protoregistry.Files
+
+func (r filesResolver) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	for _, files := range r {
+		if fd, err := files.FindFileByPath(path); err == nil {
+			return fd, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
+
+func (r filesResolver) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	for _, files := range r {
+		if d, err := files.FindDescriptorByName(name); err == nil {
+			return d, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
+
+// detachedContext keeps the values of the parent context but not its cancellation and deadline.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
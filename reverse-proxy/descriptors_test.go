@@ -0,0 +1,95 @@
+package reverse_proxy
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kratos/kratos/v2/registry"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// listedDiscovery the discovery listing the instances of the service "grpc.health.v1", in the alternating order on
+// each call, or failing if unreachable.
+type listedDiscovery struct {
+	addrs       []string
+	lists       *atomic.Int32
+	unreachable *atomic.Bool
+}
+
+func (d listedDiscovery) GetService(context.Context, string) ([]*registry.ServiceInstance, error) {
+	return nil, nil
+}
+
+func (d listedDiscovery) Watch(context.Context, string) (registry.Watcher, error) {
+	return nil, errors.New("not watchable")
+}
+
+func (d listedDiscovery) ListServices() (map[string][]*registry.ServiceInstance, error) {
+	n := d.lists.Add(1)
+	if d.unreachable.Load() {
+		return nil, errors.New("unreachable")
+	}
+	var sis []*registry.ServiceInstance
+	for _, addr := range d.addrs {
+		sis = append(sis, &registry.ServiceInstance{Endpoints: []string{"http://" + addr, "grpc://" + addr}})
+	}
+	if n%2 == 0 {
+		sis[0], sis[len(sis)-1] = sis[len(sis)-1], sis[0]
+	}
+	return map[string][]*registry.ServiceInstance{"grpc.health.v1": sis}, nil
+}
+
+func TestServiceCatalogCache(t *testing.T) {
+	addr := startTestBackend(t).opts.BackendAddr
+	d := listedDiscovery{addrs: []string{addr, "127.0.0.1:1"}, lists: &atomic.Int32{}, unreachable: &atomic.Bool{}}
+	rp, err := NewReverseProxy(WithBackendDiscovery(d), WithBackendInsecure(true), WithDescriptorCacheTTL(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the endpoint reflected is the same whichever order the instances are listed in.
+	for i := 0; i < 2; i++ {
+		eps, err := rp.reflectionEndpoints(context.Background())
+		if err != nil || len(eps) != 1 || eps[0].addr != "127.0.0.1:1" {
+			t.Fatalf("expected the sorted first endpoint 127.0.0.1:1, but got %v, %v", eps, err)
+		}
+	}
+	d.addrs[1] = "127.0.0.2:1"
+	d.lists.Store(0)
+
+	c, err := rp.ServiceCatalog(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.FindMethod("/grpc.health.v1.Health/Check"); !ok {
+		t.Errorf("expected the health service reflected, but got %v", c.Services())
+	}
+	for i := 0; i < 10; i++ {
+		if cached, err := rp.ServiceCatalog(context.Background()); err != nil || cached != c {
+			t.Errorf("expected the cached catalog, but got %v, %v", cached, err)
+		}
+	}
+	if lists := d.lists.Load(); lists != 1 {
+		t.Errorf("expected the backends listed once, but got %v", lists)
+	}
+
+	// the expired catalog is served while the discovery fails, and then rebuilt of the last listed backends.
+	d.unreachable.Store(true)
+	rp.catalogCache.Lock()
+	rp.catalogCache.refreshedAt = time.Time{}
+	rp.catalogCache.Unlock()
+	if cached, err := rp.ServiceCatalog(context.Background()); err != nil || cached != c {
+		t.Errorf("expected the cached catalog, but got %v, %v", cached, err)
+	}
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		if rebuilt, _ := rp.ServiceCatalog(context.Background()); rebuilt != c {
+			if _, ok := rebuilt.FindMethod("/grpc.health.v1.Health/Check"); !ok {
+				t.Errorf("expected the health service reflected of the last listed backends, but got %v", rebuilt.Services())
+			}
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Errorf("expected the catalog rebuilt in the background")
+}
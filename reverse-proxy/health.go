@@ -0,0 +1,142 @@
+package reverse_proxy
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"grpc-gateway-x/discovery"
+	"time"
+)
+
+// DefaultHealthWatchInterval the interval of checking the readiness of the gateway for the health watches.
+const DefaultHealthWatchInterval = time.Second
+
+// CheckDiscovery returns the error if the service discovery of the backends is unreachable. It is nil if the backend
+// address is explicitly set, or the discovery is unable to check its connectivity.
+func (grp *GrpcReverseProxy) CheckDiscovery(ctx context.Context) error {
+	if grp.opts.BackendAddr != "" {
+		return nil
+	}
+	if c, ok := grp.opts.BackendDiscovery.(discovery.Checker); ok {
+		return c.Check(ctx)
+	}
+	return nil
+}
+
+// HealthServer the grpc.health.v1.Health service of the gateway. The status of the empty service name is the one of
+// the gateway, SERVING if it is ready. The status of the other names is checked against the backends the names are
+// resolved to for the authorized callers, if the backend checks are proxied, or NOT_FOUND.
+type HealthServer struct {
+	healthpb.UnimplementedHealthServer
+	grp           *GrpcReverseProxy
+	ready         func(ctx context.Context) error
+	proxyBackends bool
+	// WatchInterval the interval of checking the readiness for the watches of the gateway status.
+	WatchInterval time.Duration
+	// Authenticate authenticates the checks of the other service names than the empty one, nil for none. They are
+	// authorized by the Authorizer of the reverse proxy as the calls of `/{service}/Check`.
+	Authenticate func(ctx context.Context) (context.Context, error)
+}
+
+// NewHealthServer creates the health service of the gateway, which is ready if the ready func returns nil.
+func NewHealthServer(grp *GrpcReverseProxy, ready func(ctx context.Context) error, proxyBackends bool) *HealthServer {
+	return &HealthServer{grp: grp, ready: ready, proxyBackends: proxyBackends, WatchInterval: DefaultHealthWatchInterval}
+}
+
+// AuthFuncOverride exempts the health checks from the authentication interceptor of the gateway, as the orchestrators
+// checking the empty service name carry no credentials. The checks of the other names, proxied to the backends, are
+// authenticated and authorized by the handlers, which see the service names.
+func (s *HealthServer) AuthFuncOverride(ctx context.Context, _ string) (context.Context, error) {
+	return ctx, nil
+}
+
+// authorize authenticates and authorizes the check of the backend service as the call of `/{service}/Check`.
+func (s *HealthServer) authorize(ctx context.Context, service string) (context.Context, error) {
+	if s.Authenticate != nil {
+		var err error
+		if ctx, err = s.Authenticate(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if s.grp.opts.Authorizer != nil {
+		if err := s.grp.opts.Authorizer.Authorize(ctx, "/"+service+"/Check"); err != nil {
+			return nil, err
+		}
+	}
+	return ctx, nil
+}
+
+func (s *HealthServer) gatewayStatus(ctx context.Context) healthpb.HealthCheckResponse_ServingStatus {
+	if s.ready != nil && s.ready(ctx) != nil {
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	return healthpb.HealthCheckResponse_SERVING
+}
+
+// backendClient resolves the backend of the service, as the calls of the service are, once the caller is authorized.
+func (s *HealthServer) backendClient(ctx context.Context, service string) (healthpb.HealthClient, error) {
+	ctx, err := s.authorize(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	if !s.proxyBackends || !s.grp.isServiceRouted(service) {
+		return nil, status.Errorf(codes.NotFound, "unknown service %v", service)
+	}
+	conn, err := s.grp.resolveServerConnection(ctx, "/"+service+"/Check")
+	if err != nil {
+		return nil, err
+	}
+	return healthpb.NewHealthClient(conn), nil
+}
+
+func (s *HealthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if req.Service == "" {
+		return &healthpb.HealthCheckResponse{Status: s.gatewayStatus(ctx)}, nil
+	}
+	c, err := s.backendClient(ctx, req.Service)
+	if err != nil {
+		return nil, err
+	}
+	return c.Check(ctx, req)
+}
+
+func (s *HealthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ctx := stream.Context()
+	if req.Service != "" {
+		c, err := s.backendClient(ctx, req.Service)
+		if err != nil {
+			return err
+		}
+		w, err := c.Watch(ctx, req)
+		if err != nil {
+			return err
+		}
+		for {
+			resp, err := w.Recv()
+			if err != nil {
+				return err
+			}
+			if err = stream.Send(resp); err != nil {
+				return err
+			}
+		}
+	}
+	ticker := time.NewTicker(s.WatchInterval)
+	defer ticker.Stop()
+	last := healthpb.HealthCheckResponse_UNKNOWN
+	for {
+		// the changes of the status are sent, along with the initial one.
+		if st := s.gatewayStatus(ctx); st != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package reverse_proxy
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func startHealthServer(t *testing.T, hs *HealthServer) healthpb.HealthClient {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, hs)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+func TestHealthServer(t *testing.T) {
+	rp := startTestBackend(t)
+	var unready atomic.Bool
+	ready := func(ctx context.Context) error {
+		if unready.Load() {
+			return errors.New("draining")
+		}
+		return nil
+	}
+	hs := NewHealthServer(rp, ready, true)
+	hs.WatchInterval = time.Millisecond * 10
+	c := startHealthServer(t, hs)
+	ctx, cls := context.WithTimeout(context.Background(), time.Second*5)
+	defer cls()
+
+	resp, err := c.Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("expected the gateway SERVING, but got %v, %v", resp, err)
+	}
+	// the backend of the test has the service "books" NOT_SERVING.
+	resp, err = c.Check(ctx, &healthpb.HealthCheckRequest{Service: "books"})
+	if err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("expected the backend service NOT_SERVING, but got %v, %v", resp, err)
+	}
+	if _, err = c.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound of the unknown backend service, but got %v", err)
+	}
+
+	w, err := c.Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err = w.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("expected the initial status SERVING, but got %v, %v", resp, err)
+	}
+	unready.Store(true)
+	if resp, err = w.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("expected the status changed to NOT_SERVING, but got %v, %v", resp, err)
+	}
+
+	c = startHealthServer(t, NewHealthServer(rp, nil, false))
+	if _, err = c.Check(ctx, &healthpb.HealthCheckRequest{Service: "books"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound of the backend service not proxied, but got %v", err)
+	}
+}
+
+func TestHealthServerAuth(t *testing.T) {
+	rp := startTestBackend(t, WithAuthorizer(denyingAuthorizer{}))
+	hs := NewHealthServer(rp, nil, true)
+	hs.Authenticate = func(ctx context.Context) (context.Context, error) {
+		return nil, status.Error(codes.Unauthenticated, "no credentials")
+	}
+	c := startHealthServer(t, hs)
+	ctx, cls := context.WithTimeout(context.Background(), time.Second*5)
+	defer cls()
+
+	// the gateway status is not authenticated, as the orchestrators carry no credentials.
+	if resp, err := c.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("expected the gateway SERVING, but got %v, %v", resp, err)
+	}
+	if _, err := c.Check(ctx, &healthpb.HealthCheckRequest{Service: "books"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated of the backend service, but got %v", err)
+	}
+	w, err := c.Watch(ctx, &healthpb.HealthCheckRequest{Service: "books"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Recv(); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated of watching the backend service, but got %v", err)
+	}
+
+	c = startHealthServer(t, NewHealthServer(rp, nil, true))
+	_, err = c.Check(ctx, &healthpb.HealthCheckRequest{Service: "books"})
+	if st := status.Convert(err); st.Code() != codes.PermissionDenied || st.Message() != "permission denied to call /books/Check" {
+		t.Errorf("expected PermissionDenied of /books/Check, but got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package reverse_proxy
+
+import (
+	"encoding/json"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// HttpInvoker is the HTTP handler which invokes any method by its name with the JSON encoded request, without the need
+// of the google.api.http annotations:
+//
+//	POST {prefix}{full.Service}/{Method}
+//
+// The request body of the client-streaming methods is a JSON array of the request messages. The responses are the same
+// as the ones of HttpTranscoder, and the errors are the JSON encoded google.rpc.Status with the details.
+type HttpInvoker struct {
+	grp    *GrpcReverseProxy
+	prefix string
+}
+
+// HttpInvoker returns the handler for the invoking requests under the path prefix, e.g. "/v1/invoke/".
+func (grp *GrpcReverseProxy) HttpInvoker(prefix string) *HttpInvoker {
+	return &HttpInvoker{grp: grp, prefix: prefix}
+}
+
+func (h *HttpInvoker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	fullMethodName := "/" + strings.TrimPrefix(r.URL.Path, h.prefix)
+	catalog, err := h.grp.ServiceCatalog(r.Context())
+	if err != nil {
+		writeHTTPError(w, status.Errorf(codes.Unavailable, "failed resolving services: %v", err), nil)
+		return
+	}
+	md, ok := catalog.FindMethod(fullMethodName)
+	if !ok {
+		writeHTTPError(w, status.Errorf(codes.Unimplemented, "unknown method %v", fullMethodName), nil)
+		return
+	}
+	resolver := catalog.Resolver()
+	h.grp.limitRequestBody(w, r)
+	reqs, err := h.readRequests(r, md, resolver)
+	if err != nil {
+		writeHTTPError(w, requestBodyError(err), resolver)
+		return
+	}
+	stream, err := h.grp.NewMethodStream(IncomingContextFromHTTPRequest(r), md)
+	for i := 0; err == nil && i < len(reqs); i++ {
+		err = stream.SendMsg(reqs[i])
+	}
+	if err == nil {
+		err = stream.CloseSend()
+	}
+	if err != nil {
+		writeHTTPError(w, err, resolver)
+		return
+	}
+	route := &httpRoute{method: md}
+	if md.IsStreamingServer() {
+		route.writeStream(w, stream, resolver)
+		return
+	}
+	route.writeUnary(w, stream, resolver)
+}
+
+// readRequests reads the request message from the body, or the array of them for the client-streaming methods.
+func (h *HttpInvoker) readRequests(r *http.Request, md protoreflect.MethodDescriptor, resolver *CatalogTypeResolver) ([]*dynamicpb.Message, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	raws := []json.RawMessage{body}
+	if md.IsStreamingClient() {
+		raws = nil
+		if len(body) > 0 {
+			if err = json.Unmarshal(body, &raws); err != nil {
+				return nil, err
+			}
+		}
+	}
+	reqs := make([]*dynamicpb.Message, 0, len(raws))
+	for _, raw := range raws {
+		req := dynamicpb.NewMessage(md.Input())
+		if len(raw) > 0 {
+			if err = jsonUnmarshalOptions(resolver).Unmarshal(raw, req); err != nil {
+				return nil, err
+			}
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
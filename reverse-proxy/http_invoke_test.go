@@ -0,0 +1,79 @@
+package reverse_proxy
+
+import (
+	"encoding/json"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// startTestBackend starts a grpc server with the health and reflection services, and returns the reverse proxy to it
+// with the extra options.
+func startTestBackend(t *testing.T, opts ...GrpcReverseProxyOption) *GrpcReverseProxy {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	hs := health.NewServer()
+	hs.SetServingStatus("books", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+	reflection.Register(srv)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	rp, err := NewReverseProxy(append([]GrpcReverseProxyOption{WithBackendAddr(lis.Addr().String()), WithBackendInsecure(true)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rp
+}
+
+func TestHttpInvoker(t *testing.T) {
+	rp := startTestBackend(t)
+	h := rp.HttpInvoker("/v1/invoke/")
+	cases := []struct {
+		path   string
+		body   string
+		status int
+		expect map[string]interface{}
+	}{
+		{"/v1/invoke/grpc.health.v1.Health/Check", `{"service": "books"}`, http.StatusOK, map[string]interface{}{"status": "NOT_SERVING"}},
+		{"/v1/invoke/grpc.health.v1.Health/Check", `{"service": "unknown"}`, http.StatusNotFound, map[string]interface{}{"code": float64(5)}},
+		{"/v1/invoke/grpc.health.v1.Health/Nope", `{}`, http.StatusNotImplemented, map[string]interface{}{"code": float64(12)}},
+		{"/v1/invoke/grpc.health.v1.Health/Check", `{"service": 1}`, http.StatusBadRequest, map[string]interface{}{"code": float64(3)}},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, c.path, strings.NewReader(c.body)))
+		if w.Code != c.status {
+			t.Errorf("%v %v: expected status %v, but got %v: %v", c.path, c.body, c.status, w.Code, w.Body.String())
+			continue
+		}
+		got := map[string]interface{}{}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Errorf("%v %v: invalid response %v", c.path, c.body, w.Body.String())
+			continue
+		}
+		for k, v := range c.expect {
+			if got[k] != v {
+				t.Errorf("%v %v: expected %v=%v, but got %v", c.path, c.body, k, v, w.Body.String())
+			}
+		}
+	}
+}
+
+func TestHttpInvokerMaxMessageSize(t *testing.T) {
+	h := startTestBackend(t, WithMaxMessageSize(64)).HttpInvoker("/v1/invoke/")
+	w := httptest.NewRecorder()
+	body := `{"service": "` + strings.Repeat("x", 64) + `"}`
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/invoke/grpc.health.v1.Health/Check", strings.NewReader(body)))
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), `"code":8`) {
+		t.Errorf("expected status %v with RESOURCE_EXHAUSTED, but got %v: %v", http.StatusTooManyRequests, w.Code, w.Body.String())
+	}
+}
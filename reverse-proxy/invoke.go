@@ -0,0 +1,168 @@
+package reverse_proxy
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// httpHeadersNotForwarded the request headers which are not forwarded as metadata to the backends,
+// as they are about the HTTP transport, not the call.
+var httpHeadersNotForwarded = map[string]struct{}{
+	"accept-encoding":   {},
+	"connection":        {},
+	"content-length":    {},
+	"content-type":      {},
+	"host":              {},
+	"keep-alive":        {},
+	"te":                {},
+	"trailer":           {},
+	"transfer-encoding": {},
+	"upgrade":           {},
+}
+
+// NewMethodStream opens a stream of the method to the backend through the director, the same way the proxied calls do.
+// The ctx is expected to carry the incoming metadata to be forwarded, e.g. the one of IncomingContextFromHTTPRequest.
+func (grp *GrpcReverseProxy) NewMethodStream(ctx context.Context, md protoreflect.MethodDescriptor) (grpc.ClientStream, error) {
+	fullMethodName := fullMethodNameOf(md)
+	outCtx, backendConn, err := grp.streamDirector(ctx, fullMethodName)
+	if err != nil {
+		return nil, err
+	}
+	desc := &grpc.StreamDesc{
+		StreamName:    string(md.Name()),
+		ServerStreams: md.IsStreamingServer(),
+		ClientStreams: md.IsStreamingClient(),
+	}
+	stream, err := grpc.NewClientStream(outCtx, desc, backendConn, fullMethodName)
+	if err != nil || (!grp.hasMetadataRules(MetadataTargetHeader) && !grp.hasMetadataRules(MetadataTargetTrailer)) {
+		return stream, err
+	}
+	return &responseMetadataClientStream{ClientStream: stream, ctx: ctx, grp: grp, fullMethodName: fullMethodName}, nil
+}
+
+// IncomingContextFromHTTPRequest makes the context of a call from the HTTP request as if it was received by the grpc server,
+// with the request headers as the incoming metadata, and the remote address and the TLS connection state as the peer.
+func IncomingContextFromHTTPRequest(r *http.Request) context.Context {
+	md := metadata.MD{}
+	for k, vs := range r.Header {
+		k = strings.ToLower(k)
+		if _, ok := httpHeadersNotForwarded[k]; ok {
+			continue
+		}
+		md.Append(k, vs...)
+	}
+	ctx := metadata.NewIncomingContext(r.Context(), md)
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		p := &peer.Peer{Addr: addr}
+		if r.TLS != nil {
+			p.AuthInfo = credentials.TLSInfo{State: *r.TLS, CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity}}
+		}
+		ctx = peer.NewContext(ctx, p)
+	}
+	return ctx
+}
+
+// HTTPStatusFromCode maps the grpc status code to the HTTP status code, per
+// https://github.com/googleapis/googleapis/blob/master/google/rpc/code.proto
+func HTTPStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.FailedPrecondition:
+		return http.StatusBadRequest
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// limitRequestBody limits the request body to the maximum message size, the reads beyond fail with http.MaxBytesError.
+func (grp *GrpcReverseProxy) limitRequestBody(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(grp.opts.MaxMessageSize))
+}
+
+// requestBodyError converts the error building the request message from the HTTP request to the status,
+// RESOURCE_EXHAUSTED if the body exceeds the maximum message size, INVALID_ARGUMENT otherwise.
+func requestBodyError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return status.Errorf(codes.ResourceExhausted, "request body larger than %d bytes", tooLarge.Limit)
+	}
+	return status.Errorf(codes.InvalidArgument, "%v", err)
+}
+
+// writeHTTPError writes the error as the JSON encoded google.rpc.Status with the mapped HTTP status code.
+func writeHTTPError(w http.ResponseWriter, err error, resolver *CatalogTypeResolver) {
+	st := status.Convert(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(HTTPStatusFromCode(st.Code()))
+	_, _ = w.Write(marshalStatusJSON(st, resolver))
+}
+
+// marshalStatusJSON marshals the status in JSON. The details are left out if any of them can not be resolved.
+func marshalStatusJSON(st *status.Status, resolver *CatalogTypeResolver) []byte {
+	opts := jsonMarshalOptions(resolver)
+	opts.EmitUnpopulated = false
+	sp := st.Proto()
+	b, err := opts.Marshal(sp)
+	if err != nil {
+		sp.Details = nil
+		b, _ = opts.Marshal(sp)
+	}
+	return b
+}
+
+// writeResponseMetadata writes the metadata received from the backend as the HTTP headers with the prefix.
+func writeResponseMetadata(h http.Header, md metadata.MD, prefix string) {
+	for k, vs := range md {
+		for _, v := range vs {
+			h.Add(prefix+k, v)
+		}
+	}
+}
+
+func jsonMarshalOptions(resolver *CatalogTypeResolver) protojson.MarshalOptions {
+	opts := protojson.MarshalOptions{EmitUnpopulated: true}
+	if resolver != nil {
+		opts.Resolver = resolver
+	}
+	return opts
+}
+
+func jsonUnmarshalOptions(resolver *CatalogTypeResolver) protojson.UnmarshalOptions {
+	opts := protojson.UnmarshalOptions{DiscardUnknown: true}
+	if resolver != nil {
+		opts.Resolver = resolver
+	}
+	return opts
+}
@@ -0,0 +1,319 @@
+package reverse_proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"grpc-gateway-x/logging"
+	"net"
+	"path"
+	"strings"
+	"text/template"
+)
+
+const (
+	// MetadataTargetRequest the rule transforms the request metadata forwarded to the backends.
+	MetadataTargetRequest = "request"
+	// MetadataTargetHeader the rule transforms the response headers sent to the clients.
+	MetadataTargetHeader = "header"
+	// MetadataTargetTrailer the rule transforms the response trailers sent to the clients.
+	MetadataTargetTrailer = "trailer"
+)
+
+const (
+	// MetadataActionDrop drops the Keys, which may be globs, e.g. "cookie" or "x-internal-*".
+	MetadataActionDrop = "drop"
+	// MetadataActionRename renames the Keys to To, appending to the existing values of To.
+	MetadataActionRename = "rename"
+	// MetadataActionSet replaces the values of the Keys with the Value.
+	MetadataActionSet = "set"
+	// MetadataActionAppend appends the Value to the values of the Keys.
+	MetadataActionAppend = "append"
+	// MetadataActionAllow turns the target into the allowlist mode, in which only the Keys, which may be globs, of all
+	// the allow rules of the route are taken from the clients or the backends. the values by the other rules and the
+	// gateway itself, e.g. x-forwarded-for, are not subject to the allowlist.
+	MetadataActionAllow = "allow"
+)
+
+// MetadataRule transforms the request metadata, or the response headers or trailers, of the calls of the routes. The
+// rules are applied in order, after the allowlist ones.
+type MetadataRule struct {
+	// Routes the prefixes of the full method names the rule applies to, e.g. "/com.example.public.". all if empty.
+	Routes []string
+	// Target "request", "header" or "trailer". default is "request".
+	Target string
+	// Action "drop", "rename", "set", "append" or "allow".
+	Action string
+	// Keys the metadata keys the action applies to.
+	Keys []string
+	// To the key the Keys are renamed to.
+	To string
+	// Value the text/template of the value to set or append, of MetadataTemplateData, e.g. "{{.ClientIp}}" or
+	// "{{with .Principal}}{{.Subject}}{{end}}". the value is not set if it is empty or fails.
+	Value string
+}
+
+// MetadataTemplateData the data of the Value templates of the metadata rules.
+type MetadataTemplateData struct {
+	// Method the full method name of the call.
+	Method string
+	// ClientIp the IP address of the client, e.g. the one by the PROXY protocol.
+	ClientIp string
+	// RequestId the request id of the call.
+	RequestId string
+	// Principal the authenticated caller by the PrincipalFunc option, nil if not authenticated.
+	Principal interface{}
+}
+
+// PrincipalFunc returns the authenticated caller of the call, e.g. the one of the JWT, nil if not authenticated.
+type PrincipalFunc func(ctx context.Context) interface{}
+
+type metadataRule struct {
+	MetadataRule
+	value *template.Template
+}
+
+// compileMetadataRules validates the rules and parses the templates of the values.
+func compileMetadataRules(rules []MetadataRule) ([]*metadataRule, error) {
+	compiled := make([]*metadataRule, 0, len(rules))
+	for i, r := range rules {
+		if r.Target == "" {
+			r.Target = MetadataTargetRequest
+		}
+		if r.Target != MetadataTargetRequest && r.Target != MetadataTargetHeader && r.Target != MetadataTargetTrailer {
+			return nil, fmt.Errorf("metadata rule %d: invalid Target %q", i, r.Target)
+		}
+		if len(r.Keys) == 0 {
+			return nil, fmt.Errorf("metadata rule %d: Keys must be set", i)
+		}
+		keys := make([]string, 0, len(r.Keys))
+		for _, key := range r.Keys {
+			key = strings.ToLower(key)
+			if _, err := path.Match(key, ""); err != nil {
+				return nil, fmt.Errorf("metadata rule %d: invalid key %q", i, key)
+			}
+			keys = append(keys, key)
+		}
+		r.Keys = keys
+		c := &metadataRule{MetadataRule: r}
+		switch r.Action {
+		case MetadataActionDrop, MetadataActionAllow:
+		case MetadataActionRename:
+			if r.To == "" || isReservedMetadataKey(r.To) {
+				return nil, fmt.Errorf("metadata rule %d: invalid To %q", i, r.To)
+			}
+			c.To = strings.ToLower(r.To)
+		case MetadataActionSet, MetadataActionAppend:
+			for _, key := range r.Keys {
+				if isReservedMetadataKey(key) || strings.ContainsAny(key, "*?[") {
+					return nil, fmt.Errorf("metadata rule %d: invalid key %q to %v", i, key, r.Action)
+				}
+			}
+			var err error
+			if c.value, err = template.New(fmt.Sprintf("rule-%d", i)).Parse(r.Value); err != nil {
+				return nil, fmt.Errorf("metadata rule %d: invalid Value: %v", i, err)
+			}
+		default:
+			return nil, fmt.Errorf("metadata rule %d: invalid Action %q", i, r.Action)
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// isReservedMetadataKey reports whether the key is of the HTTP/2 pseudo headers or the grpc protocol.
+func isReservedMetadataKey(key string) bool {
+	key = strings.ToLower(key)
+	return strings.HasPrefix(key, ":") || strings.HasPrefix(key, "grpc-") || key == "content-type" || key == "te"
+}
+
+func (r *metadataRule) appliesTo(fullMethodName, target string) bool {
+	if r.Target != target {
+		return false
+	}
+	if len(r.Routes) == 0 {
+		return true
+	}
+	for _, route := range r.Routes {
+		if strings.HasPrefix(fullMethodName, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasMetadataRules reports whether any of the rules transforms the target.
+func (grp *GrpcReverseProxy) hasMetadataRules(target string) bool {
+	for _, r := range grp.metadataRules {
+		if r.Target == target {
+			return true
+		}
+	}
+	return false
+}
+
+// allowMetadata drops the keys of the md not allowed by the allow rules of the target, if any of them applies. The
+// InjectedMetadata of the request target are kept, as they are set by the gateway after the clients' ones are dropped.
+func (grp *GrpcReverseProxy) allowMetadata(fullMethodName, target string, md metadata.MD) {
+	var allowed []string
+	allowlist := false
+	for _, r := range grp.metadataRules {
+		if r.Action == MetadataActionAllow && r.appliesTo(fullMethodName, target) {
+			allowlist = true
+			allowed = append(allowed, r.Keys...)
+		}
+	}
+	if !allowlist {
+		return
+	}
+	if target == MetadataTargetRequest {
+		allowed = append(allowed, grp.opts.InjectedMetadata...)
+	}
+	for k := range md {
+		if !matchAnyKey(allowed, k) {
+			delete(md, k)
+		}
+	}
+}
+
+// transformMetadata applies the rules other than the allow ones of the target to the md in place.
+func (grp *GrpcReverseProxy) transformMetadata(ctx context.Context, fullMethodName, target string, md metadata.MD) {
+	var data *MetadataTemplateData
+	for _, r := range grp.metadataRules {
+		if r.Action == MetadataActionAllow || !r.appliesTo(fullMethodName, target) {
+			continue
+		}
+		switch r.Action {
+		case MetadataActionDrop:
+			for k := range md {
+				if matchAnyKey(r.Keys, k) {
+					delete(md, k)
+				}
+			}
+		case MetadataActionRename:
+			for k, vs := range md {
+				if k != r.To && matchAnyKey(r.Keys, k) {
+					delete(md, k)
+					md.Append(r.To, vs...)
+				}
+			}
+		case MetadataActionSet, MetadataActionAppend:
+			if data == nil {
+				data = grp.metadataTemplateData(ctx, fullMethodName)
+			}
+			var b bytes.Buffer
+			if err := r.value.Execute(&b, data); err != nil {
+				logging.For(logging.SubsystemProxy).Debugf("failed executing metadata rule %v of %v: %v", r.value.Name(), fullMethodName, err)
+				continue
+			}
+			if b.Len() == 0 {
+				continue
+			}
+			for _, k := range r.Keys {
+				if r.Action == MetadataActionSet {
+					md.Set(k, b.String())
+				} else {
+					md.Append(k, b.String())
+				}
+			}
+		}
+	}
+}
+
+func (grp *GrpcReverseProxy) metadataTemplateData(ctx context.Context, fullMethodName string) *MetadataTemplateData {
+	data := &MetadataTemplateData{Method: fullMethodName, ClientIp: clientIp(ctx)}
+	if id, ok := RequestIdFromContext(ctx); ok {
+		data.RequestId = id
+	} else if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(grp.opts.RequestIdHeader); len(ids) > 0 {
+			data.RequestId = ids[0]
+		}
+	}
+	if grp.opts.PrincipalFunc != nil {
+		data.Principal = grp.opts.PrincipalFunc(ctx)
+	}
+	return data
+}
+
+// clientIp returns the IP address of the peer of the call, empty if unknown.
+func clientIp(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+func matchAnyKey(globs []string, key string) bool {
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// StreamServerInterceptor returns the interceptor applying the metadata rules to the response headers and trailers of
+// the proxied calls.
+func (grp *GrpcReverseProxy) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !grp.hasMetadataRules(MetadataTargetHeader) && !grp.hasMetadataRules(MetadataTargetTrailer) {
+			return handler(srv, ss)
+		}
+		return handler(srv, &responseMetadataServerStream{ServerStream: ss, grp: grp, fullMethodName: info.FullMethod})
+	}
+}
+
+type responseMetadataServerStream struct {
+	grpc.ServerStream
+	grp            *GrpcReverseProxy
+	fullMethodName string
+}
+
+func (s *responseMetadataServerStream) SetHeader(md metadata.MD) error {
+	return s.ServerStream.SetHeader(s.grp.transformResponseMetadata(s.Context(), s.fullMethodName, MetadataTargetHeader, md))
+}
+
+func (s *responseMetadataServerStream) SendHeader(md metadata.MD) error {
+	return s.ServerStream.SendHeader(s.grp.transformResponseMetadata(s.Context(), s.fullMethodName, MetadataTargetHeader, md))
+}
+
+func (s *responseMetadataServerStream) SetTrailer(md metadata.MD) {
+	s.ServerStream.SetTrailer(s.grp.transformResponseMetadata(s.Context(), s.fullMethodName, MetadataTargetTrailer, md))
+}
+
+// responseMetadataClientStream applies the metadata rules to the response headers and trailers of the calls of the
+// HTTP bridges.
+type responseMetadataClientStream struct {
+	grpc.ClientStream
+	ctx            context.Context
+	grp            *GrpcReverseProxy
+	fullMethodName string
+}
+
+func (s *responseMetadataClientStream) Header() (metadata.MD, error) {
+	md, err := s.ClientStream.Header()
+	if err != nil {
+		return md, err
+	}
+	return s.grp.transformResponseMetadata(s.ctx, s.fullMethodName, MetadataTargetHeader, md), nil
+}
+
+func (s *responseMetadataClientStream) Trailer() metadata.MD {
+	return s.grp.transformResponseMetadata(s.ctx, s.fullMethodName, MetadataTargetTrailer, s.ClientStream.Trailer())
+}
+
+// transformResponseMetadata returns the copy of the md transformed by the rules of the target.
+func (grp *GrpcReverseProxy) transformResponseMetadata(ctx context.Context, fullMethodName, target string, md metadata.MD) metadata.MD {
+	md = md.Copy()
+	grp.allowMetadata(fullMethodName, target, md)
+	grp.transformMetadata(ctx, fullMethodName, target, md)
+	return md
+}
@@ -0,0 +1,150 @@
+package reverse_proxy
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"reflect"
+	"testing"
+)
+
+type testPrincipal struct {
+	Subject string
+}
+
+func TestMetadataRulesRequest(t *testing.T) {
+	rp, err := NewReverseProxy(WithBackendAddr("127.0.0.1:1"), WithBackendInsecure(true),
+		WithPrincipalFunc(func(ctx context.Context) interface{} {
+			if md, _ := metadata.FromIncomingContext(ctx); len(md.Get("authorization")) > 0 {
+				return &testPrincipal{Subject: "alice"}
+			}
+			return nil
+		}),
+		WithMetadataRules(
+			MetadataRule{Action: MetadataActionAllow, Keys: []string{"authorization", "x-request-id", "X-B3-*", "x-legacy-tenant"}},
+			MetadataRule{Action: MetadataActionDrop, Keys: []string{"authorization"}},
+			MetadataRule{Action: MetadataActionRename, Keys: []string{"x-legacy-tenant"}, To: "x-tenant"},
+			MetadataRule{Routes: []string{"/com.example.billing."}, Action: MetadataActionSet, Keys: []string{"x-user-id"},
+				Value: "{{with .Principal}}{{.Subject}}{{end}}"},
+			MetadataRule{Action: MetadataActionAppend, Keys: []string{"x-trace"}, Value: "{{.ClientIp}}/{{.RequestId}}"},
+		))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transform := func(method string, md metadata.MD) metadata.MD {
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.7"), Port: 1234}})
+		md = md.Copy()
+		rp.allowMetadata(method, MetadataTargetRequest, md)
+		rp.transformMetadata(ctx, method, MetadataTargetRequest, md)
+		return md
+	}
+
+	in := metadata.Pairs("authorization", "Bearer x", "cookie", "session=1", "x-request-id", "r1",
+		"x-b3-traceid", "t1", "x-legacy-tenant", "acme", "x-user-id", "spoofed")
+	expected := metadata.MD{
+		"x-request-id": {"r1"},
+		"x-b3-traceid": {"t1"},
+		"x-tenant":     {"acme"},
+		"x-user-id":    {"alice"},
+		"x-trace":      {"192.0.2.7/r1"},
+	}
+	if md := transform("/com.example.billing.Invoices/Get", in); !reflect.DeepEqual(md, expected) {
+		t.Errorf("expected %v, but got %v", expected, md)
+	}
+	// the set rule is not of the route, and the spoofed value is not allowed.
+	delete(expected, "x-user-id")
+	if md := transform("/com.example.books.Books/Get", in); !reflect.DeepEqual(md, expected) {
+		t.Errorf("expected %v, but got %v", expected, md)
+	}
+	// the empty value of the anonymous caller is not set.
+	in.Delete("authorization")
+	if md := transform("/com.example.billing.Invoices/Get", in); len(md.Get("x-user-id")) != 0 {
+		t.Errorf("expected no x-user-id, but got %v", md.Get("x-user-id"))
+	}
+}
+
+func TestMetadataRulesAllowInjected(t *testing.T) {
+	rp, err := NewReverseProxy(WithBackendAddr("127.0.0.1:1"), WithBackendInsecure(true),
+		WithInjectedMetadata("x-jwt-sub", "x-client-cert-subject"),
+		WithMetadataRules(MetadataRule{Action: MetadataActionAllow, Keys: []string{"x-request-id"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := metadata.Pairs("x-request-id", "r1", "x-jwt-sub", "alice", "x-client-cert-subject", "CN=alice", "cookie", "session=1")
+	rp.allowMetadata("/com.example.books.Books/Get", MetadataTargetRequest, md)
+	expected := metadata.MD{
+		"x-request-id":          {"r1"},
+		"x-jwt-sub":             {"alice"},
+		"x-client-cert-subject": {"CN=alice"},
+	}
+	if !reflect.DeepEqual(md, expected) {
+		t.Errorf("expected %v, but got %v", expected, md)
+	}
+}
+
+func TestMetadataRulesValidation(t *testing.T) {
+	invalid := []MetadataRule{
+		{Action: "replace", Keys: []string{"a"}},
+		{Action: MetadataActionDrop},
+		{Target: "body", Action: MetadataActionDrop, Keys: []string{"a"}},
+		{Action: MetadataActionSet, Keys: []string{"grpc-status"}, Value: "0"},
+		{Action: MetadataActionSet, Keys: []string{"x-*"}, Value: "v"},
+		{Action: MetadataActionRename, Keys: []string{"a"}},
+		{Action: MetadataActionAppend, Keys: []string{"a"}, Value: "{{.Nope"},
+	}
+	for _, r := range invalid {
+		if _, err := compileMetadataRules([]MetadataRule{r}); err == nil {
+			t.Errorf("expected error of %+v, but got nil", r)
+		}
+	}
+}
+
+type recordingServerStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	header  metadata.MD
+	trailer metadata.MD
+}
+
+func (s *recordingServerStream) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+func (s *recordingServerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+func (s *recordingServerStream) SendHeader(md metadata.MD) error { return s.SetHeader(md) }
+func (s *recordingServerStream) SetTrailer(md metadata.MD)       { s.trailer = metadata.Join(s.trailer, md) }
+
+func TestMetadataRulesResponse(t *testing.T) {
+	rp, err := NewReverseProxy(WithBackendAddr("127.0.0.1:1"), WithBackendInsecure(true), WithMetadataRules(
+		MetadataRule{Target: MetadataTargetHeader, Action: MetadataActionDrop, Keys: []string{"x-backend-*"}},
+		MetadataRule{Target: MetadataTargetTrailer, Action: MetadataActionSet, Keys: []string{"x-method"}, Value: "{{.Method}}"},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := &recordingServerStream{}
+	err = rp.StreamServerInterceptor()(nil, ss, &grpc.StreamServerInfo{FullMethod: "/a.B/C"}, func(_ interface{}, s grpc.ServerStream) error {
+		if err := s.SendHeader(metadata.Pairs("x-backend-host", "10.0.0.3", "x-version", "2")); err != nil {
+			return err
+		}
+		s.SetTrailer(metadata.Pairs("x-count", "1"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := metadata.Pairs("x-version", "2"); !reflect.DeepEqual(ss.header, expected) {
+		t.Errorf("expected header %v, but got %v", expected, ss.header)
+	}
+	if expected := metadata.Pairs("x-count", "1", "x-method", "/a.B/C"); !reflect.DeepEqual(ss.trailer, expected) {
+		t.Errorf("expected trailer %v, but got %v", expected, ss.trailer)
+	}
+}
@@ -0,0 +1,124 @@
+package reverse_proxy
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	backendHandled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_gateway_backend_handled_total",
+		Help: "Total number of the calls proxied to the backends, by the resolved endpoint, the instance address, the cataloged method and the status code.",
+	}, []string{"endpoint", "instance", "grpc_service", "grpc_method", "grpc_code"})
+	backendDialSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "grpc_gateway_backend_dial_seconds",
+		Help: "Latency of dialing the backends, including the resolution of the endpoints.",
+	}, []string{"endpoint"})
+	backendDialFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_gateway_backend_dial_failures_total",
+		Help: "Total number of the failed dials of the backends, the endpoints never resolved are of \"unknown\".",
+	}, []string{"endpoint"})
+	backendPoolConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grpc_gateway_backend_pool_connections",
+		Help: "Number of the pooled connections to the backends.",
+	}, []string{"endpoint"})
+	reflectionFanOutSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "grpc_gateway_reflection_fan_out_seconds",
+		Help: "Duration of reflecting the services of all the backends to build the service catalog.",
+	})
+
+	backendHandlingSeconds     *prometheus.HistogramVec
+	backendHandlingSecondsOnce sync.Once
+)
+
+func init() {
+	prometheus.MustRegister(backendHandled, backendDialSeconds, backendDialFailures, backendPoolConnections, reflectionFanOutSeconds)
+}
+
+// EnableBackendHandlingTimeHistogram enables the histogram of the latencies of the calls proxied to the backends, by
+// the resolved endpoint and the instance address, with the buckets, or the prometheus.DefBuckets if nil. It takes effect
+// once, before the reverse proxies handle the calls.
+func EnableBackendHandlingTimeHistogram(buckets []float64) {
+	backendHandlingSecondsOnce.Do(func() {
+		if buckets == nil {
+			buckets = prometheus.DefBuckets
+		}
+		backendHandlingSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_gateway_backend_handling_seconds",
+			Help:    "Latency of the calls proxied to the backends, by the resolved endpoint and the instance address.",
+			Buckets: buckets,
+		}, []string{"endpoint", "instance", "grpc_service", "grpc_method"})
+		prometheus.MustRegister(backendHandlingSeconds)
+	})
+}
+
+// unknownLabel the label of the endpoints and the methods not known to the gateway, which are supplied by the clients and
+// would make the cardinality of the metrics unbounded.
+const unknownLabel = "unknown"
+
+// splitMethodName splits the full method name "/{service}/{method}" into the service and the method.
+func splitMethodName(fullMethodName string) (string, string) {
+	name := strings.TrimPrefix(fullMethodName, "/")
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return unknownLabel, unknownLabel
+}
+
+// backendMetricsHandler the stats.Handler of the connections to the backend of the endpoint, recording the calls by the
+// instance serving them. the methods not cataloged are labeled "unknown".
+type backendMetricsHandler struct {
+	endpoint  string
+	cataloged func(fullMethodName string) bool
+}
+
+type backendCallKey struct{}
+
+type backendCall struct {
+	sync.Mutex
+	service, method string
+	instance        string
+	start           time.Time
+}
+
+func (h backendMetricsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	c := &backendCall{start: time.Now(), service: unknownLabel, method: unknownLabel}
+	if h.cataloged == nil || h.cataloged(info.FullMethodName) {
+		c.service, c.method = splitMethodName(info.FullMethodName)
+	}
+	return context.WithValue(ctx, backendCallKey{}, c)
+}
+
+func (h backendMetricsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	c, ok := ctx.Value(backendCallKey{}).(*backendCall)
+	if !ok {
+		return
+	}
+	switch s := s.(type) {
+	case *stats.OutHeader:
+		if s.RemoteAddr != nil {
+			c.Lock()
+			c.instance = s.RemoteAddr.String()
+			c.Unlock()
+		}
+	case *stats.End:
+		c.Lock()
+		instance := c.instance
+		c.Unlock()
+		backendHandled.WithLabelValues(h.endpoint, instance, c.service, c.method, status.Code(s.Error).String()).Inc()
+		if backendHandlingSeconds != nil {
+			backendHandlingSeconds.WithLabelValues(h.endpoint, instance, c.service, c.method).Observe(s.EndTime.Sub(c.start).Seconds())
+		}
+	}
+}
+
+func (h backendMetricsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h backendMetricsHandler) HandleConn(context.Context, stats.ConnStats) {}
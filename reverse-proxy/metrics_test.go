@@ -0,0 +1,84 @@
+package reverse_proxy
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kratos/kratos/v2/registry"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"testing"
+)
+
+func TestBackendMetrics(t *testing.T) {
+	EnableBackendHandlingTimeHistogram([]float64{0.1, 1})
+	rp := startTestBackend(t)
+	endpoint := rp.opts.BackendAddr
+	conn, err := rp.resolveServerConnection(context.Background(), "/grpc.health.v1.Health/Check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the methods are labeled once cataloged.
+	if _, err = rp.ServiceCatalog(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.CollectAndCount(reflectionFanOutSeconds); got != 1 {
+		t.Errorf("expected the reflection fan-out histogram, but got %v", got)
+	}
+	if _, err = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{Service: "books"}); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{Service: "unknown"})
+	_ = conn.Invoke(context.Background(), "/grpc.health.v1.Health/Nope", &healthpb.HealthCheckRequest{}, &healthpb.HealthCheckResponse{})
+
+	for code, expected := range map[string]float64{"OK": 1, "NotFound": 1} {
+		got := testutil.ToFloat64(backendHandled.WithLabelValues(endpoint, endpoint, "grpc.health.v1.Health", "Check", code))
+		if got != expected {
+			t.Errorf("expected %v calls of %v to %v, but got %v", expected, code, endpoint, got)
+		}
+	}
+	if got := testutil.ToFloat64(backendHandled.WithLabelValues(endpoint, endpoint, unknownLabel, unknownLabel, "Unimplemented")); got != 1 {
+		t.Errorf("expected 1 call of the unknown method, but got %v", got)
+	}
+	if got := testutil.CollectAndCount(backendHandlingSeconds); got == 0 {
+		t.Errorf("expected the latency histogram of the backend, but got none")
+	}
+	if got := testutil.ToFloat64(backendPoolConnections.WithLabelValues(endpoint)); got != 1 {
+		t.Errorf("expected 1 pooled connection to %v, but got %v", endpoint, got)
+	}
+	if got := testutil.ToFloat64(backendDialFailures.WithLabelValues(endpoint)); got != 0 {
+		t.Errorf("expected no dial failure of %v, but got %v", endpoint, got)
+	}
+
+	// the failed dials of the endpoints parsed from the method names are of "unknown".
+	discovered, err := NewReverseProxy(WithBackendDiscovery(failingDiscovery{}), WithBackendInsecure(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = discovered.resolveServerConnection(context.Background(), "/com.example.nope.Nope/Get"); err == nil {
+		t.Fatalf("expected the dial to fail, but got nil")
+	}
+	if got := testutil.ToFloat64(backendDialFailures.WithLabelValues(unknownLabel)); got != 1 {
+		t.Errorf("expected 1 dial failure of unknown, but got %v", got)
+	}
+	if got := testutil.ToFloat64(backendDialFailures.WithLabelValues("com.example.nope.")); got != 0 {
+		t.Errorf("expected no dial failure labeled by the method name, but got %v", got)
+	}
+	if len(*discovered.backendConnPool.conns) != 0 {
+		t.Errorf("expected no pool of the failed endpoint, but got %v", len(*discovered.backendConnPool.conns))
+	}
+}
+
+// failingDiscovery the discovery failing to watch any service.
+type failingDiscovery struct{}
+
+func (failingDiscovery) GetService(context.Context, string) ([]*registry.ServiceInstance, error) {
+	return nil, errors.New("no such service")
+}
+
+func (failingDiscovery) Watch(context.Context, string) (registry.Watcher, error) {
+	return nil, errors.New("no such service")
+}
+
+func (failingDiscovery) ListServices() (map[string][]*registry.ServiceInstance, error) {
+	return nil, errors.New("unreachable")
+}
@@ -0,0 +1,411 @@
+package reverse_proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"html"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const openAPIStatusSchema = "google.rpc.Status"
+
+// OpenAPIHandler serves the OpenAPI 3 document describing all the HTTP transcodable methods, i.e. the ones with the
+// google.api.http annotations, of all the known services. The document is regenerated once the catalog changes.
+type OpenAPIHandler struct {
+	grp   *GrpcReverseProxy
+	title string
+	sync.Mutex
+	catalog *ServiceCatalog
+	doc     []byte
+}
+
+// OpenAPIHandler returns the handler serving the OpenAPI document in JSON, with the title as the title of the API.
+func (grp *GrpcReverseProxy) OpenAPIHandler(title string) *OpenAPIHandler {
+	return &OpenAPIHandler{grp: grp, title: title}
+}
+
+func (h *OpenAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	catalog, err := h.grp.ServiceCatalog(r.Context())
+	if err != nil {
+		writeHTTPError(w, status.Errorf(codes.Unavailable, "failed resolving services: %v", err), nil)
+		return
+	}
+	h.Lock()
+	if h.catalog != catalog {
+		h.doc, err = json.Marshal(buildOpenAPIDocument(h.title, catalog))
+		if err == nil {
+			h.catalog = catalog
+		}
+	}
+	doc := h.doc
+	h.Unlock()
+	if err != nil {
+		writeHTTPError(w, status.Errorf(codes.Internal, "failed generating the document: %v", err), nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(doc)
+}
+
+type openAPIBuilder struct {
+	schemas map[string]interface{}
+}
+
+func buildOpenAPIDocument(title string, catalog *ServiceCatalog) map[string]interface{} {
+	b := &openAPIBuilder{schemas: map[string]interface{}{
+		openAPIStatusSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"code":    map[string]interface{}{"type": "integer", "format": "int32"},
+				"message": map[string]interface{}{"type": "string"},
+				"details": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "object"}},
+			},
+		},
+	}}
+	paths := map[string]map[string]interface{}{}
+	operationIds := map[string]int{}
+	for _, route := range buildHttpRoutes(catalog) {
+		path, _ := route.template.openAPIPath()
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		operationId := string(route.method.Parent().Name()) + "_" + string(route.method.Name())
+		if n := operationIds[operationId]; n > 0 {
+			operationIds[operationId]++
+			operationId = fmt.Sprintf("%s%d", operationId, n+1)
+		} else {
+			operationIds[operationId] = 1
+		}
+		paths[path][strings.ToLower(route.httpMethod)] = b.operation(route, operationId)
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   title,
+			"version": "1.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": b.schemas,
+		},
+	}
+}
+
+func (b *openAPIBuilder) operation(route *httpRoute, operationId string) map[string]interface{} {
+	md := route.method
+	op := map[string]interface{}{
+		"operationId": operationId,
+		"tags":        []string{string(md.Parent().FullName())},
+	}
+	if comments := leadingComments(md); comments != "" {
+		op["description"] = comments
+	}
+	var parameters []interface{}
+	bound := map[string]struct{}{}
+	for _, v := range route.template.variables {
+		bound[v.fieldPath] = struct{}{}
+		schema := map[string]interface{}{"type": "string"}
+		if fd := findField(md.Input(), v.fieldPath); fd != nil {
+			schema = b.fieldSchema(fd)
+		}
+		parameters = append(parameters, map[string]interface{}{
+			"name":     v.fieldPath,
+			"in":       "path",
+			"required": true,
+			"schema":   schema,
+		})
+	}
+	_, wildcards := route.template.openAPIPath()
+	for _, name := range wildcards {
+		parameters = append(parameters, map[string]interface{}{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	if route.body != "*" {
+		fields := md.Input().Fields()
+		for i := 0; i < fields.Len(); i++ {
+			fd := fields.Get(i)
+			if _, ok := bound[string(fd.Name())]; ok || string(fd.Name()) == route.body || fd.IsMap() {
+				continue
+			}
+			if fd.Kind() == protoreflect.MessageKind && !isWellKnownScalar(fd.Message()) {
+				continue
+			}
+			parameters = append(parameters, map[string]interface{}{
+				"name":   fd.JSONName(),
+				"in":     "query",
+				"schema": b.fieldSchema(fd),
+			})
+		}
+	}
+	if len(parameters) > 0 {
+		op["parameters"] = parameters
+	}
+	if route.body != "" {
+		var schema interface{} = b.messageRef(md.Input())
+		if route.body != "*" {
+			if fd := findField(md.Input(), route.body); fd != nil {
+				schema = b.fieldSchema(fd)
+			}
+		}
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": schema},
+			},
+		}
+	}
+	var respSchema interface{} = b.messageRef(md.Output())
+	if route.responseBody != "" {
+		if fd := findField(md.Output(), route.responseBody); fd != nil {
+			respSchema = b.fieldSchema(fd)
+		}
+	}
+	content := map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": respSchema},
+	}
+	if md.IsStreamingServer() {
+		content = map[string]interface{}{
+			"application/x-ndjson": map[string]interface{}{"schema": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"result": respSchema,
+					"error":  map[string]interface{}{"$ref": "#/components/schemas/" + openAPIStatusSchema},
+				},
+			}},
+		}
+	}
+	op["responses"] = map[string]interface{}{
+		"200": map[string]interface{}{
+			"description": "A successful response.",
+			"content":     content,
+		},
+		"default": map[string]interface{}{
+			"description": "An unexpected error response.",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": map[string]interface{}{"$ref": "#/components/schemas/" + openAPIStatusSchema},
+				},
+			},
+		},
+	}
+	return op
+}
+
+// messageRef returns the reference to the schema of the message, with the schema added to the components if not yet.
+func (b *openAPIBuilder) messageRef(md protoreflect.MessageDescriptor) map[string]interface{} {
+	if schema, ok := wellKnownSchema(md); ok {
+		return schema
+	}
+	name := string(md.FullName())
+	ref := map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	if _, ok := b.schemas[name]; ok {
+		return ref
+	}
+	// placeholder for the recursive messages.
+	b.schemas[name] = nil
+	properties := map[string]interface{}{}
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		properties[fields.Get(i).JSONName()] = b.fieldSchema(fields.Get(i))
+	}
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if comments := leadingComments(md); comments != "" {
+		schema["description"] = comments
+	}
+	b.schemas[name] = schema
+	return ref
+}
+
+func (b *openAPIBuilder) fieldSchema(fd protoreflect.FieldDescriptor) map[string]interface{} {
+	if fd.IsMap() {
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": b.singularFieldSchema(fd.MapValue()),
+		}
+	}
+	schema := b.singularFieldSchema(fd)
+	if fd.IsList() {
+		schema = map[string]interface{}{"type": "array", "items": schema}
+	}
+	if comments := leadingComments(fd); comments != "" {
+		if _, ok := schema["$ref"]; !ok {
+			schema["description"] = comments
+		}
+	}
+	return schema
+}
+
+// singularFieldSchema returns the schema of a single value of the field, per the protobuf JSON mapping.
+func (b *openAPIBuilder) singularFieldSchema(fd protoreflect.FieldDescriptor) map[string]interface{} {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return map[string]interface{}{"type": "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return map[string]interface{}{"type": "string", "format": "int64"}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return map[string]interface{}{"type": "string", "format": "uint64"}
+	case protoreflect.FloatKind:
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case protoreflect.DoubleKind:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case protoreflect.BytesKind:
+		return map[string]interface{}{"type": "string", "format": "byte"}
+	case protoreflect.EnumKind:
+		var names []string
+		values := fd.Enum().Values()
+		for i := 0; i < values.Len(); i++ {
+			names = append(names, string(values.Get(i).Name()))
+		}
+		return map[string]interface{}{"type": "string", "enum": names}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return b.messageRef(fd.Message())
+	default:
+		return map[string]interface{}{"type": "string"}
+	}
+}
+
+// wellKnownSchema returns the schema of the well-known types which have the special JSON mapping.
+func wellKnownSchema(md protoreflect.MessageDescriptor) (map[string]interface{}, bool) {
+	switch md.FullName() {
+	case "google.protobuf.Timestamp":
+		return map[string]interface{}{"type": "string", "format": "date-time"}, true
+	case "google.protobuf.Duration", "google.protobuf.FieldMask":
+		return map[string]interface{}{"type": "string"}, true
+	case "google.protobuf.Struct", "google.protobuf.Empty":
+		return map[string]interface{}{"type": "object"}, true
+	case "google.protobuf.Value":
+		return map[string]interface{}{}, true
+	case "google.protobuf.ListValue":
+		return map[string]interface{}{"type": "array", "items": map[string]interface{}{}}, true
+	case "google.protobuf.Any":
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           map[string]interface{}{"@type": map[string]interface{}{"type": "string"}},
+			"additionalProperties": map[string]interface{}{},
+		}, true
+	case "google.protobuf.StringValue":
+		return map[string]interface{}{"type": "string"}, true
+	case "google.protobuf.BytesValue":
+		return map[string]interface{}{"type": "string", "format": "byte"}, true
+	case "google.protobuf.BoolValue":
+		return map[string]interface{}{"type": "boolean"}, true
+	case "google.protobuf.Int32Value", "google.protobuf.UInt32Value":
+		return map[string]interface{}{"type": "integer"}, true
+	case "google.protobuf.Int64Value", "google.protobuf.UInt64Value":
+		return map[string]interface{}{"type": "string", "format": "int64"}, true
+	case "google.protobuf.FloatValue", "google.protobuf.DoubleValue":
+		return map[string]interface{}{"type": "number"}, true
+	}
+	return nil, false
+}
+
+// isWellKnownScalar reports whether the message is represented as a scalar in JSON, so it can be set by a query parameter.
+func isWellKnownScalar(md protoreflect.MessageDescriptor) bool {
+	schema, ok := wellKnownSchema(md)
+	if !ok {
+		return false
+	}
+	typ, _ := schema["type"].(string)
+	return typ != "" && typ != "object" && typ != "array"
+}
+
+func leadingComments(d protoreflect.Descriptor) string {
+	return strings.TrimSpace(d.ParentFile().SourceLocations().ByDescriptor(d).LeadingComments)
+}
+
+// openAPIPath converts the template to the OpenAPI path, with the variables bound to multiple segments simplified, and
+// the unbound wildcards turned into the path parameters named in order, e.g.
+//
+//	/v1/{name=shelves/*}:publish => /v1/{name}:publish
+//	/v1/files/**                 => /v1/files/{wildcard1}
+func (t *httpPathTemplate) openAPIPath() (path string, wildcards []string) {
+	var sb strings.Builder
+	vi := 0
+	for i := 0; i < len(t.segments); i++ {
+		sb.WriteByte('/')
+		if vi < len(t.variables) && t.variables[vi].start == i {
+			sb.WriteString("{" + t.variables[vi].fieldPath + "}")
+			i = t.variables[vi].end - 1
+			vi++
+			continue
+		}
+		switch t.segments[i].kind {
+		case segmentLiteral:
+			sb.WriteString(t.segments[i].literal)
+		case segmentWildcard, segmentDeepWildcard:
+			wildcards = append(wildcards, fmt.Sprintf("wildcard%d", len(wildcards)+1))
+			sb.WriteString("{" + wildcards[len(wildcards)-1] + "}")
+		}
+	}
+	if sb.Len() == 0 {
+		sb.WriteByte('/')
+	}
+	if t.verb != "" {
+		sb.WriteString(":" + t.verb)
+	}
+	return sb.String(), wildcards
+}
+
+// DefaultSwaggerUIAssetsUrl the pinned release of swagger-ui-dist the Swagger UI page loads its assets from.
+const DefaultSwaggerUIAssetsUrl = "https://unpkg.com/swagger-ui-dist@4.15.5"
+
+// SwaggerUIAssets where the Swagger UI page loads swagger-ui.css and swagger-ui-bundle.js from.
+type SwaggerUIAssets struct {
+	// AssetsUrl the base URL of the swagger-ui-dist files, DefaultSwaggerUIAssetsUrl if empty.
+	AssetsUrl string
+	// CssIntegrity and BundleIntegrity the subresource integrity of swagger-ui.css and swagger-ui-bundle.js,
+	// e.g. "sha384-...", not checked by the browsers if empty.
+	CssIntegrity    string
+	BundleIntegrity string
+}
+
+// SwaggerUIHandler serves the Swagger UI page, loaded from the assets, for the OpenAPI document at the URL.
+func SwaggerUIHandler(specURL string, assets SwaggerUIAssets) http.Handler {
+	base := strings.TrimSuffix(assets.AssetsUrl, "/")
+	if base == "" {
+		base = DefaultSwaggerUIAssetsUrl
+	}
+	page := fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8"/>
+<title>Swagger UI</title>
+<link rel="stylesheet" href="%s"%s/>
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="%s"%s></script>
+<script>window.ui = SwaggerUIBundle({url: %q, dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`, html.EscapeString(base+"/swagger-ui.css"), integrityAttributes(assets.CssIntegrity),
+		html.EscapeString(base+"/swagger-ui-bundle.js"), integrityAttributes(assets.BundleIntegrity), specURL)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(page))
+	})
+}
+
+// integrityAttributes returns the HTML attributes checking the subresource integrity, none if empty.
+func integrityAttributes(integrity string) string {
+	if integrity == "" {
+		return ""
+	}
+	return fmt.Sprintf(` integrity="%s" crossorigin="anonymous"`, html.EscapeString(integrity))
+}
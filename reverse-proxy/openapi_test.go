@@ -0,0 +1,55 @@
+package reverse_proxy
+
+import (
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"testing"
+)
+
+func TestBuildOpenAPIDocument(t *testing.T) {
+	fd := testBookServiceFile(t)
+	files := &protoregistry.Files{}
+	if err := files.RegisterFile(fd); err != nil {
+		t.Fatal(err)
+	}
+	c := newServiceCatalog("", nil)
+	c.addFiles(files, []protoreflect.FullName{"test.v1.BookService"})
+	doc := buildOpenAPIDocument("test", c)
+	paths := doc["paths"].(map[string]map[string]interface{})
+	op, ok := paths["/v1/shelves/{shelf}/books/{book.id}"]["patch"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected the patch operation, but got %v", paths)
+	}
+	if op["operationId"] != "BookService_UpdateBook" {
+		t.Errorf("unexpected operation id %v", op["operationId"])
+	}
+	if params := op["parameters"].([]interface{}); len(params) != 3 {
+		t.Errorf("expected the 2 path and 1 query parameters, but got %v", params)
+	}
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	if _, ok = schemas["test.v1.Book"]; !ok {
+		t.Errorf("expected the schema of test.v1.Book, but got %v", schemas)
+	}
+}
+
+func TestOpenAPIPath(t *testing.T) {
+	cases := []struct {
+		template  string
+		path      string
+		wildcards int
+	}{
+		{"/v1/shelves/{shelf}", "/v1/shelves/{shelf}", 0},
+		{"/v1/{name=shelves/*}:publish", "/v1/{name}:publish", 0},
+		{"/v1/files/**", "/v1/files/{wildcard1}", 1},
+		{"/v1/*/files/**:get", "/v1/{wildcard1}/files/{wildcard2}:get", 2},
+	}
+	for _, c := range cases {
+		tpl, err := parsePathTemplate(c.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path, wildcards := tpl.openAPIPath(); path != c.path || len(wildcards) != c.wildcards {
+			t.Errorf("%v: expected %v with %v wildcards, but got %v with %v", c.template, c.path, c.wildcards, path, wildcards)
+		}
+	}
+}
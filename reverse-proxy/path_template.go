@@ -0,0 +1,186 @@
+package reverse_proxy
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	segmentLiteral = iota
+	segmentWildcard
+	segmentDeepWildcard
+)
+
+type templateSegment struct {
+	kind    int
+	literal string
+}
+
+type templateVariable struct {
+	fieldPath string
+	// start and end index of the segments the variable is bound to.
+	start, end int
+}
+
+// httpPathTemplate is the compiled path template of the google.api.http rules, e.g.
+//
+//	/v1/{name=shelves/*/books/*}:publish
+//
+// see https://github.com/googleapis/googleapis/blob/master/google/api/http.proto for the syntax.
+type httpPathTemplate struct {
+	template  string
+	segments  []templateSegment
+	variables []templateVariable
+	verb      string
+	// deep the index of the "**" segment, -1 if none.
+	deep int
+}
+
+func parsePathTemplate(template string) (*httpPathTemplate, error) {
+	if !strings.HasPrefix(template, "/") {
+		return nil, fmt.Errorf("path template %q must start with '/'", template)
+	}
+	t := &httpPathTemplate{template: template, deep: -1}
+	path := template[1:]
+	if i := strings.LastIndexByte(path, ':'); i >= 0 && i > strings.LastIndexByte(path, '/') && i > strings.LastIndexByte(path, '}') {
+		t.verb = path[i+1:]
+		path = path[:i]
+	}
+	for len(path) > 0 {
+		if path[0] == '{' {
+			end := strings.IndexByte(path, '}')
+			if end < 0 {
+				return nil, fmt.Errorf("path template %q has unclosed variable", template)
+			}
+			v := templateVariable{fieldPath: path[1:end], start: len(t.segments)}
+			sub := "*"
+			if eq := strings.IndexByte(v.fieldPath, '='); eq >= 0 {
+				v.fieldPath, sub = v.fieldPath[:eq], v.fieldPath[eq+1:]
+			}
+			if v.fieldPath == "" || sub == "" {
+				return nil, fmt.Errorf("path template %q has invalid variable", template)
+			}
+			for _, s := range strings.Split(sub, "/") {
+				if err := t.addSegment(s); err != nil {
+					return nil, err
+				}
+			}
+			v.end = len(t.segments)
+			t.variables = append(t.variables, v)
+			path = path[end+1:]
+		} else {
+			end := strings.IndexByte(path, '/')
+			if end < 0 {
+				end = len(path)
+			}
+			if err := t.addSegment(path[:end]); err != nil {
+				return nil, err
+			}
+			path = path[end:]
+		}
+		if len(path) > 0 {
+			if path[0] != '/' {
+				return nil, fmt.Errorf("path template %q has invalid segment", template)
+			}
+			path = path[1:]
+		}
+	}
+	return t, nil
+}
+
+func (t *httpPathTemplate) addSegment(s string) error {
+	switch {
+	case s == "":
+		return fmt.Errorf("path template %q has empty segment", t.template)
+	case strings.ContainsAny(s, "{}="):
+		return fmt.Errorf("path template %q has invalid segment %q", t.template, s)
+	case s == "**":
+		if t.deep >= 0 {
+			return fmt.Errorf("path template %q has more than one '**'", t.template)
+		}
+		t.deep = len(t.segments)
+		t.segments = append(t.segments, templateSegment{kind: segmentDeepWildcard})
+	case s == "*":
+		t.segments = append(t.segments, templateSegment{kind: segmentWildcard})
+	default:
+		t.segments = append(t.segments, templateSegment{kind: segmentLiteral, literal: s})
+	}
+	return nil
+}
+
+// literals the number of the literal segments, for the more specific templates to be matched first.
+func (t *httpPathTemplate) literals() int {
+	n := 0
+	for _, s := range t.segments {
+		if s.kind == segmentLiteral {
+			n++
+		}
+	}
+	return n
+}
+
+// match matches the escaped request path against the template, and returns the values of the variables by field path.
+func (t *httpPathTemplate) match(escapedPath string) (map[string]string, bool) {
+	if !strings.HasPrefix(escapedPath, "/") {
+		return nil, false
+	}
+	path := escapedPath[1:]
+	if t.verb != "" {
+		if !strings.HasSuffix(path, ":"+t.verb) {
+			return nil, false
+		}
+		path = path[:len(path)-len(t.verb)-1]
+	}
+	var components []string
+	if path != "" {
+		components = strings.Split(path, "/")
+	}
+	// the index of the first component matched by each segment, with an extra one marking the end.
+	bounds := make([]int, len(t.segments)+1)
+	if t.deep < 0 {
+		if len(components) != len(t.segments) {
+			return nil, false
+		}
+		for i := range bounds {
+			bounds[i] = i
+		}
+	} else {
+		extra := len(components) - len(t.segments) + 1
+		if extra < 0 {
+			return nil, false
+		}
+		for i := range bounds {
+			bounds[i] = i
+			if i > t.deep {
+				bounds[i] += extra - 1
+			}
+		}
+	}
+	for i, s := range t.segments {
+		switch s.kind {
+		case segmentWildcard:
+			if components[bounds[i]] == "" {
+				return nil, false
+			}
+		case segmentLiteral:
+			c, err := url.PathUnescape(components[bounds[i]])
+			if err != nil || c != s.literal {
+				return nil, false
+			}
+		}
+	}
+	values := map[string]string{}
+	for _, v := range t.variables {
+		parts := components[bounds[v.start]:bounds[v.end]]
+		unescaped := make([]string, len(parts))
+		for i, p := range parts {
+			var err error
+			if unescaped[i], err = url.PathUnescape(p); err != nil {
+				return nil, false
+			}
+		}
+		values[v.fieldPath] = strings.Join(unescaped, "/")
+	}
+	return values, true
+}
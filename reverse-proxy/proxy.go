@@ -2,12 +2,10 @@ package reverse_proxy
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"errors"
-	"fmt"
 	kgrpc "github.com/go-kratos/kratos/v2/transport/grpc"
 	"github.com/mwitkow/grpc-proxy/proxy"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
@@ -15,8 +13,10 @@ import (
 	"google.golang.org/grpc/metadata"
 	grpcReflection "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
 	"google.golang.org/grpc/status"
+	"grpc-gateway-x/accesslog"
+	"grpc-gateway-x/certs"
 	"grpc-gateway-x/discovery"
-	"os"
+	"grpc-gateway-x/tracing"
 	"strings"
 	"sync"
 	"time"
@@ -24,8 +24,17 @@ import (
 
 const DefaultBackendConnPoolSize = 3
 
+// DefaultMaxMessageSize the default maximum size of the request bodies of the HTTP APIs, the same as the gRPC one.
+const DefaultMaxMessageSize = 4 << 20
+
 type BackendProxyDirector proxy.StreamDirector
 
+// Authorizer authorizes the calls before they are proxied to the backends.
+type Authorizer interface {
+	// Authorize returns the error with the status code PERMISSION_DENIED if the call is not authorized.
+	Authorize(ctx context.Context, fullMethodName string) error
+}
+
 type BackendDialer func(context.Context, ...kgrpc.ClientOption) (*grpc.ClientConn, error)
 
 // EndpointParser parse endpoint part from grpc path, e.g.
@@ -45,6 +54,32 @@ type GrpcReverseProxyOptions struct {
 	BackendConnPoolSize  int
 	BackendTlsCaFile     string
 	BackendTlsVerifyCert bool
+	// BackendTlsCertFile and BackendTlsKeyFile the client certificate presented to the backends, none if empty.
+	BackendTlsCertFile string
+	BackendTlsKeyFile  string
+	// BackendTlsServerNames overrides the server name verified against the backend certificates by the routes.
+	BackendTlsServerNames []BackendTlsServerName
+	// DescriptorSetFiles compiled FileDescriptorSet files merged into the reflection answers.
+	DescriptorSetFiles []string
+	// Routes the prefixes of the full method names to be proxied, e.g. "/com.example.public." or "/com.example.Greeter/SayHello".
+	// the other methods are rejected as unimplemented, and hidden from the reflection and the HTTP APIs. all if empty.
+	Routes []string
+	// Authorizer authorizes the calls in the director, nil for all authorized.
+	Authorizer Authorizer
+	// MetadataRules transform the request metadata and the response headers and trailers of the calls by the routes.
+	MetadataRules []MetadataRule
+	// InjectedMetadata the request metadata keys set by the gateway itself, e.g. the identities of the callers by the
+	// authenticators, which are not subject to the allow rules.
+	InjectedMetadata []string
+	// PrincipalFunc resolves the authenticated caller of the calls for the templates of the MetadataRules.
+	PrincipalFunc PrincipalFunc
+	// RequestIdHeader the metadata key of the request id forwarded to the backends, default is "x-request-id".
+	RequestIdHeader string
+	// DescriptorCacheTTL how long the aggregated service descriptors and the discovered backends are cached before being
+	// listed and reflected again.
+	DescriptorCacheTTL time.Duration
+	// MaxMessageSize the maximum size in bytes of the request bodies read by the HTTP APIs.
+	MaxMessageSize int
 }
 type BackendConnPool struct {
 	sync.RWMutex
@@ -53,6 +88,11 @@ type BackendConnPool struct {
 type GrpcReverseProxy struct {
 	opts            *GrpcReverseProxyOptions
 	backendConnPool *BackendConnPool
+	descriptorSets  *descriptorSetStore
+	metadataRules   []*metadataRule
+	// backendCerts the client certificate and the CA bundle verifying the backends, nil if BackendInsecure.
+	backendCerts *certs.Store
+	catalogCache serviceCatalogCache
 	grpcReflection.UnimplementedServerReflectionServer
 }
 
@@ -62,6 +102,9 @@ func NewReverseProxy(opts ...GrpcReverseProxyOption) (*GrpcReverseProxy, error)
 			EndpointParser:      ParseEndpointFromGrpcRequestPath,
 			BackendInsecure:     false,
 			BackendConnPoolSize: DefaultBackendConnPoolSize,
+			DescriptorCacheTTL:  DefaultDescriptorCacheTTL,
+			MaxMessageSize:      DefaultMaxMessageSize,
+			RequestIdHeader:     DefaultRequestIdHeader,
 		},
 		backendConnPool: &BackendConnPool{
 			conns: &map[string]chan *grpc.ClientConn{},
@@ -73,40 +116,45 @@ func NewReverseProxy(opts ...GrpcReverseProxyOption) (*GrpcReverseProxy, error)
 	if grp.opts.BackendAddr == "" && grp.opts.BackendDiscovery == nil {
 		return nil, errors.New("none of BackendAddr or BackendDiscovery option is set")
 	}
+	var err error
+	if grp.metadataRules, err = compileMetadataRules(grp.opts.MetadataRules); err != nil {
+		return nil, err
+	}
+	if !grp.opts.BackendInsecure {
+		if grp.backendCerts, err = certs.NewStore("backend", grp.opts.BackendTlsCertFile, grp.opts.BackendTlsKeyFile, grp.opts.BackendTlsCaFile); err != nil {
+			return nil, err
+		}
+	}
+	grp.descriptorSets = newDescriptorSetStore(grp.opts.DescriptorSetFiles)
+	if err := grp.descriptorSets.load(); err != nil {
+		return nil, err
+	}
 	return grp, nil
 }
 
+// ReloadDescriptorSets re-reads the descriptor set files. On failure the previously loaded descriptors stay in use.
+func (grp *GrpcReverseProxy) ReloadDescriptorSets() error {
+	return grp.descriptorSets.load()
+}
+
 func (grp *GrpcReverseProxy) Director() BackendProxyDirector {
 	return grp.streamDirector
 }
 
-func (grp *GrpcReverseProxy) DialBackend(ctx context.Context, endpoint string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
-	var conn *grpc.ClientConn
-	var err error
+// DialBackend dials the backend of the endpoint address. The server name verified is the host of the address, as the
+// BackendTlsServerNames are of the routes.
+func (grp *GrpcReverseProxy) DialBackend(ctx context.Context, endpoint string, opts ...grpc.DialOption) (conn *grpc.ClientConn, err error) {
+	return grp.dialBackend(ctx, endpoint, "", opts...)
+}
+
+// dialBackend dials the backend of the endpoint address by the same TLS config as the proxied calls, verifying the
+// server name, or the host of the address if empty.
+func (grp *GrpcReverseProxy) dialBackend(ctx context.Context, endpoint string, serverName string, opts ...grpc.DialOption) (conn *grpc.ClientConn, err error) {
+	ctx, span := startSpan(ctx, "backend.dial", attribute.String("backend.endpoint", endpoint))
+	defer func() { endSpan(span, err) }()
 	var backendCredential credentials.TransportCredentials
 	if !grp.opts.BackendInsecure {
-		tlsCfg := &tls.Config{
-			InsecureSkipVerify: !grp.opts.BackendTlsVerifyCert,
-		}
-		var cp *x509.CertPool
-		if grp.opts.BackendTlsCaFile != "" {
-			var cab []byte
-			cab, err = os.ReadFile(grp.opts.BackendTlsCaFile)
-			if err != nil {
-				return nil, err
-			}
-			cp = x509.NewCertPool()
-			if !cp.AppendCertsFromPEM(cab) {
-				return nil, fmt.Errorf("credentials: failed to append ca certificates")
-			}
-		} else {
-			cp, err = x509.SystemCertPool()
-			if err != nil {
-				return nil, fmt.Errorf("failed to create system cert pool, err: %v", err)
-			}
-		}
-		tlsCfg.RootCAs = cp
-		backendCredential = credentials.NewTLS(tlsCfg)
+		backendCredential = grp.backendCredentials(serverName)
 	} else {
 		backendCredential = insecure.NewCredentials()
 	}
@@ -116,23 +164,41 @@ func (grp *GrpcReverseProxy) DialBackend(ctx context.Context, endpoint string, o
 }
 
 func (grp *GrpcReverseProxy) streamDirector(ctx context.Context, serviceFullMethodName string) (context.Context, *grpc.ClientConn, error) {
+	if !grp.isRouted(serviceFullMethodName) {
+		return nil, nil, unroutedError(serviceFullMethodName)
+	}
+	if grp.opts.Authorizer != nil {
+		if err := grp.opts.Authorizer.Authorize(ctx, serviceFullMethodName); err != nil {
+			return nil, nil, err
+		}
+	}
 	md, _ := metadata.FromIncomingContext(ctx)
-	outCtx, _ := context.WithCancel(ctx)
 	mdCopy := md.Copy()
 	delete(mdCopy, "user-agent")
 	// If this header is present in the request from the web client,
 	// the actual connection to the backend will not be established.
 	// https://github.com/improbable-eng/grpc-web/issues/568
 	delete(mdCopy, "connection")
-	outCtx = metadata.NewOutgoingContext(outCtx, mdCopy)
-	backendConn, err := grp.resolveServerConnection(serviceFullMethodName)
+	grp.allowMetadata(serviceFullMethodName, MetadataTargetRequest, mdCopy)
+	// the request id is not subject to the allowlist.
+	if id, ok := RequestIdFromContext(ctx); ok {
+		mdCopy.Set(grp.opts.RequestIdHeader, id)
+	}
+	if ip := clientIp(ctx); ip != "" {
+		mdCopy.Append("x-forwarded-for", ip)
+	}
+	grp.transformMetadata(ctx, serviceFullMethodName, MetadataTargetRequest, mdCopy)
+	// the backends continue the trace of the gateway, which replaces the trace context sent by the client.
+	tracing.InjectMetadata(ctx, mdCopy)
+	outCtx := metadata.NewOutgoingContext(ctx, mdCopy)
+	backendConn, err := grp.resolveServerConnection(ctx, serviceFullMethodName)
 	if err != nil {
 		return nil, nil, err
 	}
 	return outCtx, backendConn, nil
 }
 
-func (grp *GrpcReverseProxy) resolveServerConnection(serviceFullMethodName string) (conn *grpc.ClientConn, err error) {
+func (grp *GrpcReverseProxy) resolveServerConnection(ctx context.Context, serviceFullMethodName string) (conn *grpc.ClientConn, err error) {
 	var endpoint string
 	if grp.opts.BackendAddr != "" {
 		endpoint = grp.opts.BackendAddr
@@ -142,12 +208,20 @@ func (grp *GrpcReverseProxy) resolveServerConnection(serviceFullMethodName strin
 			return nil, err
 		}
 	}
+	accesslog.SetEndpoint(ctx, endpoint)
+	serverName := grp.backendServerName(serviceFullMethodName)
+	// the connections verifying different server names are pooled apart.
+	poolKey := endpoint
+	if serverName != "" {
+		poolKey = endpoint + "#" + serverName
+	}
 	grp.backendConnPool.Lock()
 	defer grp.backendConnPool.Unlock()
-	conns, ok := (*grp.backendConnPool.conns)[endpoint]
+	// the pool of the endpoint is kept once it's dialed, so as the endpoints of the arbitrary method names failing to
+	// resolve are not.
+	conns, ok := (*grp.backendConnPool.conns)[poolKey]
 	if !ok {
 		conns = make(chan *grpc.ClientConn, grp.opts.BackendConnPoolSize)
-		(*grp.backendConnPool.conns)[endpoint] = conns
 	}
 	select {
 	case conn = <-conns:
@@ -158,13 +232,16 @@ func (grp *GrpcReverseProxy) resolveServerConnection(serviceFullMethodName strin
 		return conn, nil
 	}
 	var dialer BackendDialer
+	dialOpts := []kgrpc.ClientOption{
+		// the backend instance serving each call is recorded for the access log.
+		kgrpc.WithOptions(grpc.WithBlock(), grpc.WithStatsHandler(accesslog.BackendStatsHandler{}),
+			grpc.WithStatsHandler(backendMetricsHandler{endpoint: endpoint, cataloged: grp.isMethodCataloged})),
+	}
 	if grp.opts.BackendInsecure {
 		dialer = kgrpc.DialInsecure
 	} else {
 		dialer = kgrpc.Dial
-	}
-	dialOpts := []kgrpc.ClientOption{
-		kgrpc.WithOptions(grpc.WithBlock()),
+		dialOpts = append(dialOpts, kgrpc.WithOptions(grpc.WithTransportCredentials(grp.backendCredentials(serverName))))
 	}
 	endpointWithScheme := "discovery:///" + endpoint
 	// if backend address is explicitly specified, the address will be used and the service discovery will be ignored.
@@ -174,16 +251,29 @@ func (grp *GrpcReverseProxy) resolveServerConnection(serviceFullMethodName strin
 		dialOpts = append(dialOpts, kgrpc.WithDiscovery(grp.opts.BackendDiscovery))
 	}
 	dialOpts = append(dialOpts, kgrpc.WithEndpoint(endpointWithScheme))
-	ctx, cls := context.WithTimeout(context.TODO(), time.Second*2)
+	// the dial, including the discovery resolution, is traced as a part of the call opening the connection.
+	_, span := startSpan(ctx, "backend.dial", attribute.String("backend.endpoint", endpointWithScheme))
+	defer func() { endSpan(span, err) }()
+	dialCtx, cls := context.WithTimeout(context.TODO(), time.Second*2)
 	defer cls()
-	conn, err = dialer(ctx, dialOpts...)
+	dialStart := time.Now()
+	conn, err = dialer(dialCtx, dialOpts...)
+	// the endpoints parsed from the method names are labeled once resolved, the failures of the others are of "unknown".
+	endpointLabel := endpoint
+	if err != nil && grp.opts.BackendAddr == "" && !ok {
+		endpointLabel = unknownLabel
+	}
+	backendDialSeconds.WithLabelValues(endpointLabel).Observe(time.Since(dialStart).Seconds())
 	if err != nil {
+		backendDialFailures.WithLabelValues(endpointLabel).Inc()
 		if context.DeadlineExceeded == err {
 			err = status.New(codes.NotFound, "Resolving or dialing service timed out. this may be caused by invalid service name or unreachable backend server, service full method name: "+serviceFullMethodName).Err()
 		}
 		return nil, err
 	}
 	conns <- conn
+	(*grp.backendConnPool.conns)[poolKey] = conns
+	backendPoolConnections.WithLabelValues(endpoint).Inc()
 	return
 }
 
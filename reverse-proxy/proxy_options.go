@@ -2,6 +2,7 @@ package reverse_proxy
 
 import (
 	"grpc-gateway-x/discovery"
+	"time"
 )
 
 // WithEndpointParser set the parser to parse server endpoint from the grpc request path. if WithBackendAddr option is set, the parser won't be used.
@@ -20,6 +21,7 @@ func WithBackendInsecure(sec bool) GrpcReverseProxyOption {
 // WithBackendAddr set the option BackendAddr, e.g.
 //
 //	"127.0.0.1:3212"
+//	"unix:///var/run/backend/grpc.sock"
 func WithBackendAddr(addr string) GrpcReverseProxyOption {
 	return func(opts *GrpcReverseProxyOptions) {
 		opts.BackendAddr = addr
@@ -43,3 +45,95 @@ func WithBackendTlsVerifyCert(verifyCert bool) GrpcReverseProxyOption {
 		opts.BackendTlsVerifyCert = verifyCert
 	}
 }
+
+// WithBackendTlsClientCert set the client certificate and key files presented to the backends requiring mTLS. The files
+// are reloaded by ReloadBackendTls.
+func WithBackendTlsClientCert(certFile, keyFile string) GrpcReverseProxyOption {
+	return func(opts *GrpcReverseProxyOptions) {
+		opts.BackendTlsCertFile = certFile
+		opts.BackendTlsKeyFile = keyFile
+	}
+}
+
+// WithBackendTlsServerNames set the server names verified against the backend certificates by the routes, e.g.
+//
+//	WithBackendTlsServerNames(BackendTlsServerName{Route: "/com.example.billing.", ServerName: "billing.internal.example"})
+func WithBackendTlsServerNames(serverNames ...BackendTlsServerName) GrpcReverseProxyOption {
+	return func(opts *GrpcReverseProxyOptions) {
+		opts.BackendTlsServerNames = serverNames
+	}
+}
+
+// WithMetadataRules set the rules transforming the request metadata and the response headers and trailers by the routes.
+func WithMetadataRules(rules ...MetadataRule) GrpcReverseProxyOption {
+	return func(opts *GrpcReverseProxyOptions) {
+		opts.MetadataRules = rules
+	}
+}
+
+// WithInjectedMetadata set the request metadata keys set by the gateway itself, e.g. by the authenticators, which are
+// forwarded regardless of the allow rules of the metadata rules.
+func WithInjectedMetadata(keys ...string) GrpcReverseProxyOption {
+	return func(opts *GrpcReverseProxyOptions) {
+		opts.InjectedMetadata = keys
+	}
+}
+
+// WithPrincipalFunc set the func resolving the authenticated caller for the templates of the metadata rules.
+func WithPrincipalFunc(f PrincipalFunc) GrpcReverseProxyOption {
+	return func(opts *GrpcReverseProxyOptions) {
+		opts.PrincipalFunc = f
+	}
+}
+
+// WithRequestIdHeader set the metadata key of the request id forwarded to the backends, as the one of the request id
+// interceptors and handler.
+func WithRequestIdHeader(header string) GrpcReverseProxyOption {
+	return func(opts *GrpcReverseProxyOptions) {
+		opts.RequestIdHeader = requestIdHeader(header)
+	}
+}
+
+// WithDescriptorSetFiles set the compiled FileDescriptorSet files (e.g. from `protoc --descriptor_set_out` or `buf build`)
+// to be served by the reflection service along with the ones reflected from the backends.
+func WithDescriptorSetFiles(paths ...string) GrpcReverseProxyOption {
+	return func(opts *GrpcReverseProxyOptions) {
+		opts.DescriptorSetFiles = paths
+	}
+}
+
+// WithDescriptorCacheTTL set how long the service descriptors aggregated from the backends' reflection, and the
+// backends discovered, are cached. The cache is rebuilt anyway when the descriptor sets change, the default is kept if
+// not positive.
+func WithDescriptorCacheTTL(ttl time.Duration) GrpcReverseProxyOption {
+	return func(opts *GrpcReverseProxyOptions) {
+		if ttl > 0 {
+			opts.DescriptorCacheTTL = ttl
+		}
+	}
+}
+
+// WithMaxMessageSize set the maximum size in bytes of the request bodies read by the HTTP APIs, larger ones are
+// rejected as RESOURCE_EXHAUSTED. The default is kept if not positive.
+func WithMaxMessageSize(size int) GrpcReverseProxyOption {
+	return func(opts *GrpcReverseProxyOptions) {
+		if size > 0 {
+			opts.MaxMessageSize = size
+		}
+	}
+}
+
+// WithRoutes set the prefixes of the full method names to be proxied, e.g. "/com.example.public.", the other methods are
+// rejected and hidden from the reflection. All the methods are proxied if not set.
+func WithRoutes(prefixes ...string) GrpcReverseProxyOption {
+	return func(opts *GrpcReverseProxyOptions) {
+		opts.Routes = prefixes
+	}
+}
+
+// WithAuthorizer set the authorizer of the calls, which is evaluated in the director before connecting the backends.
+func WithAuthorizer(authorizer Authorizer) GrpcReverseProxyOption {
+	return func(opts *GrpcReverseProxyOptions) {
+		opts.Authorizer = authorizer
+	}
+}
@@ -1,6 +1,15 @@
 package reverse_proxy
 
-import "testing"
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"reflect"
+	"testing"
+)
 
 func TestParseEndpointFromGrpcRequestPath(t *testing.T) {
 	expected := "com.veigit.dimpocp.fsosi.grpc.v1"
@@ -15,3 +24,33 @@ func TestParseEndpointFromGrpcRequestPath(t *testing.T) {
 		t.Errorf("expected: %v, but got %v", expected, endpoint)
 	}
 }
+
+func TestStreamDirectorForwardedFor(t *testing.T) {
+	rp := startTestBackend(t)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-forwarded-for", "10.0.0.1"))
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.7"), Port: 1234}})
+	outCtx, _, err := rp.streamDirector(ctx, "/grpc.health.v1.Health/Check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, _ := metadata.FromOutgoingContext(outCtx)
+	if got := md.Get("x-forwarded-for"); !reflect.DeepEqual(got, []string{"10.0.0.1", "192.0.2.7"}) {
+		t.Errorf("expected x-forwarded-for [10.0.0.1 192.0.2.7], but got %v", got)
+	}
+}
+
+type denyingAuthorizer struct{}
+
+func (denyingAuthorizer) Authorize(_ context.Context, fullMethodName string) error {
+	return status.Errorf(codes.PermissionDenied, "permission denied to call %v", fullMethodName)
+}
+
+func TestStreamDirectorAuthorizer(t *testing.T) {
+	rp, err := NewReverseProxy(WithBackendAddr("127.0.0.1:1"), WithAuthorizer(denyingAuthorizer{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = rp.streamDirector(context.Background(), "/a.B/C"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied before connecting the backend, but got %v", err)
+	}
+}
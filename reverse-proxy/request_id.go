@@ -0,0 +1,153 @@
+package reverse_proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus/ctxlogrus"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"strings"
+)
+
+// DefaultRequestIdHeader the default metadata key of the request id.
+const DefaultRequestIdHeader = "x-request-id"
+
+// maxRequestIdLength the longest request id accepted from the clients, the longer ones are replaced.
+const maxRequestIdLength = 128
+
+type requestIdKey struct{}
+
+// NewRequestId generates a random request id in the form of the UUID version 4.
+func NewRequestId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	s := hex.EncodeToString(b)
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// RequestIdFromContext returns the request id of the call, set by the request id interceptor or handler.
+func RequestIdFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIdKey{}).(string)
+	return id, ok
+}
+
+// requestIdHeader returns the lower case header, or the default one if empty.
+func requestIdHeader(header string) string {
+	if header == "" {
+		return DefaultRequestIdHeader
+	}
+	return strings.ToLower(header)
+}
+
+// isValidRequestId reports whether the request id from the clients is accepted, i.e. of the printable ASCII.
+func isValidRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIdContext takes the request id from the incoming metadata of the header, or generates one if absent or
+// invalid, and returns the context carrying it in both the incoming metadata and the value.
+func requestIdContext(ctx context.Context, header string) (context.Context, string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	md = md.Copy()
+	id := ""
+	if ids := md.Get(header); len(ids) > 0 && isValidRequestId(ids[0]) {
+		id = ids[0]
+	} else {
+		id = NewRequestId()
+	}
+	md.Set(header, id)
+	ctxlogrus.AddFields(ctx, logrus.Fields{"request_id": id})
+	ctx = metadata.NewIncomingContext(ctx, md)
+	return context.WithValue(ctx, requestIdKey{}, id), id
+}
+
+// RequestIdStreamServerInterceptor returns the interceptor taking or generating the request id of the calls in the
+// header metadata, which is added to the logs, forwarded to the backends and sent back in the response header and
+// trailer, replacing the ones of the backends.
+func RequestIdStreamServerInterceptor(header string) grpc.StreamServerInterceptor {
+	header = requestIdHeader(header)
+	return func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, id := requestIdContext(ss.Context(), header)
+		_ = ss.SetHeader(metadata.Pairs(header, id))
+		err := handler(srv, &requestIdServerStream{ServerStream: ss, ctx: ctx, header: header})
+		ss.SetTrailer(metadata.Pairs(header, id))
+		return err
+	}
+}
+
+// RequestIdUnaryServerInterceptor is the unary counterpart of RequestIdStreamServerInterceptor, for the services
+// served by the gateway itself.
+func RequestIdUnaryServerInterceptor(header string) grpc.UnaryServerInterceptor {
+	header = requestIdHeader(header)
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, id := requestIdContext(ctx, header)
+		_ = grpc.SetHeader(ctx, metadata.Pairs(header, id))
+		resp, err := handler(ctx, req)
+		_ = grpc.SetTrailer(ctx, metadata.Pairs(header, id))
+		return resp, err
+	}
+}
+
+type requestIdServerStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	header string
+}
+
+func (s *requestIdServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *requestIdServerStream) SetHeader(md metadata.MD) error {
+	return s.ServerStream.SetHeader(s.withoutRequestId(md))
+}
+
+func (s *requestIdServerStream) SendHeader(md metadata.MD) error {
+	return s.ServerStream.SendHeader(s.withoutRequestId(md))
+}
+
+func (s *requestIdServerStream) SetTrailer(md metadata.MD) {
+	s.ServerStream.SetTrailer(s.withoutRequestId(md))
+}
+
+func (s *requestIdServerStream) withoutRequestId(md metadata.MD) metadata.MD {
+	if _, ok := md[s.header]; !ok {
+		return md
+	}
+	md = md.Copy()
+	delete(md, s.header)
+	return md
+}
+
+// RequestIdHandler returns the handler taking or generating the request id of the HTTP requests bridged to the calls in
+// the header, which is forwarded to the backends and sent back in the response header, exposed to the cross-origin
+// clients as well.
+func RequestIdHandler(header string, next http.Handler) http.Handler {
+	header = requestIdHeader(header)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(header)
+		if !isValidRequestId(id) {
+			id = NewRequestId()
+		}
+		r = r.WithContext(context.WithValue(r.Context(), requestIdKey{}, id))
+		r.Header.Set(header, id)
+		w.Header().Set(header, id)
+		if r.Header.Get("Origin") != "" {
+			w.Header().Add("Access-Control-Expose-Headers", http.CanonicalHeaderKey(header))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
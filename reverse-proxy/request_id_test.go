@@ -0,0 +1,99 @@
+package reverse_proxy
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestNewRequestId(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if id := NewRequestId(); !uuid.MatchString(id) {
+		t.Errorf("expected UUID v4, but got %v", id)
+	}
+	if NewRequestId() == NewRequestId() {
+		t.Errorf("expected unique request ids")
+	}
+}
+
+func TestRequestIdStreamServerInterceptor(t *testing.T) {
+	interceptor := RequestIdStreamServerInterceptor("X-Request-Id")
+	call := func(md metadata.MD) (*recordingServerStream, string) {
+		ss := &recordingServerStream{ctx: metadata.NewIncomingContext(context.Background(), md)}
+		var seen string
+		err := interceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: "/a.B/C"}, func(_ interface{}, s grpc.ServerStream) error {
+			seen, _ = RequestIdFromContext(s.Context())
+			in, _ := metadata.FromIncomingContext(s.Context())
+			if !reflect.DeepEqual(in.Get("x-request-id"), []string{seen}) {
+				t.Errorf("expected incoming metadata of %v, but got %v", seen, in.Get("x-request-id"))
+			}
+			// the backend's own request id is replaced.
+			return s.SendHeader(metadata.Pairs("x-request-id", "backend", "x-version", "2"))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ss, seen
+	}
+
+	ss, id := call(metadata.Pairs("x-request-id", "client-1"))
+	if id != "client-1" {
+		t.Errorf("expected client-1 accepted, but got %v", id)
+	}
+	if expected := metadata.Pairs("x-request-id", "client-1", "x-version", "2"); !reflect.DeepEqual(ss.header, expected) {
+		t.Errorf("expected header %v, but got %v", expected, ss.header)
+	}
+	if !reflect.DeepEqual(ss.trailer.Get("x-request-id"), []string{"client-1"}) {
+		t.Errorf("expected trailer of client-1, but got %v", ss.trailer)
+	}
+	if _, id = call(metadata.Pairs("x-request-id", "bad id\n")); id == "" || id == "bad id\n" {
+		t.Errorf("expected generated request id, but got %q", id)
+	}
+	if _, id = call(metadata.MD{}); id == "" {
+		t.Errorf("expected generated request id, but got empty")
+	}
+}
+
+func TestStreamDirectorRequestId(t *testing.T) {
+	rp := startTestBackend(t)
+	rp.opts.MetadataRules = []MetadataRule{{Action: MetadataActionAllow, Keys: []string{"authorization"}}}
+	var err error
+	if rp.metadataRules, err = compileMetadataRules(rp.opts.MetadataRules); err != nil {
+		t.Fatal(err)
+	}
+	ctx, id := requestIdContext(metadata.NewIncomingContext(context.Background(), metadata.MD{}), DefaultRequestIdHeader)
+	outCtx, _, err := rp.streamDirector(ctx, "/grpc.health.v1.Health/Check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, _ := metadata.FromOutgoingContext(outCtx)
+	if got := md.Get("x-request-id"); !reflect.DeepEqual(got, []string{id}) {
+		t.Errorf("expected x-request-id %v despite the allowlist, but got %v", id, got)
+	}
+}
+
+func TestRequestIdHandler(t *testing.T) {
+	var forwarded string
+	h := RequestIdHandler("X-Correlation-Id", http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		id, _ := RequestIdFromContext(r.Context())
+		if id != r.Header.Get("X-Correlation-Id") {
+			t.Errorf("expected the header %v, but got %v", id, r.Header.Get("X-Correlation-Id"))
+		}
+		forwarded = id
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/v1/invoke/a.B/C", nil)
+	r.Header.Set("Origin", "https://app.example")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if forwarded == "" || w.Header().Get("X-Correlation-Id") != forwarded {
+		t.Errorf("expected the response header of %v, but got %v", forwarded, w.Header().Get("X-Correlation-Id"))
+	}
+	if w.Header().Get("Access-Control-Expose-Headers") != "X-Correlation-Id" {
+		t.Errorf("expected X-Correlation-Id exposed, but got %v", w.Header().Get("Access-Control-Expose-Headers"))
+	}
+}
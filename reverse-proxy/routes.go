@@ -0,0 +1,39 @@
+package reverse_proxy
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// isRouted reports whether the method is proxied per the Routes option, i.e. the full method name starts with any
+// of the routes. All the methods are routed if Routes is empty.
+func (grp *GrpcReverseProxy) isRouted(fullMethodName string) bool {
+	if len(grp.opts.Routes) == 0 {
+		return true
+	}
+	for _, route := range grp.opts.Routes {
+		if strings.HasPrefix(fullMethodName, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// isServiceRouted reports whether any method of the service is routed.
+func (grp *GrpcReverseProxy) isServiceRouted(service string) bool {
+	if len(grp.opts.Routes) == 0 {
+		return true
+	}
+	prefix := "/" + service + "/"
+	for _, route := range grp.opts.Routes {
+		if strings.HasPrefix(prefix, route) || strings.HasPrefix(route, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func unroutedError(fullMethodName string) error {
+	return status.Errorf(codes.Unimplemented, "unknown service or method %v", fullMethodName)
+}
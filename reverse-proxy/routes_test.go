@@ -0,0 +1,44 @@
+package reverse_proxy
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestRoutes(t *testing.T) {
+	rp, err := NewReverseProxy(WithBackendAddr("127.0.0.1:1"), WithRoutes("/com.example.public.", "/com.example.Admin/Ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for method, expect := range map[string]bool{
+		"/com.example.public.Greeter/SayHello": true,
+		"/com.example.Admin/Ping":              true,
+		"/com.example.Admin/Shutdown":          false,
+		"/com.example.internal.Jobs/Run":       false,
+	} {
+		if got := rp.isRouted(method); got != expect {
+			t.Errorf("isRouted(%v): expected %v, but got %v", method, expect, got)
+		}
+	}
+	for service, expect := range map[string]bool{
+		"com.example.public.Greeter":   true,
+		"com.example.Admin":            true,
+		"com.example.AdminV2":          false,
+		"com.example.internal.Jobs":    false,
+		"grpc.reflection.v1alpha.Info": false,
+	} {
+		if got := rp.isServiceRouted(service); got != expect {
+			t.Errorf("isServiceRouted(%v): expected %v, but got %v", service, expect, got)
+		}
+	}
+	if _, _, err = rp.streamDirector(context.Background(), "/com.example.Admin/Shutdown"); status.Code(err) != codes.Unimplemented {
+		t.Errorf("expected Unimplemented for the unrouted method, but got %v", err)
+	}
+
+	all, _ := NewReverseProxy(WithBackendAddr("127.0.0.1:1"))
+	if !all.isRouted("/any.Service/Method") || !all.isServiceRouted("any.Service") {
+		t.Error("expected all the methods routed without Routes")
+	}
+}
@@ -2,19 +2,26 @@ package reverse_proxy
 
 import (
 	"context"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	grpcReflection "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
 	"io"
 	"strings"
 	"time"
 )
 
-func (grp *GrpcReverseProxy) getEndpointServerReflectionResponse(reqCtx context.Context, req *grpcReflection.ServerReflectionRequest, endpoint string) (*grpcReflection.ServerReflectionResponse, error) {
+func (grp *GrpcReverseProxy) getEndpointServerReflectionResponse(reqCtx context.Context, req *grpcReflection.ServerReflectionRequest, endpoint reflectionEndpoint) (resp *grpcReflection.ServerReflectionResponse, err error) {
+	reqCtx, span := startSpan(reqCtx, "reflection.endpoint", attribute.String("backend.endpoint", endpoint.addr))
+	defer func() { endSpan(span, err) }()
 	var conn *grpc.ClientConn
-	var err error
-	dialCtx, cls := context.WithTimeout(context.Background(), time.Second*3)
+	// the dial is traced under the span of the request, but is not canceled along with it.
+	dialCtx, cls := context.WithTimeout(detachedContext{reqCtx}, time.Second*3)
 	defer cls()
-	conn, err = grp.DialBackend(dialCtx, endpoint)
+	conn, err = grp.dialBackend(dialCtx, endpoint.addr, endpoint.serverName)
 	if err != nil {
 		return nil, err
 	}
@@ -31,23 +38,31 @@ func (grp *GrpcReverseProxy) getEndpointServerReflectionResponse(reqCtx context.
 	}
 	return infoC.Recv()
 }
+
+// isReflectionUnavailable reports whether the backend has the reflection service disabled, in which case
+// the backend is skipped and the answers are made up of the other backends and the static descriptor sets.
+func isReflectionUnavailable(err error) bool {
+	return status.Code(err) == codes.Unimplemented
+}
+
+func uniqueInt32s(in []int32) []int32 {
+	seen := map[int32]struct{}{}
+	out := in[:0]
+	for _, v := range in {
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		out = append(out, v)
+	}
+	return out
+}
+
 func (grp *GrpcReverseProxy) ServerReflectionInfo(stream grpcReflection.ServerReflection_ServerReflectionInfoServer) error {
-	sis, err := grp.opts.BackendDiscovery.ListServices()
+	uniqueGrpcServiceEndpoints, err := grp.reflectionEndpoints(stream.Context())
 	if err != nil {
 		return err
 	}
-	var uniqueGrpcServiceEndpoints []string
-	for _, si := range sis {
-	SearchEP:
-		for _, sie := range si {
-			for _, ep := range sie.Endpoints {
-				if strings.Index(ep, "grpc://") == 0 {
-					uniqueGrpcServiceEndpoints = append(uniqueGrpcServiceEndpoints, ep[7:])
-					break SearchEP
-				}
-			}
-		}
-	}
 
 	for {
 		in, err := stream.Recv()
@@ -68,11 +83,18 @@ func (grp *GrpcReverseProxy) ServerReflectionInfo(stream grpcReflection.ServerRe
 			reqM := &grpcReflection.ServerReflectionRequest_FileByFilename{
 				FileByFilename: req.FileByFilename,
 			}
-			var respFileDescriptorProto [][]byte
+			// descriptors from the static descriptor sets take precedence over the reflected ones.
+			respFileDescriptorProto := grp.descriptorSets.FileByFilename(req.FileByFilename)
 			nReq.Host = in.Host
 			nReq.MessageRequest = reqM
 			for _, ep := range uniqueGrpcServiceEndpoints {
+				if len(respFileDescriptorProto) > 0 {
+					break
+				}
 				resp, err = grp.getEndpointServerReflectionResponse(stream.Context(), nReq, ep)
+				if isReflectionUnavailable(err) {
+					continue
+				}
 				if err != nil {
 					return err
 				}
@@ -88,11 +110,18 @@ func (grp *GrpcReverseProxy) ServerReflectionInfo(stream grpcReflection.ServerRe
 			reqM := &grpcReflection.ServerReflectionRequest_FileContainingSymbol{
 				FileContainingSymbol: req.FileContainingSymbol,
 			}
-			var respFileDescriptorProto [][]byte
+			// descriptors from the static descriptor sets take precedence over the reflected ones.
+			respFileDescriptorProto := grp.descriptorSets.FileContainingSymbol(req.FileContainingSymbol)
 			nReq.Host = in.Host
 			nReq.MessageRequest = reqM
 			for _, ep := range uniqueGrpcServiceEndpoints {
+				if len(respFileDescriptorProto) > 0 {
+					break
+				}
 				resp, err = grp.getEndpointServerReflectionResponse(stream.Context(), nReq, ep)
+				if isReflectionUnavailable(err) {
+					continue
+				}
 				if err != nil {
 					return err
 				}
@@ -107,11 +136,18 @@ func (grp *GrpcReverseProxy) ServerReflectionInfo(stream grpcReflection.ServerRe
 			reqM := &grpcReflection.ServerReflectionRequest_FileContainingExtension{
 				FileContainingExtension: req.FileContainingExtension,
 			}
-			var respFileDescriptorProto [][]byte
+			// descriptors from the static descriptor sets take precedence over the reflected ones.
+			respFileDescriptorProto := grp.descriptorSets.FileContainingExtension(req.FileContainingExtension.GetContainingType(), req.FileContainingExtension.GetExtensionNumber())
 			nReq.Host = in.Host
 			nReq.MessageRequest = reqM
 			for _, ep := range uniqueGrpcServiceEndpoints {
+				if len(respFileDescriptorProto) > 0 {
+					break
+				}
 				resp, err = grp.getEndpointServerReflectionResponse(stream.Context(), nReq, ep)
+				if isReflectionUnavailable(err) {
+					continue
+				}
 				if err != nil {
 					return err
 				}
@@ -126,11 +162,14 @@ func (grp *GrpcReverseProxy) ServerReflectionInfo(stream grpcReflection.ServerRe
 			reqM := &grpcReflection.ServerReflectionRequest_AllExtensionNumbersOfType{
 				AllExtensionNumbersOfType: req.AllExtensionNumbersOfType,
 			}
-			var extNumbers []int32
+			extNumbers := grp.descriptorSets.AllExtensionNumbersOfType(req.AllExtensionNumbersOfType)
 			nReq.Host = in.Host
 			nReq.MessageRequest = reqM
 			for _, ep := range uniqueGrpcServiceEndpoints {
 				resp, err = grp.getEndpointServerReflectionResponse(stream.Context(), nReq, ep)
+				if isReflectionUnavailable(err) {
+					continue
+				}
 				if err != nil {
 					return err
 				}
@@ -138,8 +177,8 @@ func (grp *GrpcReverseProxy) ServerReflectionInfo(stream grpcReflection.ServerRe
 			}
 			out.MessageResponse = &grpcReflection.ServerReflectionResponse_AllExtensionNumbersResponse{
 				AllExtensionNumbersResponse: &grpcReflection.ExtensionNumberResponse{
-					ExtensionNumber: extNumbers,
-					BaseTypeName:    resp.GetAllExtensionNumbersResponse().GetBaseTypeName(),
+					ExtensionNumber: uniqueInt32s(extNumbers),
+					BaseTypeName:    req.AllExtensionNumbersOfType,
 				},
 			}
 		case *grpcReflection.ServerReflectionRequest_ListServices:
@@ -147,14 +186,31 @@ func (grp *GrpcReverseProxy) ServerReflectionInfo(stream grpcReflection.ServerRe
 				ListServices: req.ListServices,
 			}
 			var svcList []*grpcReflection.ServiceResponse
+			listed := map[string]struct{}{}
+			for _, name := range grp.descriptorSets.ListServices() {
+				if !grp.isServiceRouted(name) {
+					continue
+				}
+				listed[name] = struct{}{}
+				svcList = append(svcList, &grpcReflection.ServiceResponse{Name: name})
+			}
 			nReq.Host = in.Host
 			nReq.MessageRequest = reqM
 			for _, ep := range uniqueGrpcServiceEndpoints {
 				resp, err = grp.getEndpointServerReflectionResponse(stream.Context(), nReq, ep)
+				if isReflectionUnavailable(err) {
+					continue
+				}
 				if err != nil {
 					return err
 				}
-				svcList = append(svcList, resp.GetListServicesResponse().Service...)
+				for _, svc := range resp.GetListServicesResponse().GetService() {
+					if _, ok := listed[svc.Name]; ok || !grp.isServiceRouted(svc.Name) {
+						continue
+					}
+					listed[svc.Name] = struct{}{}
+					svcList = append(svcList, svc)
+				}
 			}
 			out.MessageResponse = &grpcReflection.ServerReflectionResponse_ListServicesResponse{
 				ListServicesResponse: &grpcReflection.ListServiceResponse{
@@ -162,8 +218,64 @@ func (grp *GrpcReverseProxy) ServerReflectionInfo(stream grpcReflection.ServerRe
 				},
 			}
 		}
+		if fdr := out.GetFileDescriptorResponse(); fdr != nil {
+			var symbol string
+			if req, ok := in.MessageRequest.(*grpcReflection.ServerReflectionRequest_FileContainingSymbol); ok {
+				symbol = req.FileContainingSymbol
+			}
+			files, unrouted := grp.stripUnroutedServices(fdr.FileDescriptorProto, symbol)
+			if unrouted {
+				out.MessageResponse = &grpcReflection.ServerReflectionResponse_ErrorResponse{
+					ErrorResponse: &grpcReflection.ErrorResponse{
+						ErrorCode:    int32(codes.NotFound),
+						ErrorMessage: "symbol not found: " + symbol,
+					},
+				}
+			} else {
+				fdr.FileDescriptorProto = files
+			}
+		}
 		if err := stream.Send(out); err != nil {
 			return err
 		}
 	}
 }
+
+// stripUnroutedServices removes the services which are not routed from the serialized file descriptors, so they are
+// hidden from the file answers as from ListServices. It reports whether the symbol is one of the removed services or
+// their methods.
+func (grp *GrpcReverseProxy) stripUnroutedServices(files [][]byte, symbol string) ([][]byte, bool) {
+	if len(grp.opts.Routes) == 0 {
+		return files, false
+	}
+	stripped := make([][]byte, 0, len(files))
+	for _, b := range files {
+		fdp := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(b, fdp); err != nil {
+			continue
+		}
+		services := fdp.Service[:0]
+		for _, sd := range fdp.Service {
+			name := sd.GetName()
+			if fdp.GetPackage() != "" {
+				name = fdp.GetPackage() + "." + name
+			}
+			if grp.isServiceRouted(name) {
+				services = append(services, sd)
+				continue
+			}
+			if symbol == name || strings.HasPrefix(symbol, name+".") {
+				return nil, true
+			}
+		}
+		if len(services) == len(fdp.Service) {
+			stripped = append(stripped, b)
+			continue
+		}
+		fdp.Service = services
+		if b, err := proto.Marshal(fdp); err == nil {
+			stripped = append(stripped, b)
+		}
+	}
+	return stripped, false
+}
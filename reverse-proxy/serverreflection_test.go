@@ -0,0 +1,84 @@
+package reverse_proxy
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcReflection "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"net"
+	"testing"
+)
+
+func TestServerReflectionHidesUnroutedServices(t *testing.T) {
+	rp := startTestBackend(t, WithRoutes("/grpc.health.v1.Health/"))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	grpcReflection.RegisterServerReflectionServer(srv, rp)
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	stream, err := grpcReflection.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ask := func(req *grpcReflection.ServerReflectionRequest) *grpcReflection.ServerReflectionResponse {
+		if err := stream.Send(req); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	services := func(resp *grpcReflection.ServerReflectionResponse) []string {
+		var names []string
+		for _, b := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			fdp := &descriptorpb.FileDescriptorProto{}
+			if err := proto.Unmarshal(b, fdp); err != nil {
+				t.Fatal(err)
+			}
+			for _, sd := range fdp.Service {
+				names = append(names, fdp.GetPackage()+"."+sd.GetName())
+			}
+		}
+		return names
+	}
+
+	for _, symbol := range []string{"grpc.reflection.v1alpha.ServerReflection", "grpc.reflection.v1alpha.ServerReflection.ServerReflectionInfo"} {
+		resp := ask(&grpcReflection.ServerReflectionRequest{
+			MessageRequest: &grpcReflection.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: symbol},
+		})
+		if resp.GetErrorResponse().GetErrorCode() != int32(codes.NotFound) {
+			t.Errorf("expected NOT_FOUND for the unrouted symbol %v, but got %v", symbol, resp)
+		}
+	}
+	resp := ask(&grpcReflection.ServerReflectionRequest{
+		MessageRequest: &grpcReflection.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: "grpc.reflection.v1alpha.ServerReflectionRequest"},
+	})
+	if names := services(resp); len(resp.GetFileDescriptorResponse().GetFileDescriptorProto()) == 0 || len(names) != 0 {
+		t.Errorf("expected the file of the message without the unrouted service, but got %v", resp)
+	}
+	resp = ask(&grpcReflection.ServerReflectionRequest{
+		MessageRequest: &grpcReflection.ServerReflectionRequest_FileByFilename{FileByFilename: grpcReflection.File_grpc_reflection_v1alpha_reflection_proto.Path()},
+	})
+	if names := services(resp); len(resp.GetFileDescriptorResponse().GetFileDescriptorProto()) == 0 || len(names) != 0 {
+		t.Errorf("expected the file without the unrouted service, but got %v", resp)
+	}
+	resp = ask(&grpcReflection.ServerReflectionRequest{
+		MessageRequest: &grpcReflection.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: "grpc.health.v1.Health"},
+	})
+	if names := services(resp); len(names) != 1 || names[0] != "grpc.health.v1.Health" {
+		t.Errorf("expected the routed service, but got %v", names)
+	}
+}
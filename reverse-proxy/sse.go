@@ -0,0 +1,174 @@
+package reverse_proxy
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SSEResumeHook is called when an EventSource reconnects with the Last-Event-ID header, i.e. the id of the last event
+// received. The hook may modify the request message and the outgoing metadata to resume the stream from the event, and
+// returns the id of the first event to be sent. By default, the last event id is forwarded as the "last-event-id"
+// metadata and the events are numbered following it.
+type SSEResumeHook func(ctx context.Context, fullMethodName string, lastEventId string, req proto.Message) (context.Context, uint64, error)
+
+// SSEHandler is the HTTP handler which bridges the server-streaming methods to the Server-Sent Events, for the clients
+// where only EventSource works:
+//
+//	GET {prefix}{full.Service}/{Method}?{field}={value}...
+//	GET {prefix}{full.Service}/{Method}?json={request in JSON}
+//	POST {prefix}{full.Service}/{Method} with the request in JSON as the body
+//
+// Each response message is sent as a "message" event with the JSON encoded message as the data, and the end of the
+// stream as a "status" event with the JSON encoded google.rpc.Status, which is "grpc-error" instead if the call fails,
+// not to be taken for the "error" event the EventSource fires on the connection failures.
+type SSEHandler struct {
+	grp        *GrpcReverseProxy
+	prefix     string
+	resumeHook SSEResumeHook
+}
+
+// SSEHandler returns the handler for the Server-Sent Events requests under the path prefix, e.g. "/v1/sse/".
+func (grp *GrpcReverseProxy) SSEHandler(prefix string, resumeHook SSEResumeHook) *SSEHandler {
+	if resumeHook == nil {
+		resumeHook = DefaultSSEResumeHook
+	}
+	return &SSEHandler{grp: grp, prefix: prefix, resumeHook: resumeHook}
+}
+
+// DefaultSSEResumeHook forwards the last event id as the "last-event-id" metadata, and numbers the events following it.
+func DefaultSSEResumeHook(ctx context.Context, _ string, lastEventId string, _ proto.Message) (context.Context, uint64, error) {
+	ctx = metadata.AppendToOutgoingContext(ctx, "last-event-id", lastEventId)
+	id, err := strconv.ParseUint(lastEventId, 10, 64)
+	if err != nil {
+		return ctx, 0, nil
+	}
+	return ctx, id + 1, nil
+}
+
+func (h *SSEHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeHTTPError(w, status.Error(codes.Internal, "streaming is not supported by the connection"), nil)
+		return
+	}
+	fullMethodName := "/" + strings.TrimPrefix(r.URL.Path, h.prefix)
+	catalog, err := h.grp.ServiceCatalog(r.Context())
+	if err != nil {
+		writeHTTPError(w, status.Errorf(codes.Unavailable, "failed resolving services: %v", err), nil)
+		return
+	}
+	md, ok := catalog.FindMethod(fullMethodName)
+	if !ok {
+		writeHTTPError(w, status.Errorf(codes.Unimplemented, "unknown method %v", fullMethodName), nil)
+		return
+	}
+	resolver := catalog.Resolver()
+	if !md.IsStreamingServer() || md.IsStreamingClient() {
+		writeHTTPError(w, status.Errorf(codes.Unimplemented, "method %v is not server-streaming", fullMethodName), resolver)
+		return
+	}
+	route := &httpRoute{method: md, body: "*"}
+	if r.Method == http.MethodGet {
+		route.body = ""
+	}
+	req := dynamicpb.NewMessage(md.Input())
+	if q := r.URL.Query(); q.Get("json") != "" {
+		err = jsonUnmarshalOptions(resolver).Unmarshal([]byte(q.Get("json")), req)
+	} else {
+		var built proto.Message
+		h.grp.limitRequestBody(w, r)
+		if built, err = route.buildRequest(r, nil, resolver); err == nil {
+			req = built.(*dynamicpb.Message)
+		}
+	}
+	if err != nil {
+		writeHTTPError(w, requestBodyError(err), resolver)
+		return
+	}
+
+	fwd := r.Clone(r.Context())
+	fwd.Header.Del("Last-Event-ID")
+	fwd.Header.Del("Accept")
+	fwd.Header.Del("Cache-Control")
+	ctx := IncomingContextFromHTTPRequest(fwd)
+	var nextId uint64
+	if lastEventId := r.Header.Get("Last-Event-ID"); lastEventId != "" {
+		// the hook modifies the outgoing metadata, which is merged into the incoming one to be forwarded by the director.
+		var outCtx context.Context
+		outCtx, nextId, err = h.resumeHook(metadata.NewOutgoingContext(ctx, nil), fullMethodName, lastEventId, req)
+		if err != nil {
+			writeHTTPError(w, err, resolver)
+			return
+		}
+		inMd, _ := metadata.FromIncomingContext(ctx)
+		outMd, _ := metadata.FromOutgoingContext(outCtx)
+		ctx = metadata.NewIncomingContext(outCtx, metadata.Join(inMd, outMd))
+	}
+	stream, err := h.grp.NewMethodStream(ctx, md)
+	if err == nil {
+		err = stream.SendMsg(req)
+	}
+	if err == nil {
+		err = stream.CloseSend()
+	}
+	if err != nil {
+		writeHTTPError(w, err, resolver)
+		return
+	}
+	if header, err := stream.Header(); err == nil {
+		writeResponseMetadata(w.Header(), header, "Grpc-Metadata-")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for id := nextId; ; id++ {
+		resp := dynamicpb.NewMessage(md.Output())
+		if err = stream.RecvMsg(resp); err != nil {
+			break
+		}
+		var b []byte
+		if b, err = jsonMarshalOptions(resolver).Marshal(resp); err != nil {
+			err = status.Errorf(codes.Internal, "failed marshaling response: %v", err)
+			break
+		}
+		if _, err = fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", id, b); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+	event := "grpc-error"
+	if err == io.EOF {
+		event, err = "status", nil
+	}
+	_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, marshalSSEStatusJSON(status.Convert(err), resolver))
+	flusher.Flush()
+}
+
+// marshalSSEStatusJSON marshals the final status of the stream with the unpopulated fields, so that the OK one carries
+// the code 0 too. The details are left out if any of them can not be resolved.
+func marshalSSEStatusJSON(st *status.Status, resolver *CatalogTypeResolver) []byte {
+	opts := jsonMarshalOptions(resolver)
+	sp := st.Proto()
+	b, err := opts.Marshal(sp)
+	if err != nil {
+		sp.Details = nil
+		b, _ = opts.Marshal(sp)
+	}
+	return b
+}
@@ -0,0 +1,76 @@
+package reverse_proxy
+
+import (
+	"bufio"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// readSSEEvent reads the lines of the next event.
+func readSSEEvent(t *testing.T, r *bufio.Reader) []string {
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed reading event %v: %v", lines, err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestSSEHandler(t *testing.T) {
+	rp := startTestBackend(t)
+	srv := httptest.NewServer(rp.SSEHandler("/v1/sse/", nil))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/v1/sse/grpc.health.v1.Health/Watch?service=books", nil)
+	req.Header.Set("Last-Event-ID", "5")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(resp *http.Response) { _ = resp.Body.Close() }(resp)
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %v", ct)
+	}
+	event := readSSEEvent(t, bufio.NewReader(resp.Body))
+	if len(event) != 3 || event[0] != "id: 6" || event[1] != "event: message" || !strings.Contains(event[2], `"NOT_SERVING"`) {
+		t.Errorf("unexpected event %v", event)
+	}
+
+	resp, err = http.Get(srv.URL + "/v1/sse/grpc.health.v1.Health/Watch?json=" + `{"service":1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(resp *http.Response) { _ = resp.Body.Close() }(resp)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status %v, but got %v", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	resp, err = http.Get(srv.URL + "/v1/sse/grpc.health.v1.Health/Check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(resp *http.Response) { _ = resp.Body.Close() }(resp)
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("expected status %v for the unary method, but got %v", http.StatusNotImplemented, resp.StatusCode)
+	}
+}
+
+func TestMarshalSSEStatusJSON(t *testing.T) {
+	if b := string(marshalSSEStatusJSON(status.New(codes.OK, ""), nil)); !strings.Contains(b, `"code":0`) {
+		t.Errorf("expected the OK code in the final status, but got %v", b)
+	}
+	// the error bodies of the other HTTP APIs are left without the unpopulated fields.
+	if b := string(marshalStatusJSON(status.New(codes.NotFound, "not found"), nil)); strings.Contains(b, `"details"`) {
+		t.Errorf("expected no details in the error body, but got %v", b)
+	}
+}
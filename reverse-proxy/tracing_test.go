@@ -0,0 +1,78 @@
+package reverse_proxy
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+	"grpc-gateway-x/tracing"
+	"testing"
+)
+
+func findSpan(spans tracetest.SpanStubs, name string) (tracetest.SpanStub, bool) {
+	for _, s := range spans {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return tracetest.SpanStub{}, false
+}
+
+func TestStreamDirectorTracing(t *testing.T) {
+	exporter, restore := tracing.SetupInMemory()
+	defer restore()
+	rp := startTestBackend(t)
+
+	clientTraceparent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("traceparent", clientTraceparent))
+	ctx = propagation.TraceContext{}.Extract(ctx, tracing.MetadataCarrier(metadata.Pairs("traceparent", clientTraceparent)))
+	ctx, span := tracing.Tracer().Start(ctx, "grpc.health.v1.Health/Check")
+	outCtx, _, err := rp.streamDirector(ctx, "/grpc.health.v1.Health/Check")
+	span.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md, _ := metadata.FromOutgoingContext(outCtx)
+	if got := md.Get("traceparent"); len(got) != 1 || got[0] == clientTraceparent {
+		t.Fatalf("expected the traceparent of the gateway span, but got %v", got)
+	}
+	backendCtx := propagation.TraceContext{}.Extract(context.Background(), tracing.MetadataCarrier(md))
+	sc := trace.SpanContextFromContext(backendCtx)
+	if sc.TraceID() != span.SpanContext().TraceID() || sc.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("expected the backend to continue span %v, but got %v", span.SpanContext().SpanID(), sc.SpanID())
+	}
+	if sc.TraceID().String() != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("expected the trace of the client, but got %v", sc.TraceID())
+	}
+
+	dial, ok := findSpan(exporter.GetSpans(), "backend.dial")
+	if !ok {
+		t.Fatalf("expected backend.dial span, but got %v", exporter.GetSpans())
+	}
+	if dial.Parent.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("expected backend.dial to be a child of the call span, but got parent %v", dial.Parent.SpanID())
+	}
+}
+
+func TestServiceCatalogTracing(t *testing.T) {
+	exporter, restore := tracing.SetupInMemory()
+	defer restore()
+	rp := startTestBackend(t)
+	if _, err := rp.ServiceCatalog(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	spans := exporter.GetSpans()
+	fanOut, ok := findSpan(spans, "reflection.fan_out")
+	if !ok {
+		t.Fatalf("expected reflection.fan_out span, but got %v", spans)
+	}
+	endpoint, ok := findSpan(spans, "reflection.endpoint")
+	if !ok {
+		t.Fatalf("expected reflection.endpoint span, but got %v", spans)
+	}
+	if endpoint.Parent.SpanID() != fanOut.SpanContext.SpanID() {
+		t.Errorf("expected reflection.endpoint to be a child of reflection.fan_out, but got parent %v", endpoint.Parent.SpanID())
+	}
+}
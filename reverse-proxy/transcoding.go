@@ -0,0 +1,414 @@
+package reverse_proxy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// httpRoute is an HTTP binding of a method by its google.api.http rule.
+type httpRoute struct {
+	method       protoreflect.MethodDescriptor
+	httpMethod   string
+	template     *httpPathTemplate
+	body         string
+	responseBody string
+}
+
+// HttpTranscoder is the HTTP handler which maps the REST/JSON requests to the grpc calls per the google.api.http
+// annotations of the methods, and forwards the calls through the director of the proxy.
+//
+// The unary methods are responded with the JSON encoded response message. The server-streaming methods are responded
+// with newline-delimited JSON, with each line being either {"result": <message>} or {"error": <status>}.
+type HttpTranscoder struct {
+	grp *GrpcReverseProxy
+	sync.Mutex
+	// catalog the catalog the routes are built from.
+	catalog *ServiceCatalog
+	routes  []*httpRoute
+}
+
+func (grp *GrpcReverseProxy) HttpTranscoder() *HttpTranscoder {
+	return &HttpTranscoder{grp: grp}
+}
+
+func (t *HttpTranscoder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	catalog, err := t.grp.ServiceCatalog(r.Context())
+	if err != nil {
+		writeHTTPError(w, status.Errorf(codes.Unavailable, "failed resolving services: %v", err), nil)
+		return
+	}
+	route, vars := t.match(catalog, r)
+	if route == nil {
+		writeHTTPError(w, status.Errorf(codes.NotFound, "no method bound to %v %v", r.Method, r.URL.Path), nil)
+		return
+	}
+	resolver := catalog.Resolver()
+	if route.method.IsStreamingClient() {
+		writeHTTPError(w, status.Errorf(codes.Unimplemented, "client-streaming method %v can not be transcoded", route.method.FullName()), resolver)
+		return
+	}
+	t.grp.limitRequestBody(w, r)
+	req, err := route.buildRequest(r, vars, resolver)
+	if err != nil {
+		writeHTTPError(w, requestBodyError(err), resolver)
+		return
+	}
+	stream, err := t.grp.NewMethodStream(IncomingContextFromHTTPRequest(r), route.method)
+	if err == nil {
+		err = stream.SendMsg(req)
+	}
+	if err == nil {
+		err = stream.CloseSend()
+	}
+	if err != nil {
+		writeHTTPError(w, err, resolver)
+		return
+	}
+	if route.method.IsStreamingServer() {
+		route.writeStream(w, stream, resolver)
+		return
+	}
+	route.writeUnary(w, stream, resolver)
+}
+
+// match finds the route and the path variables of the request, with the routes rebuilt if the catalog has changed.
+func (t *HttpTranscoder) match(catalog *ServiceCatalog, r *http.Request) (*httpRoute, map[string]string) {
+	t.Lock()
+	if t.catalog != catalog {
+		t.routes = buildHttpRoutes(catalog)
+		t.catalog = catalog
+	}
+	routes := t.routes
+	t.Unlock()
+	path := r.URL.EscapedPath()
+	for _, route := range routes {
+		if route.httpMethod != r.Method {
+			continue
+		}
+		if vars, ok := route.template.match(path); ok {
+			return route, vars
+		}
+	}
+	return nil, nil
+}
+
+// buildHttpRoutes builds the routes of all the annotated methods in the catalog, the more specific ones first.
+func buildHttpRoutes(catalog *ServiceCatalog) []*httpRoute {
+	var routes []*httpRoute
+	for _, sd := range catalog.Services() {
+		for i := 0; i < sd.Methods().Len(); i++ {
+			routes = append(routes, httpRoutesOf(sd.Methods().Get(i))...)
+		}
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routes[i].template.literals() > routes[j].template.literals()
+	})
+	return routes
+}
+
+// httpRuleOf returns the google.api.http rule of the method, nil if the method is not annotated.
+func httpRuleOf(md protoreflect.MethodDescriptor) *annotations.HttpRule {
+	opts := md.Options()
+	if opts == nil || !proto.HasExtension(opts, annotations.E_Http) {
+		return nil
+	}
+	rule, _ := proto.GetExtension(opts, annotations.E_Http).(*annotations.HttpRule)
+	return rule
+}
+
+func httpRoutesOf(md protoreflect.MethodDescriptor) []*httpRoute {
+	rule := httpRuleOf(md)
+	if rule == nil {
+		return nil
+	}
+	var routes []*httpRoute
+	for _, r := range append([]*annotations.HttpRule{rule}, rule.AdditionalBindings...) {
+		var httpMethod, pattern string
+		switch p := r.Pattern.(type) {
+		case *annotations.HttpRule_Get:
+			httpMethod, pattern = http.MethodGet, p.Get
+		case *annotations.HttpRule_Put:
+			httpMethod, pattern = http.MethodPut, p.Put
+		case *annotations.HttpRule_Post:
+			httpMethod, pattern = http.MethodPost, p.Post
+		case *annotations.HttpRule_Delete:
+			httpMethod, pattern = http.MethodDelete, p.Delete
+		case *annotations.HttpRule_Patch:
+			httpMethod, pattern = http.MethodPatch, p.Patch
+		case *annotations.HttpRule_Custom:
+			httpMethod, pattern = p.Custom.GetKind(), p.Custom.GetPath()
+		default:
+			continue
+		}
+		tpl, err := parsePathTemplate(pattern)
+		if err != nil {
+			continue
+		}
+		routes = append(routes, &httpRoute{
+			method:       md,
+			httpMethod:   httpMethod,
+			template:     tpl,
+			body:         r.Body,
+			responseBody: r.ResponseBody,
+		})
+	}
+	return routes
+}
+
+// buildRequest builds the request message from the body, the query parameters and the path variables in order,
+// the latter ones overriding the former ones.
+func (route *httpRoute) buildRequest(r *http.Request, vars map[string]string, resolver *CatalogTypeResolver) (proto.Message, error) {
+	req := dynamicpb.NewMessage(route.method.Input())
+	if route.body != "" {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > 0 {
+			if route.body != "*" {
+				fd := findField(req.Descriptor(), route.body)
+				if fd == nil {
+					return nil, fmt.Errorf("body field %v not found", route.body)
+				}
+				// the body is wrapped as the value of the field, so it must be exactly one JSON value not to set the
+				// sibling fields.
+				if !json.Valid(body) {
+					return nil, errors.New("invalid JSON body")
+				}
+				body = []byte(fmt.Sprintf("{%q:%s}", fd.JSONName(), body))
+			}
+			if err = jsonUnmarshalOptions(resolver).Unmarshal(body, req); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if route.body != "*" {
+		for k, vs := range r.URL.Query() {
+			if _, ok := vars[k]; ok || k == route.body {
+				continue
+			}
+			if err := setFieldPathValue(req, k, vs, true); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for k, v := range vars {
+		if err := setFieldPathValue(req, k, []string{v}, false); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+func (route *httpRoute) marshalResponse(resp proto.Message, resolver *CatalogTypeResolver) ([]byte, error) {
+	b, err := jsonMarshalOptions(resolver).Marshal(resp)
+	if err != nil || route.responseBody == "" {
+		return b, err
+	}
+	fd := findField(route.method.Output(), route.responseBody)
+	if fd == nil {
+		return nil, fmt.Errorf("response body field %v not found", route.responseBody)
+	}
+	fields := map[string]json.RawMessage{}
+	if err = json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	return fields[fd.JSONName()], nil
+}
+
+func (route *httpRoute) writeUnary(w http.ResponseWriter, stream grpc.ClientStream, resolver *CatalogTypeResolver) {
+	resp := dynamicpb.NewMessage(route.method.Output())
+	if err := stream.RecvMsg(resp); err != nil {
+		writeHTTPError(w, err, resolver)
+		return
+	}
+	b, err := route.marshalResponse(resp, resolver)
+	if err != nil {
+		writeHTTPError(w, status.Errorf(codes.Internal, "failed marshaling response: %v", err), resolver)
+		return
+	}
+	if md, err := stream.Header(); err == nil {
+		writeResponseMetadata(w.Header(), md, "Grpc-Metadata-")
+	}
+	writeResponseMetadata(w.Header(), stream.Trailer(), "Grpc-Trailer-")
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+func (route *httpRoute) writeStream(w http.ResponseWriter, stream grpc.ClientStream, resolver *CatalogTypeResolver) {
+	flusher, _ := w.(http.Flusher)
+	headerWritten := false
+	writeHeader := func() {
+		if headerWritten {
+			return
+		}
+		headerWritten = true
+		if md, err := stream.Header(); err == nil {
+			writeResponseMetadata(w.Header(), md, "Grpc-Metadata-")
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+	}
+	for {
+		resp := dynamicpb.NewMessage(route.method.Output())
+		err := stream.RecvMsg(resp)
+		if err == io.EOF {
+			writeHeader()
+			return
+		}
+		if err != nil {
+			if !headerWritten {
+				writeHTTPError(w, err, resolver)
+				return
+			}
+			_, _ = fmt.Fprintf(w, "{\"error\":%s}\n", marshalStatusJSON(status.Convert(err), resolver))
+			return
+		}
+		b, err := route.marshalResponse(resp, resolver)
+		if err != nil {
+			b = marshalStatusJSON(status.Newf(codes.Internal, "failed marshaling response: %v", err), resolver)
+			_, _ = fmt.Fprintf(w, "{\"error\":%s}\n", b)
+			return
+		}
+		writeHeader()
+		_, _ = fmt.Fprintf(w, "{\"result\":%s}\n", b)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// findField finds the field by the dot separated path of field names, or JSON names.
+func findField(md protoreflect.MessageDescriptor, fieldPath string) protoreflect.FieldDescriptor {
+	var fd protoreflect.FieldDescriptor
+	for i, name := range strings.Split(fieldPath, ".") {
+		if i > 0 {
+			if fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+				return nil
+			}
+			md = fd.Message()
+		}
+		if fd = md.Fields().ByName(protoreflect.Name(name)); fd == nil {
+			if fd = md.Fields().ByJSONName(name); fd == nil {
+				return nil
+			}
+		}
+	}
+	return fd
+}
+
+// setFieldPathValue sets the values parsed from the strings to the field by the dot separated path.
+// Unknown fields are ignored if ignoreUnknown is set, e.g. for the query parameters.
+func setFieldPathValue(msg protoreflect.Message, fieldPath string, values []string, ignoreUnknown bool) error {
+	names := strings.Split(fieldPath, ".")
+	for i, name := range names {
+		fields := msg.Descriptor().Fields()
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = fields.ByJSONName(name)
+		}
+		if fd == nil {
+			if ignoreUnknown {
+				return nil
+			}
+			return fmt.Errorf("field %v not found in %v", fieldPath, msg.Descriptor().FullName())
+		}
+		if i < len(names)-1 {
+			if fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+				return fmt.Errorf("field %v is not a message", fieldPath)
+			}
+			msg = msg.Mutable(fd).Message()
+			continue
+		}
+		if fd.IsMap() {
+			return fmt.Errorf("map field %v can not be set by the parameters", fieldPath)
+		}
+		if fd.IsList() {
+			list := msg.Mutable(fd).List()
+			for _, s := range values {
+				v, err := parseFieldValue(fd, s, func() protoreflect.Message { return list.NewElement().Message() })
+				if err != nil {
+					return fmt.Errorf("invalid value of %v: %v", fieldPath, err)
+				}
+				list.Append(v)
+			}
+			return nil
+		}
+		if len(values) == 0 {
+			return nil
+		}
+		v, err := parseFieldValue(fd, values[len(values)-1], func() protoreflect.Message { return msg.NewField(fd).Message() })
+		if err != nil {
+			return fmt.Errorf("invalid value of %v: %v", fieldPath, err)
+		}
+		msg.Set(fd, v)
+	}
+	return nil
+}
+
+// parseFieldValue parses the value of the field kind from the string, with newMessage making the value of the message fields.
+func parseFieldValue(fd protoreflect.FieldDescriptor, s string, newMessage func() protoreflect.Message) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BytesKind:
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			b, err = base64.URLEncoding.DecodeString(s)
+		}
+		return protoreflect.ValueOfBytes(b), err
+	case protoreflect.BoolKind:
+		v, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(v)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(v)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		v, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		v, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		v, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(v)), err
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		// the well-known types, e.g. google.protobuf.Timestamp or the wrappers, are parsed per their JSON form.
+		m := newMessage()
+		if err := protojson.Unmarshal([]byte(s), m.Interface()); err != nil {
+			if err = protojson.Unmarshal([]byte(strconv.Quote(s)), m.Interface()); err != nil {
+				return protoreflect.Value{}, err
+			}
+		}
+		return protoreflect.ValueOfMessage(m), nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported field kind %v", fd.Kind())
+}
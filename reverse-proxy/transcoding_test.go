@@ -0,0 +1,227 @@
+package reverse_proxy
+
+import (
+	"bufio"
+	"context"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParsePathTemplate(t *testing.T) {
+	cases := []struct {
+		template string
+		path     string
+		vars     map[string]string
+	}{
+		{"/v1/shelves", "/v1/shelves", map[string]string{}},
+		{"/v1/shelves/{shelf}", "/v1/shelves/a%2Fb", map[string]string{"shelf": "a/b"}},
+		{"/v1/{name=shelves/*/books/*}", "/v1/shelves/1/books/2", map[string]string{"name": "shelves/1/books/2"}},
+		{"/v1/{name=files/**}", "/v1/files/a/b/c", map[string]string{"name": "files/a/b/c"}},
+		{"/v1/{name=**}/meta", "/v1/a/b/meta", map[string]string{"name": "a/b"}},
+		{"/v1/books/{id}:publish", "/v1/books/3:publish", map[string]string{"id": "3"}},
+		{"/v1/books/{id}:publish", "/v1/books/3", nil},
+		{"/v1/shelves/{shelf}", "/v1/shelves/1/books", nil},
+		{"/v1/shelves/{shelf}", "/v1/shelves/", nil},
+	}
+	for _, c := range cases {
+		tpl, err := parsePathTemplate(c.template)
+		if err != nil {
+			t.Errorf("failed parsing %v: %v", c.template, err)
+			continue
+		}
+		vars, ok := tpl.match(c.path)
+		if ok != (c.vars != nil) {
+			t.Errorf("%v matching %v: expected %v, but got %v", c.template, c.path, c.vars != nil, ok)
+			continue
+		}
+		for k, v := range c.vars {
+			if vars[k] != v {
+				t.Errorf("%v matching %v: expected %v=%v, but got %v", c.template, c.path, k, v, vars[k])
+			}
+		}
+	}
+	for _, invalid := range []string{"v1/shelves", "/v1/{shelf", "/v1/**/**", "/v1//shelves"} {
+		if _, err := parsePathTemplate(invalid); err == nil {
+			t.Errorf("expected error on parsing %v", invalid)
+		}
+	}
+}
+
+// testBookServiceFile builds the descriptor of a service with the google.api.http annotated method:
+//
+//	rpc UpdateBook(UpdateBookRequest) returns (Book) { option (google.api.http) = { patch: "/v1/shelves/{shelf}/books/{book.id}" body: "book" }; }
+func testBookServiceFile(t *testing.T) protoreflect.FileDescriptor {
+	methodOpts := &descriptorpb.MethodOptions{}
+	proto.SetExtension(methodOpts, annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Patch{Patch: "/v1/shelves/{shelf}/books/{book.id}"},
+		Body:    "book",
+	})
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     typ.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("test/book.proto"),
+		Package:    proto.String("test.v1"),
+		Dependency: []string{"google/api/annotations.proto"},
+		Syntax:     proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Book"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+					field("title", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				},
+			},
+			{
+				Name: proto.String("UpdateBookRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("shelf", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					field("book", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.v1.Book"),
+					field("notify", 3, descriptorpb.FieldDescriptorProto_TYPE_BOOL, ""),
+				},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("BookService"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("UpdateBook"),
+				InputType:  proto.String(".test.v1.UpdateBookRequest"),
+				OutputType: proto.String(".test.v1.Book"),
+				Options:    methodOpts,
+			}},
+		}},
+	}
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fd
+}
+
+func TestHttpRouteBuildRequest(t *testing.T) {
+	md := testBookServiceFile(t).Services().Get(0).Methods().Get(0)
+	routes := httpRoutesOf(md)
+	if len(routes) != 1 {
+		t.Fatalf("expected 1 route, but got %v", len(routes))
+	}
+	r := httptest.NewRequest("PATCH", "/v1/shelves/s1/books/42?notify=true&unknown=1", strings.NewReader(`{"id": 1, "title": "Go"}`))
+	vars, ok := routes[0].template.match(r.URL.EscapedPath())
+	if !ok {
+		t.Fatal("expected the route to match")
+	}
+	req, err := routes[0].buildRequest(r, vars, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := jsonMarshalOptions(nil).Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := dynamicpb.NewMessage(md.Input())
+	if err = jsonUnmarshalOptions(nil).Unmarshal(b, got); err != nil {
+		t.Fatal(err)
+	}
+	fields := got.Descriptor().Fields()
+	book := got.Get(fields.ByName("book")).Message()
+	if got.Get(fields.ByName("shelf")).String() != "s1" || !got.Get(fields.ByName("notify")).Bool() ||
+		book.Get(book.Descriptor().Fields().ByName("id")).Int() != 42 ||
+		book.Get(book.Descriptor().Fields().ByName("title")).String() != "Go" {
+		t.Errorf("unexpected request: %s", b)
+	}
+
+	// the body is the value of the body field only, not setting the sibling fields.
+	for _, body := range []string{`{"title": "Go"}, "shelf": "s2"`, `{"title": "Go"`, `{"title": "Go"} {}`} {
+		r = httptest.NewRequest("PATCH", "/v1/shelves/s1/books/42", strings.NewReader(body))
+		if req, err = routes[0].buildRequest(r, vars, nil); err == nil {
+			t.Errorf("expected error of the body %v, but got %v", body, req)
+		}
+	}
+}
+
+// writeHttpHealthDescriptorSet writes the descriptor set of the health service with the methods annotated by the
+// google.api.http rules, returning the path of it.
+func writeHttpHealthDescriptorSet(t *testing.T) string {
+	fdp := protodesc.ToFileDescriptorProto(healthpb.File_grpc_health_v1_health_proto)
+	fdp.Name = proto.String("test/health_http.proto")
+	fdp.Dependency = append(fdp.Dependency, "google/api/annotations.proto")
+	rules := map[string]*annotations.HttpRule{
+		"Check": {Pattern: &annotations.HttpRule_Post{Post: "/v1/health/check"}, Body: "*"},
+		"Watch": {Pattern: &annotations.HttpRule_Get{Get: "/v1/health/{service}/watch"}},
+	}
+	for _, m := range fdp.Service[0].Method {
+		m.Options = &descriptorpb.MethodOptions{}
+		proto.SetExtension(m.Options, annotations.E_Http, rules[m.GetName()])
+	}
+	fds := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(descriptorpb.File_google_protobuf_descriptor_proto),
+		protodesc.ToFileDescriptorProto(annotations.File_google_api_http_proto),
+		protodesc.ToFileDescriptorProto(annotations.File_google_api_annotations_proto),
+		fdp,
+	}}
+	b, err := proto.Marshal(fds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "health_http.protoset")
+	if err = os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHttpTranscoderServeHTTP(t *testing.T) {
+	rp := startTestBackend(t, WithDescriptorSetFiles(writeHttpHealthDescriptorSet(t)), WithMaxMessageSize(64))
+	srv := httptest.NewServer(rp.HttpTranscoder())
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/v1/health/books/watch", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(resp *http.Response) { _ = resp.Body.Close() }(resp)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("unexpected response %v %v", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	// the stream is kept open by the backend, so the first message must be flushed before it ends.
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != `{"result":{"status":"NOT_SERVING"}}`+"\n" {
+		t.Errorf("unexpected streamed message %q", line)
+	}
+
+	resp, err = http.Post(srv.URL+"/v1/health/check", "application/json", strings.NewReader(`{"service":"`+strings.Repeat("x", 64)+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(resp *http.Response) { _ = resp.Body.Close() }(resp)
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected status %v for the body exceeding the max message size, but got %v", http.StatusTooManyRequests, resp.StatusCode)
+	}
+}
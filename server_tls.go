@@ -1,52 +1,285 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
-	"os"
-
 	"crypto/x509"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"grpc-gateway-x/certs"
+	"net"
+	"net/http"
+	"strings"
+
 	"github.com/mwitkow/go-conntrack/connhelpers"
 	logrus "github.com/sirupsen/logrus"
 )
 
-func buildServerTlsOrFail(cfg *Config) *tls.Config {
-	if cfg.TlsCertFile == "" || cfg.TlsKeyFile == "" {
-		logrus.Fatalf("TlsCertFile and TlsKeyFile must be set")
+const (
+	// clientAuthNone accepts the connections without client certificates.
+	clientAuthNone = "none"
+	// clientAuthRequest verifies the client certificates if any is sent.
+	clientAuthRequest = "request"
+	// clientAuthRequire requires the verified client certificates.
+	clientAuthRequire = "require"
+)
+
+// TlsCertificateConfig a certificate of the TLS listeners selected by the SNI of the clients.
+type TlsCertificateConfig struct {
+	// ServerNames the host names the certificate is selected for, e.g. "api.example.com", or "*.example.com" matching
+	// any single label subdomain.
+	ServerNames []string
+	CertFile    string
+	KeyFile     string
+	// ClientAuth "none", "request" or "require" for the host names, default is the one of TlsVerifyCert. the requests whose
+	// authority selects another certificate than the SNI of their connections are rejected, so as not to bypass it.
+	ClientAuth string
+	// CaFile the CA verifying the client certificates for the host names, default is TlsCaFile.
+	CaFile string
+}
+
+// sniCertificates the certificates of a TLS listener, the first one is the default for the clients sending no or unknown SNI.
+type sniCertificates []*sniCertificate
+
+// sniCertificate a certificate with the client authentication of its host names.
+type sniCertificate struct {
+	serverNames []string
+	store       *certs.Store
+	clientAuth  tls.ClientAuthType
+	// clientCAs the CA bundle verifying the client certificates, nil for the one of the store.
+	clientCAs *x509.CertPool
+}
+
+// currentClientCAs returns the CA bundle currently verifying the client certificates.
+func (c *sniCertificate) currentClientCAs() *x509.CertPool {
+	if cas := c.store.CAs(); cas != nil {
+		return cas
 	}
-	tlsConfig, err := connhelpers.TlsConfigForServerCerts(cfg.TlsCertFile, cfg.TlsKeyFile)
+	return c.clientCAs
+}
+
+// buildServerTlsOrFail builds the TLS config of the listener. The certificate and the client authentication are selected
+// by the SNI on each handshake, out of the TlsCertificates, or the default TlsCertFile, whose files are taken from the
+// returned stores, so they can be reloaded without restarting the listener.
+func buildServerTlsOrFail(name string, cfg *Config) (*tls.Config, sniCertificates) {
+	var sniCerts sniCertificates
+	if cfg.TlsCertFile != "" || cfg.TlsKeyFile != "" {
+		if cfg.TlsCertFile == "" || cfg.TlsKeyFile == "" {
+			logrus.Fatalf("TlsCertFile and TlsKeyFile must be set")
+		}
+		sniCerts = append(sniCerts, buildSniCertificateOrFail("server/"+name, cfg, TlsCertificateConfig{
+			CertFile: cfg.TlsCertFile,
+			KeyFile:  cfg.TlsKeyFile,
+		}))
+	}
+	for i, tc := range cfg.TlsCertificates {
+		if len(tc.ServerNames) == 0 && (i > 0 || len(sniCerts) > 0) {
+			logrus.Fatalf("ServerNames of the TlsCertificates must be set except the first one as the default")
+		}
+		sniCerts = append(sniCerts, buildSniCertificateOrFail(fmt.Sprintf("server/%v/%d", name, i), cfg, tc))
+	}
+	if len(sniCerts) == 0 {
+		logrus.Fatalf("TlsCertFile and TlsKeyFile, or TlsCertificates must be set")
+	}
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	tlsConfig, err := connhelpers.TlsConfigWithHttp2Enabled(tlsConfig)
 	if err != nil {
-		logrus.Fatalf("failed reading TLS server keys: %v", err)
+		logrus.Fatalf("can't configure h2 handling: %v", err)
 	}
-	tlsConfig.MinVersion = tls.VersionTLS12
-	switch cfg.TlsVerifyCert {
-	case false:
-		tlsConfig.ClientAuth = tls.NoClientCert
+	base := tlsConfig.Clone()
+	tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		c := sniCerts.selectFor(hello.ServerName)
+		conf := base.Clone()
+		conf.GetCertificate = c.store.GetCertificate
+		conf.ClientAuth = c.clientAuth
+		if c.clientAuth != tls.NoClientCert {
+			conf.ClientCAs = c.currentClientCAs()
+		}
+		return conf, nil
+	}
+	return tlsConfig, sniCerts
+}
+
+func buildSniCertificateOrFail(name string, cfg *Config, tc TlsCertificateConfig) *sniCertificate {
+	if tc.CertFile == "" || tc.KeyFile == "" {
+		logrus.Fatalf("CertFile and KeyFile of the TlsCertificates must be set")
+	}
+	c := &sniCertificate{serverNames: tc.ServerNames, clientAuth: tls.NoClientCert}
+	clientAuth := tc.ClientAuth
+	if clientAuth == "" {
+		clientAuth = clientAuthNone
+		if cfg.TlsVerifyCert {
+			clientAuth = clientAuthRequire
+		}
+	}
+	switch clientAuth {
+	case clientAuthNone:
+	case clientAuthRequest:
+		c.clientAuth = tls.VerifyClientCertIfGiven
+	case clientAuthRequire:
+		c.clientAuth = tls.RequireAndVerifyClientCert
 	default:
-		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		logrus.Fatalf("invalid ClientAuth %q of the TlsCertificates", tc.ClientAuth)
 	}
+	caFile := ""
+	if c.clientAuth != tls.NoClientCert {
+		caFile = tc.CaFile
+		if caFile == "" {
+			caFile = cfg.TlsCaFile
+		}
+	}
+	var err error
+	c.store, err = certs.NewStore(name, tc.CertFile, tc.KeyFile, caFile)
+	if err != nil {
+		logrus.Fatalf("failed reading TLS server keys: %v", err)
+	}
+	if c.clientAuth != tls.NoClientCert && caFile == "" {
+		c.clientCAs, err = x509.SystemCertPool()
+		if err != nil {
+			logrus.Fatalf("no client CA files specified, fallback to system CA chain failed: %v", err)
+		}
+	}
+	return c
+}
 
-	if tlsConfig.ClientAuth != tls.NoClientCert {
-		if cfg.TlsCaFile != "" {
-			tlsConfig.ClientCAs = x509.NewCertPool()
-			data, err := os.ReadFile(cfg.TlsCaFile)
-			if err != nil {
-				logrus.Fatalf("failed reading client CA file %v: %v", cfg.TlsCaFile, err)
-			}
-			if ok := tlsConfig.ClientCAs.AppendCertsFromPEM(data); !ok {
-				logrus.Fatalf("failed processing client CA file %v", cfg.TlsCaFile)
+// selectSniCertificate returns the certificate of the server name, preferring the exact names to the wildcard ones, nil
+// if none matches.
+func selectSniCertificate(sniCerts []*sniCertificate, serverName string) *sniCertificate {
+	serverName = strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if serverName == "" {
+		return nil
+	}
+	wildcard := ""
+	if i := strings.IndexByte(serverName, '.'); i > 0 {
+		wildcard = "*" + serverName[i:]
+	}
+	var matched *sniCertificate
+	for _, c := range sniCerts {
+		for _, sn := range c.serverNames {
+			sn = strings.ToLower(sn)
+			if sn == serverName {
+				return c
 			}
-		} else {
-			var err error
-			tlsConfig.ClientCAs, err = x509.SystemCertPool()
-			if err != nil {
-				logrus.Fatalf("no client CA files specified, fallback to system CA chain failed: %v", err)
+			if matched == nil && wildcard != "" && sn == wildcard {
+				matched = c
 			}
 		}
+	}
+	return matched
+}
 
+// selectFor returns the certificate of the server name, or the default one if none matches.
+func (sniCerts sniCertificates) selectFor(serverName string) *sniCertificate {
+	if c := selectSniCertificate(sniCerts, serverName); c != nil {
+		return c
 	}
-	tlsConfig, err = connhelpers.TlsConfigWithHttp2Enabled(tlsConfig)
-	if err != nil {
-		logrus.Fatalf("can't configure h2 handling: %v", err)
+	return sniCerts[0]
+}
+
+// stores returns the stores of the certificate files, to be reloaded.
+func (sniCerts sniCertificates) stores() []*certs.Store {
+	stores := make([]*certs.Store, 0, len(sniCerts))
+	for _, c := range sniCerts {
+		stores = append(stores, c.store)
 	}
-	return tlsConfig
+	return stores
+}
+
+// authorityMatches reports whether the authority of a request selects the same certificate, so as the client
+// authentication, as the SNI negotiated by its connection. As the calls are routed by the methods only, a client could
+// otherwise pass the client authentication of a host by the SNI, and call the methods of another one by the authority.
+func (sniCerts sniCertificates) authorityMatches(serverName string, authority string) bool {
+	host := authority
+	if h, _, err := net.SplitHostPort(authority); err == nil {
+		host = h
+	}
+	return sniCerts.selectFor(serverName) == sniCerts.selectFor(host)
+}
+
+// httpHandler rejects the requests whose authority mismatches the SNI as 421 Misdirected Request. The grpc ones, including
+// grpc-web, are left to the interceptors to be rejected with the grpc status.
+func (sniCerts sniCertificates) httpHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") &&
+			!sniCerts.authorityMatches(r.TLS.ServerName, r.Host) {
+			http.Error(w, fmt.Sprintf("host %v is not served on the connection to %v", r.Host, r.TLS.ServerName), http.StatusMisdirectedRequest)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// checkAuthority returns PERMISSION_DENIED if the authority of the call mismatches the SNI of its connection.
+func (sniCerts sniCertificates) checkAuthority(ctx context.Context) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	authority := md.Get(":authority")
+	if len(authority) == 0 || sniCerts.authorityMatches(info.State.ServerName, authority[0]) {
+		return nil
+	}
+	return status.Errorf(codes.PermissionDenied, "authority %v is not served on the connection to %v", authority[0], info.State.ServerName)
+}
+
+func (sniCerts sniCertificates) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := sniCerts.checkAuthority(ctx); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (sniCerts sniCertificates) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := sniCerts.checkAuthority(ss.Context()); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// tlsConnCredentials completes the handshakes of the connections accepted by the TLS listener, exposing their TLS states
+// to the grpc server, e.g. the SNI and the client certificates of the calls.
+type tlsConnCredentials struct{}
+
+func (tlsConnCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return conn, nil, nil
+	}
+	if err := tc.Handshake(); err != nil {
+		return nil, nil, err
+	}
+	return conn, credentials.TLSInfo{State: tc.ConnectionState(), CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity}}, nil
+}
+
+func (tlsConnCredentials) ClientHandshake(context.Context, string, net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("client handshake is not supported")
+}
+
+func (tlsConnCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "tls"}
+}
+
+func (c tlsConnCredentials) Clone() credentials.TransportCredentials {
+	return c
+}
+
+func (tlsConnCredentials) OverrideServerName(string) error {
+	return nil
 }
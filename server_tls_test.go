@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedCert writes a self-signed certificate of the host names and its key, returning the file paths.
+func writeSelfSignedCert(t *testing.T, dir, cn string, hosts ...string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              hosts,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	certFile, keyFile := filepath.Join(dir, cn+".pem"), filepath.Join(dir, cn+".key.pem")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestServerTlsSni(t *testing.T) {
+	dir := t.TempDir()
+	defaultCert, defaultKey := writeSelfSignedCert(t, dir, "default", "default.example")
+	apiCert, apiKey := writeSelfSignedCert(t, dir, "api", "api.example.com")
+	partnersCert, partnersKey := writeSelfSignedCert(t, dir, "partners", "*.partners.example.com")
+	clientCert, clientKey := writeSelfSignedCert(t, dir, "client")
+	cfg := &Config{
+		TlsCertFile: defaultCert,
+		TlsKeyFile:  defaultKey,
+		TlsCertificates: []TlsCertificateConfig{
+			{ServerNames: []string{"api.example.com"}, CertFile: apiCert, KeyFile: apiKey},
+			{ServerNames: []string{"*.partners.example.com"}, CertFile: partnersCert, KeyFile: partnersKey,
+				ClientAuth: clientAuthRequire, CaFile: clientCert},
+		},
+	}
+	tlsConfig, sniCerts := buildServerTlsOrFail("test", cfg)
+	if len(sniCerts.stores()) != 3 {
+		t.Fatalf("expected 3 stores, but got %v", len(sniCerts.stores()))
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = lis.Close() }()
+	tlsLis := tls.NewListener(lis, tlsConfig)
+	go func() {
+		for {
+			conn, err := tlsLis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = conn.(*tls.Conn).Handshake()
+				_, _ = conn.Write([]byte("ok"))
+				_ = conn.Close()
+			}()
+		}
+	}()
+	client, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handshake := func(serverName string, withClientCert bool) (string, error) {
+		c := &tls.Config{ServerName: serverName, InsecureSkipVerify: true}
+		if withClientCert {
+			c.Certificates = []tls.Certificate{client}
+		}
+		conn, err := tls.Dial("tcp", lis.Addr().String(), c)
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = conn.Close() }()
+		// the client certificate is verified by the server after the client finishes the handshake of TLS 1.3.
+		if _, err = conn.Read(make([]byte, 2)); err != nil {
+			return "", err
+		}
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+	}
+	cases := []struct {
+		serverName string
+		expected   string
+	}{
+		{"api.example.com", "api"},
+		{"API.example.com", "api"},
+		{"unknown.example.com", "default"},
+		{"", "default"},
+	}
+	for _, c := range cases {
+		if cn, err := handshake(c.serverName, false); err != nil || cn != c.expected {
+			t.Errorf("%q: expected %v, but got %v, %v", c.serverName, c.expected, cn, err)
+		}
+	}
+	if cn, err := handshake("a.partners.example.com", true); err != nil || cn != "partners" {
+		t.Errorf("expected partners by wildcard, but got %v, %v", cn, err)
+	}
+	if _, err = handshake("a.partners.example.com", false); err == nil {
+		t.Errorf("expected error without client certificate, but got nil")
+	}
+	if cn, err := handshake("a.b.partners.example.com", false); err != nil || cn != "default" {
+		t.Errorf("expected default for nested subdomain, but got %v, %v", cn, err)
+	}
+}
+
+func TestSniAuthorityMismatch(t *testing.T) {
+	dir := t.TempDir()
+	apiCert, apiKey := writeSelfSignedCert(t, dir, "api", "api.example.com")
+	partnersCert, partnersKey := writeSelfSignedCert(t, dir, "partners", "*.partners.example.com")
+	cfg := &Config{
+		TlsCertificates: []TlsCertificateConfig{
+			{CertFile: apiCert, KeyFile: apiKey},
+			{ServerNames: []string{"*.partners.example.com"}, CertFile: partnersCert, KeyFile: partnersKey,
+				ClientAuth: clientAuthRequire, CaFile: partnersCert},
+		},
+	}
+	tlsConfig, sniCerts := buildServerTlsOrFail("test", cfg)
+	clientTls := &tls.Config{ServerName: "api.example.com", InsecureSkipVerify: true}
+
+	// the grpc calls authorized by the SNI of api.example.com must not reach the partners by the authority.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.Creds(tlsConnCredentials{}), grpc.ChainUnaryInterceptor(sniCerts.UnaryServerInterceptor()))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(tls.NewListener(lis, tlsConfig)) }()
+	defer srv.Stop()
+	check := func(authority string) error {
+		// the TLS is dialed by the client itself, as grpc would send the authority as the SNI.
+		conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithAuthority(authority),
+			grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+				return (&tls.Dialer{Config: clientTls}).DialContext(ctx, "tcp", addr)
+			}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = conn.Close() }()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		return err
+	}
+	if err = check("api.example.com:443"); err != nil {
+		t.Errorf("expected the call of the matched authority succeeded, but got %v", err)
+	}
+	if err = check("a.partners.example.com"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied of the mismatched authority, but got %v", err)
+	}
+
+	httpSrv := httptest.NewUnstartedServer(sniCerts.httpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	httpSrv.TLS = tlsConfig
+	httpSrv.StartTLS()
+	defer httpSrv.Close()
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTls}}
+	for host, expected := range map[string]int{"api.example.com": http.StatusOK, "a.partners.example.com": http.StatusMisdirectedRequest} {
+		req, _ := http.NewRequest(http.MethodGet, httpSrv.URL, nil)
+		req.Host = host
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Errorf("%v: expected status %v, but got %v", host, expected, resp.StatusCode)
+		}
+	}
+}
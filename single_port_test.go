@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	reverse_proxy "grpc-gateway-x/reverse-proxy"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSinglePortServesGrpcAndHttp(t *testing.T) {
+	rp, err := reverse_proxy.NewReverseProxy(
+		reverse_proxy.WithBackendAddr(startEchoBackend(t)),
+		reverse_proxy.WithBackendInsecure(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{SinglePort: true, HttpPort: 8080, EnableMetrics: true, AllowAllOrigins: true, GrpcMaxMessageSize: 4194304}
+	listeners, err := cfg.ListenerConfigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := listeners[0]
+	lc.Init()
+	srv := httptest.NewServer(buildHttpHandler(lc, rp, buildGrpcProxyServer(logrus.NewEntry(logrus.New()), &lc.Config, rp, listenerServices{}), listenerServices{}, "test"))
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, strings.TrimPrefix(srv.URL, "http://"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, "/test.Echo/Chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = stream.SendMsg(wrapperspb.String("over h2c")); err != nil {
+		t.Fatal(err)
+	}
+	got := &wrapperspb.StringValue{}
+	if err = stream.RecvMsg(got); err != nil {
+		t.Fatal(err)
+	}
+	if got.GetValue() != "over h2c" {
+		t.Errorf("echo = %q", got.GetValue())
+	}
+	_ = stream.CloseSend()
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /metrics status = %d", resp.StatusCode)
+	}
+}
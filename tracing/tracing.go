@@ -0,0 +1,133 @@
+// Package tracing sets up the OpenTelemetry tracing of the gateway, and instruments the grpc servers and the HTTP
+// handlers. The spans are exported by OTLP over grpc, and the trace context is propagated by the W3C traceparent and
+// tracestate headers, optionally with the baggage and the B3 ones.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"math"
+)
+
+// InstrumentationName the name of the tracer of the gateway.
+const InstrumentationName = "grpc-gateway-x"
+
+const (
+	// PropagatorTraceContext the W3C traceparent and tracestate headers.
+	PropagatorTraceContext = "tracecontext"
+	// PropagatorBaggage the W3C baggage header.
+	PropagatorBaggage = "baggage"
+	// PropagatorB3 the single b3 header, which is taken along with the multiple x-b3-* headers.
+	PropagatorB3 = "b3"
+	// PropagatorB3Multi the multiple x-b3-* headers.
+	PropagatorB3Multi = "b3multi"
+)
+
+// Config the settings of the tracing.
+type Config struct {
+	// Enabled whether to trace the calls.
+	Enabled bool
+	// Endpoint the OTLP grpc endpoint of the collector, e.g. "otel-collector:4317". default is the one of the
+	// OTEL_EXPORTER_OTLP_ENDPOINT environment variable, or "localhost:4317".
+	Endpoint string
+	// Insecure whether to export without TLS.
+	Insecure bool
+	// ServiceName the service.name of the spans, default is "grpc-gateway-x".
+	ServiceName string
+	// SampleRatio the ratio of the traces started by the gateway to be sampled, 0 to 1, 0 samples none. the ones of the
+	// sampled parents are always sampled. default is 1.
+	SampleRatio float64
+	// Propagators the formats of the trace context taken from the clients and sent to the backends, any of
+	// "tracecontext", "baggage", "b3" and "b3multi". default is ["tracecontext", "baggage"].
+	Propagators []string
+}
+
+// Tracer returns the tracer of the gateway by the global provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(InstrumentationName)
+}
+
+// Setup sets the global tracer provider exporting by OTLP and the propagators, and returns the func to flush and shut
+// them down.
+func Setup(cfg Config) (func(context.Context) error, error) {
+	var opts []otlptracegrpc.Option
+	if cfg.Endpoint != "" {
+		opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	// the exporter connects lazily, so the gateway starts regardless of the collector being reachable.
+	exporter, err := otlptracegrpc.New(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating OTLP exporter: %v", err)
+	}
+	return SetupWithExporter(cfg, sdktrace.WithBatcher(exporter))
+}
+
+// SetupWithExporter sets the global tracer provider of the span processor option, e.g. sdktrace.WithSyncer of an
+// in-memory exporter in the tests, and the propagators.
+func SetupWithExporter(cfg Config, processor sdktrace.TracerProviderOption) (func(context.Context) error, error) {
+	prop, err := newPropagator(cfg.Propagators)
+	if err != nil {
+		return nil, err
+	}
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = InstrumentationName
+	}
+	// the ratio of 0 samples none of the traces started by the gateway, the default of 1 is of the config.
+	ratio := math.Min(math.Max(cfg.SampleRatio, 0), 1)
+	tp := sdktrace.NewTracerProvider(
+		processor,
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(prop)
+	return tp.Shutdown, nil
+}
+
+// SetupInMemory sets the global tracer provider recording the spans in memory, and the default propagators, for the
+// tests. The returned func restores the previous global provider and propagators.
+func SetupInMemory() (*tracetest.InMemoryExporter, func()) {
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	exporter := tracetest.NewInMemoryExporter()
+	shutdown, _ := SetupWithExporter(Config{Enabled: true, SampleRatio: 1}, sdktrace.WithSyncer(exporter))
+	return exporter, func() {
+		_ = shutdown(context.Background())
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	}
+}
+
+func newPropagator(names []string) (propagation.TextMapPropagator, error) {
+	if len(names) == 0 {
+		names = []string{PropagatorTraceContext, PropagatorBaggage}
+	}
+	var props []propagation.TextMapPropagator
+	for _, name := range names {
+		switch name {
+		case PropagatorTraceContext:
+			props = append(props, propagation.TraceContext{})
+		case PropagatorBaggage:
+			props = append(props, propagation.Baggage{})
+		case PropagatorB3:
+			props = append(props, b3.New(b3.WithInjectEncoding(b3.B3SingleHeader)))
+		case PropagatorB3Multi:
+			props = append(props, b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)))
+		default:
+			return nil, fmt.Errorf("unknown propagator %q", name)
+		}
+	}
+	return propagation.NewCompositeTextMapPropagator(props...), nil
+}
@@ -0,0 +1,112 @@
+package tracing
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const clientTraceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	exporter, restore := SetupInMemory()
+	defer restore()
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("traceparent", clientTraceparent))
+	info := &grpc.UnaryServerInfo{FullMethod: "/a.B/C"}
+	_, _ = UnaryServerInterceptor()(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(grpcCodes.NotFound, "not found")
+	})
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, but got %v", len(spans))
+	}
+	s := spans[0]
+	if s.Name != "a.B/C" {
+		t.Errorf("expected span a.B/C, but got %v", s.Name)
+	}
+	if s.Parent.TraceID().String() != "0af7651916cd43dd8448eb211c80319c" || s.Parent.SpanID().String() != "b7ad6b7169203331" {
+		t.Errorf("expected the span to continue the client trace, but got parent %v", s.Parent)
+	}
+	if s.Status.Code != codes.Error {
+		t.Errorf("expected error status, but got %v", s.Status)
+	}
+}
+
+func TestInjectMetadata(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	defer func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	}()
+	if _, err := SetupWithExporter(Config{SampleRatio: 1, Propagators: []string{PropagatorTraceContext, PropagatorB3}}, sdktrace.WithSyncer(exporter)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, span := Tracer().Start(context.Background(), "call")
+	defer span.End()
+	md := metadata.Pairs("traceparent", clientTraceparent, "b3", "stale")
+	InjectMetadata(ctx, md)
+	if got := md.Get("traceparent"); len(got) != 1 || got[0] == clientTraceparent {
+		t.Errorf("expected the traceparent to be replaced, but got %v", got)
+	}
+	expectedB3 := span.SpanContext().TraceID().String() + "-" + span.SpanContext().SpanID().String() + "-1"
+	if got := md.Get("b3"); len(got) != 1 || got[0] != expectedB3 {
+		t.Errorf("expected b3 %v, but got %v", expectedB3, got)
+	}
+
+	if _, err := SetupWithExporter(Config{Propagators: []string{"nope"}}, sdktrace.WithSyncer(exporter)); err == nil {
+		t.Errorf("expected error of unknown propagator, but got nil")
+	}
+}
+
+func TestSampleRatio(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	defer func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	}()
+	if _, err := SetupWithExporter(Config{SampleRatio: 0}, sdktrace.WithSyncer(exporter)); err != nil {
+		t.Fatal(err)
+	}
+	_, span := Tracer().Start(context.Background(), "call")
+	span.End()
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Errorf("expected no span sampled, but got %v", len(spans))
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	exporter, restore := SetupInMemory()
+	defer restore()
+	h := HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.(http.Flusher).Flush()
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/v1/invoke/a.B/C", nil)
+	r.Header.Set("traceparent", clientTraceparent)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if !rec.Flushed {
+		t.Errorf("expected the response to be flushed")
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, but got %v", len(spans))
+	}
+	if spans[0].Parent.SpanID().String() != "b7ad6b7169203331" {
+		t.Errorf("expected the span to continue the client trace, but got parent %v", spans[0].Parent)
+	}
+	if spans[0].Status.Code != codes.Error {
+		t.Errorf("expected error status, but got %v", spans[0].Status)
+	}
+}
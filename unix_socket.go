@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mwitkow/go-conntrack"
+	"github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const unixSocketScheme = "unix://"
+
+// defaultUnixSocketMode the file permission of the unix sockets if UnixSocketMode is not set.
+const defaultUnixSocketMode os.FileMode = 0660
+
+// unixSocketPath returns the socket file path of the address in the form of "unix:///path/to/file.sock",
+// or the plain path as is.
+func unixSocketPath(address string) string {
+	return strings.TrimPrefix(address, unixSocketScheme)
+}
+
+// buildUnixListenerOrFail listens on the unix socket, with the file permission and owner per the configuration.
+func buildUnixListenerOrFail(name string, address string, cfg *Config) net.Listener {
+	path := unixSocketPath(address)
+	mode := defaultUnixSocketMode
+	if cfg.UnixSocketMode != "" {
+		m, err := strconv.ParseUint(cfg.UnixSocketMode, 8, 32)
+		if err != nil {
+			logrus.Fatalf("invalid UnixSocketMode %v: %v", cfg.UnixSocketMode, err)
+		}
+		mode = os.FileMode(m)
+	}
+	listener, err := listenUnix(path, mode, cfg.UnixSocketOwner)
+	if err != nil {
+		logrus.Fatalf("failed listening for '%v' on %v: %v", name, address, err)
+	}
+	return conntrack.NewListener(listener,
+		conntrack.TrackWithName(name),
+		conntrack.TrackWithTracing(),
+	)
+}
+
+// listenUnix listens on the unix socket file, removing the stale one left by a process not shutdown gracefully.
+// The socket is created in a private directory and moved to the path once its mode and owner are set, so that it is
+// never reachable with the wider permission. The socket file is removed when the listener is closed.
+func listenUnix(path string, mode os.FileMode, owner string) (net.Listener, error) {
+	if err := removeStaleUnixSocket(path); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".sock")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	tmpPath := filepath.Join(dir, filepath.Base(path))
+	listener, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	// the socket is unlinked by its final path on close, rather than the one it's bound to.
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err = os.Chmod(tmpPath, mode); err == nil && owner != "" {
+		err = chownUnixSocket(tmpPath, owner)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return &unixSocketListener{Listener: listener, path: path}, nil
+}
+
+// unixSocketListener removes the socket file of the path on close.
+type unixSocketListener struct {
+	net.Listener
+	path string
+	once sync.Once
+}
+
+func (l *unixSocketListener) Close() error {
+	err := l.Listener.Close()
+	l.once.Do(func() { _ = os.Remove(l.path) })
+	return err
+}
+
+// removeStaleUnixSocket removes the socket file if nothing is listening on it. It fails if the file is not a socket,
+// or the socket is in use.
+func removeStaleUnixSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%v exists and is not a unix socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%v is in use by another process", path)
+	}
+	logrus.Infof("removing stale unix socket %v", path)
+	return os.Remove(path)
+}
+
+// chownUnixSocket changes the owner of the socket file per the owner in the form of "user:group", where both of them
+// are names or numeric ids, and either of them may be omitted.
+func chownUnixSocket(path string, owner string) error {
+	uid, gid := -1, -1
+	userName, groupName, _ := strings.Cut(owner, ":")
+	if userName != "" {
+		id, err := strconv.Atoi(userName)
+		if err != nil {
+			u, err := user.Lookup(userName)
+			if err != nil {
+				return err
+			}
+			if id, err = strconv.Atoi(u.Uid); err != nil {
+				return err
+			}
+		}
+		uid = id
+	}
+	if groupName != "" {
+		id, err := strconv.Atoi(groupName)
+		if err != nil {
+			g, err := user.LookupGroup(groupName)
+			if err != nil {
+				return err
+			}
+			if id, err = strconv.Atoi(g.Gid); err != nil {
+				return err
+			}
+		}
+		gid = id
+	}
+	return os.Chown(path, uid, gid)
+}
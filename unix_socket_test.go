@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	reverse_proxy "grpc-gateway-x/reverse-proxy"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stale.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	listener, err := listenUnix(path, 0600, "")
+	if err != nil {
+		t.Fatalf("stale socket not removed: %v", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, but got %v", fi.Mode().Perm())
+	}
+	// the socket is created in a private directory, which is removed once the socket is moved to the path.
+	if entries, err := os.ReadDir(filepath.Dir(path)); err != nil || len(entries) != 1 {
+		t.Errorf("expected the socket only in the directory, but got %v, %v", entries, err)
+	}
+	if _, err = listenUnix(path, 0600, ""); err == nil {
+		t.Error("expected error listening on the socket in use")
+	}
+	_ = listener.Close()
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected socket removed on close, but got %v", err)
+	}
+
+	regular := filepath.Join(t.TempDir(), "regular")
+	if err = os.WriteFile(regular, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = listenUnix(regular, 0600, ""); err == nil {
+		t.Error("expected error listening on a regular file")
+	}
+}
+
+func TestUnixSocketServingAndBackend(t *testing.T) {
+	dir := t.TempDir()
+	backendListener, err := net.Listen("unix", filepath.Join(dir, "backend.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveEchoBackend(t, backendListener)
+	rp, err := reverse_proxy.NewReverseProxy(
+		reverse_proxy.WithBackendAddr("unix://"+backendListener.Addr().String()),
+		reverse_proxy.WithBackendInsecure(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{GrpcUnixSocket: "unix://" + filepath.Join(dir, "grpc.sock"), GrpcMaxMessageSize: 4194304}
+	srv := buildGrpcProxyServer(logrus.NewEntry(logrus.New()), cfg, rp, listenerServices{})
+	go func() { _ = srv.Serve(buildServingListenerOrFail("grpc", cfg.GrpcUnixSocket, cfg)) }()
+	t.Cleanup(srv.Stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, cfg.GrpcUnixSocket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, "/test.Echo/Chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = stream.SendMsg(wrapperspb.String("over unix")); err != nil {
+		t.Fatal(err)
+	}
+	got := &wrapperspb.StringValue{}
+	if err = stream.RecvMsg(got); err != nil {
+		t.Fatal(err)
+	}
+	if got.GetValue() != "over unix" {
+		t.Errorf("echo = %q", got.GetValue())
+	}
+	_ = stream.CloseSend()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	reverse_proxy "grpc-gateway-x/reverse-proxy"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"nhooyr.io/websocket"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startEchoBackend starts a grpc server with the bidi method /test.Echo/Chat echoing the google.protobuf.StringValue messages.
+func startEchoBackend(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveEchoBackend(t, lis)
+	return lis.Addr().String()
+}
+
+func serveEchoBackend(t *testing.T, lis net.Listener) {
+	srv := grpc.NewServer()
+	srv.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "test.Echo",
+		HandlerType: (*interface{})(nil),
+		Streams: []grpc.StreamDesc{{
+			StreamName:    "Chat",
+			ServerStreams: true,
+			ClientStreams: true,
+			Handler: func(_ interface{}, stream grpc.ServerStream) error {
+				for {
+					m := &wrapperspb.StringValue{}
+					if err := stream.RecvMsg(m); err == io.EOF {
+						return nil
+					} else if err != nil {
+						return err
+					}
+					if err := stream.SendMsg(m); err != nil {
+						return err
+					}
+				}
+			},
+		}},
+	}, struct{}{})
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+}
+
+func startWebsocketProxy(t *testing.T, cfg *Config) *httptest.Server {
+	rp, err := reverse_proxy.NewReverseProxy(
+		reverse_proxy.WithBackendAddr(startEchoBackend(t)),
+		reverse_proxy.WithBackendInsecure(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.GrpcMaxMessageSize = 4194304
+	cfg.EnableWebsockets = true
+	cfg.Init()
+	srv := httptest.NewServer(buildGrpcWebServer(buildGrpcProxyServer(logrus.NewEntry(logrus.New()), cfg, rp, listenerServices{}), cfg))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func grpcFrame(flags byte, payload []byte) []byte {
+	frame := make([]byte, 5, 5+len(payload))
+	frame[0] = flags
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	return append(frame, payload...)
+}
+
+func TestWebsocketBidiStreaming(t *testing.T) {
+	srv := startWebsocketProxy(t, &Config{AllowedOrigins: []string{"http://allowed.example"}})
+	ctx, cls := context.WithTimeout(context.Background(), time.Second*10)
+	defer cls()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/test.Echo/Chat"
+
+	_, _, err := websocket.Dial(ctx, wsURL, &websocket.DialOptions{
+		Subprotocols: []string{"grpc-websockets"},
+		HTTPHeader:   http.Header{"Origin": []string{"http://evil.example"}},
+	})
+	if err == nil {
+		t.Error("expected the websocket from the disallowed origin to be rejected")
+	}
+
+	conn, _, err := websocket.Dial(ctx, wsURL, &websocket.DialOptions{
+		Subprotocols: []string{"grpc-websockets"},
+		HTTPHeader:   http.Header{"Origin": []string{"http://allowed.example"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close(websocket.StatusNormalClosure, "") }()
+	if err = conn.Write(ctx, websocket.MessageBinary, []byte("content-type: application/grpc-web+proto\r\nx-grpc-web: 1\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	words := []string{"hello", "world"}
+	for _, w := range words {
+		b, _ := proto.Marshal(wrapperspb.String(w))
+		if err = conn.Write(ctx, websocket.MessageBinary, append([]byte{0}, grpcFrame(0, b)...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the client half-close.
+	if err = conn.Write(ctx, websocket.MessageBinary, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	var received []string
+	var trailer string
+	buf := &bytes.Buffer{}
+	for trailer == "" {
+		_, b, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("failed reading, received %v: %v", received, err)
+		}
+		buf.Write(b)
+		for buf.Len() >= 5 {
+			n := int(binary.BigEndian.Uint32(buf.Bytes()[1:5]))
+			if buf.Len() < 5+n {
+				break
+			}
+			frame := buf.Next(5 + n)
+			if frame[0]&0x80 != 0 {
+				if strings.Contains(string(frame[5:]), "grpc-status") {
+					trailer = string(frame[5:])
+				}
+				continue
+			}
+			m := &wrapperspb.StringValue{}
+			if err = proto.Unmarshal(frame[5:], m); err != nil {
+				t.Fatal(err)
+			}
+			received = append(received, m.Value)
+		}
+	}
+	if strings.Join(received, ",") != strings.Join(words, ",") {
+		t.Errorf("expected %v echoed, but got %v", words, received)
+	}
+	if !strings.Contains(trailer, "grpc-status: 0") {
+		t.Errorf("unexpected trailer %q", trailer)
+	}
+}